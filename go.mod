@@ -2,10 +2,11 @@ module goodmock
 
 go 1.25.6
 
-require github.com/valyala/fasthttp v1.69.0
-
 require (
-	github.com/andybalholm/brotli v1.2.0 // indirect
-	github.com/klauspost/compress v1.18.4 // indirect
-	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.4
+	github.com/valyala/fasthttp v1.69.0
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require github.com/valyala/bytebufferpool v1.0.0 // indirect
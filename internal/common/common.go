@@ -1,10 +1,18 @@
 package common
 
 import (
+	"crypto/x509"
+	"goodmock/internal/proxy"
+	"goodmock/internal/server"
+	"goodmock/internal/types"
 	"log"
+	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
 )
 
 func GetPort() int {
@@ -32,6 +40,298 @@ func SortArrayMembers() bool {
 	return os.Getenv("SORT_ARRAY_MEMBERS") != ""
 }
 
+// FilesDir returns the directory response bodyFileName paths are resolved against,
+// from FILES_DIR (default: server.DefaultFilesDir).
+func FilesDir() string {
+	if dir := os.Getenv("FILES_DIR"); dir != "" {
+		return dir
+	}
+	return server.DefaultFilesDir
+}
+
+// RequestJournalSize returns how many requests the replay journal keeps, from
+// REQUEST_JOURNAL_SIZE (default: server.DefaultJournalSize). 0 disables the journal.
+func RequestJournalSize() int {
+	if v := os.Getenv("REQUEST_JOURNAL_SIZE"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 0 {
+			log.Fatalf("Invalid REQUEST_JOURNAL_SIZE value: %s", v)
+		}
+		return size
+	}
+	return server.DefaultJournalSize
+}
+
+// DefaultMaxRequestBodySize is the largest request body accepted when
+// MAX_REQUEST_BODY_BYTES is unset: fasthttp's own default of 4 MiB.
+const DefaultMaxRequestBodySize = fasthttp.DefaultMaxRequestBodySize
+
+// MaxRequestBodySize returns the largest request body the server accepts, from
+// MAX_REQUEST_BODY_BYTES (default: DefaultMaxRequestBodySize). Larger requests get a 413.
+func MaxRequestBodySize() int {
+	if v := os.Getenv("MAX_REQUEST_BODY_BYTES"); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			log.Fatalf("Invalid MAX_REQUEST_BODY_BYTES value: %s", v)
+		}
+		return size
+	}
+	return DefaultMaxRequestBodySize
+}
+
+// WatchMappings returns true if replay should reload MAPPINGS_DIR whenever its
+// mapping files change, from WATCH_MAPPINGS.
+func WatchMappings() bool {
+	return os.Getenv("WATCH_MAPPINGS") != ""
+}
+
+// TLSFiles returns the certificate and key file to serve HTTPS with, from
+// TLS_CERT_FILE and TLS_KEY_FILE. Both empty means plain HTTP; setting only
+// one of them is a configuration error.
+func TLSFiles() (certFile, keyFile string) {
+	certFile, keyFile = os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		log.Fatalf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	return certFile, keyFile
+}
+
+// RecordStubsFirst returns true if record mode should serve requests matching a
+// loaded stub directly and only proxy and record the rest.
+func RecordStubsFirst() bool {
+	return os.Getenv("RECORD_STUBS_FIRST") != ""
+}
+
+// RecordingsDir returns the directory snapshots with "persist": true write mapping
+// files to, from RECORDINGS_DIR (default: ./mappings). Record mode only.
+func RecordingsDir() string {
+	if dir := os.Getenv("RECORDINGS_DIR"); dir != "" {
+		return dir
+	}
+	return "./mappings"
+}
+
+// ExtractBodiesOver returns the size in bytes above which record mode writes response
+// bodies to FILES_DIR instead of inlining them, from EXTRACT_BODIES_OVER. 0 (the
+// default) keeps every body inline.
+func ExtractBodiesOver() int {
+	if v := os.Getenv("EXTRACT_BODIES_OVER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid EXTRACT_BODIES_OVER value: %s", v)
+		}
+		return n
+	}
+	return 0
+}
+
+// ResponseRewrites returns the find/replace rules record mode applies to text and
+// JSON response bodies, from RESPONSE_REWRITE: comma-separated find=>replace pairs,
+// e.g. "https://staging.example.com=>http://localhost:8080". Empty by default.
+func ResponseRewrites() []types.RewriteRule {
+	var rules []types.RewriteRule
+	if env := os.Getenv("RESPONSE_REWRITE"); env != "" {
+		for _, pair := range strings.Split(env, ",") {
+			find, replace, ok := strings.Cut(strings.TrimSpace(pair), "=>")
+			if !ok || find == "" {
+				log.Fatalf("Invalid RESPONSE_REWRITE rule: %q (expected find=>replace)", pair)
+			}
+			rules = append(rules, types.RewriteRule{Find: find, Replace: replace})
+		}
+	}
+	return rules
+}
+
+// GzipResponsesOver returns the size in bytes above which replay gzips response
+// bodies for clients sending Accept-Encoding: gzip, from GZIP_RESPONSES_OVER. 0 (the
+// default) only compresses stubs with "gzip": true.
+func GzipResponsesOver() int {
+	if v := os.Getenv("GZIP_RESPONSES_OVER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid GZIP_RESPONSES_OVER value: %s", v)
+		}
+		return n
+	}
+	return 0
+}
+
+// DefaultResponse returns the response replay serves when no stub matches, from
+// DEFAULT_STATUS and DEFAULT_BODY, or nil if neither is set. The status defaults
+// to 404 when only a body is given.
+func DefaultResponse() *types.Response {
+	status, body := os.Getenv("DEFAULT_STATUS"), os.Getenv("DEFAULT_BODY")
+	if status == "" && body == "" {
+		return nil
+	}
+	resp := &types.Response{Status: 404, Body: body}
+	if status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 599 {
+			log.Fatalf("Invalid DEFAULT_STATUS value: %s", status)
+		}
+		resp.Status = code
+	}
+	return resp
+}
+
+// JSONLogFormat reports whether LOG_FORMAT asks for JSON log events instead of the
+// default text format.
+func JSONLogFormat() bool {
+	switch v := os.Getenv("LOG_FORMAT"); strings.ToLower(v) {
+	case "", "text":
+		return false
+	case "json":
+		return true
+	default:
+		log.Fatalf("Invalid LOG_FORMAT value: %s (must be text or json)", v)
+		return false
+	}
+}
+
+// JSONNumberTolerance returns how far apart JSON numbers in equalToJson bodies may
+// be and still match, from JSON_NUMBER_TOLERANCE. 0 (the default) requires exact
+// equality; numerically equal forms such as 5 and 5.0 always match.
+func JSONNumberTolerance() float64 {
+	if v := os.Getenv("JSON_NUMBER_TOLERANCE"); v != "" {
+		epsilon, err := strconv.ParseFloat(v, 64)
+		if err != nil || epsilon < 0 || math.IsNaN(epsilon) || math.IsInf(epsilon, 0) {
+			log.Fatalf("Invalid JSON_NUMBER_TOLERANCE value: %s", v)
+		}
+		return epsilon
+	}
+	return 0
+}
+
+// NormalizeTrailingSlash reports whether url and urlPath matchers should ignore a
+// trailing slash on the request path, from NORMALIZE_TRAILING_SLASH (any value enables).
+func NormalizeTrailingSlash() bool {
+	return os.Getenv("NORMALIZE_TRAILING_SLASH") != ""
+}
+
+// Diagnostics reports whether responses should carry X-GoodMock-* headers naming
+// the stub that served them, from DIAGNOSTICS (any value enables).
+func Diagnostics() bool {
+	return os.Getenv("DIAGNOSTICS") != ""
+}
+
+// RequestLogFile returns the file replay mode appends a JSON line per request to,
+// from REQUEST_LOG_FILE. Empty disables the log.
+func RequestLogFile() string {
+	return os.Getenv("REQUEST_LOG_FILE")
+}
+
+// AdminToken returns the token admin API requests must present, from ADMIN_TOKEN.
+// Empty leaves the admin API open.
+func AdminToken() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// StrictMappings reports whether startup should fail when a loaded mapping is shadowed
+// by another, from STRICT_MAPPINGS (any value enables).
+func StrictMappings() bool {
+	return os.Getenv("STRICT_MAPPINGS") != ""
+}
+
+// CaseInsensitiveQueryNames reports whether query parameter names should match
+// regardless of case, from QUERY_NAMES_IGNORE_CASE (any value enables).
+func CaseInsensitiveQueryNames() bool {
+	return os.Getenv("QUERY_NAMES_IGNORE_CASE") != ""
+}
+
+// ServerTuning reads the fasthttp.Server connection settings: CONCURRENCY (maximum
+// concurrent connections), READ_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT (Go durations
+// such as 5s or 500ms) and DISABLE_KEEPALIVE (any value enables). Unset values keep
+// fasthttp's defaults.
+func ServerTuning() server.Tuning {
+	var tuning server.Tuning
+	if v := os.Getenv("CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid CONCURRENCY value: %s", v)
+		}
+		tuning.Concurrency = n
+	}
+	for _, timeout := range []struct {
+		name string
+		dst  *time.Duration
+	}{{"READ_TIMEOUT", &tuning.ReadTimeout}, {"WRITE_TIMEOUT", &tuning.WriteTimeout}, {"IDLE_TIMEOUT", &tuning.IdleTimeout}} {
+		if v := os.Getenv(timeout.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				log.Fatalf("Invalid %s value: %s (expected a duration such as 5s)", timeout.name, v)
+			}
+			*timeout.dst = d
+		}
+	}
+	tuning.DisableKeepalive = os.Getenv("DISABLE_KEEPALIVE") != ""
+	return tuning
+}
+
+// ProxyConfig returns the upstream client settings: PROXY_TIMEOUT_MS bounds each
+// attempt (0, the default, waits indefinitely) and PROXY_RETRIES is how many extra
+// attempts idempotent requests get after a failure. Upstream TLS verification is
+// disabled by PROXY_INSECURE_SKIP_VERIFY, and PROXY_CA_FILE adds a PEM bundle of
+// CAs to trust on top of the system ones. PROXY_PRESERVE_HOST forwards the client's
+// Host header and PROXY_HOST_OVERRIDE sends a fixed one. PROXY_FOLLOW_REDIRECTS
+// follows up to that many redirect hops instead of returning the redirect.
+func ProxyConfig() proxy.Config {
+	var config proxy.Config
+	if v := os.Getenv("PROXY_TIMEOUT_MS"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			log.Fatalf("Invalid PROXY_TIMEOUT_MS value: %s", v)
+		}
+		config.Timeout = time.Duration(ms) * time.Millisecond
+	}
+	if v := os.Getenv("PROXY_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid PROXY_RETRIES value: %s", v)
+		}
+		config.Retries = n
+	}
+	if v := os.Getenv("PROXY_FOLLOW_REDIRECTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > proxy.MaxRedirects {
+			log.Fatalf("Invalid PROXY_FOLLOW_REDIRECTS value: %s (must be 0-%d)", v, proxy.MaxRedirects)
+		}
+		config.FollowRedirects = n
+	}
+	config.InsecureSkipVerify = os.Getenv("PROXY_INSECURE_SKIP_VERIFY") != ""
+	config.PreserveHost = os.Getenv("PROXY_PRESERVE_HOST") != ""
+	config.HostOverride = os.Getenv("PROXY_HOST_OVERRIDE")
+	if caFile := os.Getenv("PROXY_CA_FILE"); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			log.Fatalf("Failed to read PROXY_CA_FILE: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("No certificates found in PROXY_CA_FILE: %s", caFile)
+		}
+		config.RootCAs = pool
+	}
+	return config
+}
+
+// RandomSeed returns the seed for random response behavior (delay distributions, weighted
+// responses, randomValue) from RANDOM_SEED, and whether one was set. Fixing it makes runs
+// reproducible.
+func RandomSeed() (int64, bool) {
+	if v := os.Getenv("RANDOM_SEED"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid RANDOM_SEED value: %s", v)
+		}
+		return seed, true
+	}
+	return 0, false
+}
+
 // ParseJSONContentTypes returns the list of Content-Types whose response bodies
 // should be stored as structured JSON (jsonBody) instead of escaped strings.
 // application/json is always included.
@@ -48,6 +348,21 @@ func ParseJSONContentTypes() []string {
 	return types
 }
 
+// ParseCaptureHeaders returns the request header names that record mode turns into
+// equalTo header matchers, from CAPTURE_HEADERS (comma-separated). Empty by default.
+func ParseCaptureHeaders() []string {
+	var names []string
+	if env := os.Getenv("CAPTURE_HEADERS"); env != "" {
+		for _, n := range strings.Split(env, ",") {
+			n = strings.TrimSpace(n)
+			if n != "" {
+				names = append(names, n)
+			}
+		}
+	}
+	return names
+}
+
 // ParseBinaryContentTypes returns the list of Content-Types whose response bodies
 // should be stored as base64-encoded strings. Empty by default.
 func ParseBinaryContentTypes() []string {
@@ -0,0 +1,19 @@
+// (C) 2025 GoodData Corporation
+package jsonutil
+
+import "encoding/json"
+
+// Canonicalize returns a stable encoding of a JSON document: compact, with object
+// keys sorted and numbers in their shortest form (1.0 and 1e0 both become 1). With
+// sortArrays, arrays are also sorted via SortArrays. Two documents are equal as JSON
+// values exactly when their canonical forms are byte-equal.
+func Canonicalize(raw []byte, sortArrays bool) ([]byte, error) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if sortArrays {
+		v = SortArrays(v)
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,64 @@
+package jsonutil
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		sortArrays bool
+		expected   string
+	}{
+		{
+			name:     "object keys sorted at every level",
+			input:    `{"b": 1, "a": {"z": true, "y": null}}`,
+			expected: `{"a":{"y":null,"z":true},"b":1}`,
+		},
+		{
+			name:     "numeric forms normalized",
+			input:    `[1.0, 1e3, 2.50, -0.0, 1E-2]`,
+			expected: `[1,1000,2.5,-0,0.01]`,
+		},
+		{
+			name:     "arrays kept in order by default",
+			input:    `[3, 1, 2]`,
+			expected: `[3,1,2]`,
+		},
+		{
+			name:       "arrays sorted on request",
+			input:      `{"ids": [3, 1, 2]}`,
+			sortArrays: true,
+			expected:   `{"ids":[1,2,3]}`,
+		},
+		{
+			name:     "whitespace removed",
+			input:    "{\n  \"a\" : [ 1 , 2 ]\n}",
+			expected: `{"a":[1,2]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Canonicalize([]byte(tt.input), tt.sortArrays)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.expected {
+				t.Errorf("Canonicalize(%s) = %s, want %s", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeEquivalentDocuments(t *testing.T) {
+	a, _ := Canonicalize([]byte(`{"x": 1, "y": [2.0, {"k": "v"}]}`), false)
+	b, _ := Canonicalize([]byte(`{"y": [2, {"k": "v"}], "x": 1.00}`), false)
+	if string(a) != string(b) {
+		t.Errorf("canonical forms differ: %s vs %s", a, b)
+	}
+}
+
+func TestCanonicalizeInvalid(t *testing.T) {
+	if _, err := Canonicalize([]byte(`{"a":`), false); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
@@ -2,7 +2,9 @@
 package logging
 
 import (
+	"encoding/json"
 	"fmt"
+	"goodmock/internal/matching"
 	"goodmock/internal/types"
 	"strings"
 	"time"
@@ -10,10 +12,92 @@ import (
 
 const colWidth = 58
 
-// LogMismatch outputs a request mismatch in the same format as WireMock
-func LogMismatch(method, fullURL string, result types.MatchResult) {
+const timestampFormat = "2006-01-02 15:04:05.000"
+
+// mismatchEvent is the JSON form of LogMismatch. The diffs of the closest stub are
+// inlined and use the same encoding as the near-misses admin endpoint.
+type mismatchEvent struct {
+	Event           string `json:"event"`
+	Timestamp       string `json:"timestamp"`
+	Method          string `json:"method"`
+	URL             string `json:"url"`
+	ClosestStubID   string `json:"closestStubId,omitempty"`
+	ClosestStubName string `json:"closestStubName,omitempty"`
+	ContentType     string `json:"contentType,omitempty"`
+	BodySize        int    `json:"bodySize"`
+	BodyIsJSON      bool   `json:"bodyIsJson"`
+	*types.MatchDiff
+}
+
+// RequestBody summarizes the body of an unmatched request, so a mismatch report can
+// show e.g. that text/plain was sent to a stub expecting JSON.
+type RequestBody struct {
+	ContentType string
+	Size        int
+	JSON        bool // the body parsed as JSON
+}
+
+// DescribeBody builds the RequestBody for a request's Content-Type and body.
+func DescribeBody(contentType string, body []byte) RequestBody {
+	return RequestBody{ContentType: contentType, Size: len(body), JSON: len(body) > 0 && json.Valid(body)}
+}
+
+// summary renders the body as e.g. "text/plain, 12 bytes, not JSON".
+func (b RequestBody) summary() string {
+	contentType := b.ContentType
+	if contentType == "" {
+		contentType = "no Content-Type"
+	}
+	parsed := "not JSON"
+	if b.JSON {
+		parsed = "valid JSON"
+	}
+	return fmt.Sprintf("%s, %d bytes, %s", contentType, b.Size, parsed)
+}
+
+// expectsJSON reports whether any body pattern only makes sense for a JSON body.
+func expectsJSON(patterns []types.BodyPattern) bool {
+	for _, p := range patterns {
+		if p.EqualToJSON != nil || p.MatchesJsonPath != "" || p.MatchesJsonSchema != nil || len(p.JsonPathMatchers) > 0 ||
+			expectsJSON(p.And) || expectsJSON(p.Or) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestEvent is the JSON form of a verbose request log line.
+type requestEvent struct {
+	Event     string              `json:"event"`
+	Timestamp string              `json:"timestamp"`
+	Method    string              `json:"method"`
+	URL       string              `json:"url"`
+	Headers   map[string][]string `json:"headers,omitempty"`
+	Body      string              `json:"body,omitempty"`
+}
+
+// printJSON writes event as a single line.
+func printJSON(event any) {
+	data, _ := json.Marshal(event)
+	fmt.Println(string(data))
+}
+
+// LogRequest prints an incoming request as a JSON "request" event.
+func LogRequest(method, fullURL string, headers map[string][]string, body string) {
+	printJSON(requestEvent{
+		Event:     "request",
+		Timestamp: time.Now().UTC().Format(timestampFormat),
+		Method:    method,
+		URL:       fullURL,
+		Headers:   headers,
+		Body:      body,
+	})
+}
+
+// LogMismatch outputs a request mismatch in the same format as WireMock.
+func LogMismatch(method, fullURL string, body RequestBody, result types.MatchResult) {
 	separator := strings.Repeat("-", 119)
-	timestamp := time.Now().UTC().Format("2006-01-02 15:04:05.000")
+	timestamp := time.Now().UTC().Format(timestampFormat)
 
 	fmt.Printf("%s \n", timestamp)
 	fmt.Println("                                               Request was not matched")
@@ -34,7 +118,11 @@ func LogMismatch(method, fullURL string, result types.MatchResult) {
 		fmt.Printf("%-*s |\n", colWidth+1, "")
 
 		// Method
-		fmt.Printf("%-*s | %s\n", colWidth, " "+m.Request.Method, method)
+		expectedMethod := m.Request.Method
+		if m.Request.MethodPattern != "" {
+			expectedMethod = "[regex] " + m.Request.MethodPattern
+		}
+		fmt.Printf("%-*s | %s\n", colWidth, " "+expectedMethod, method)
 
 		// Path comparison
 		expectedPath := m.Request.URL
@@ -44,6 +132,15 @@ func LogMismatch(method, fullURL string, result types.MatchResult) {
 		if expectedPath == "" {
 			expectedPath = m.Request.URLPattern
 		}
+		if expectedPath == "" {
+			expectedPath = m.Request.URLPathPattern
+		}
+		if expectedPath == "" {
+			expectedPath = m.Request.URLPathTemplate
+		}
+		if expectedPath == "" {
+			expectedPath = m.Request.URLPathGlob
+		}
 
 		if result.URLMatch {
 			fmt.Printf(" [path] %-*s | %-*s\n",
@@ -72,7 +169,7 @@ func LogMismatch(method, fullURL string, result types.MatchResult) {
 			paramName := parts[1]
 			expectedVals := parts[2]
 
-			stubCol := fmt.Sprintf(" Query: %s exactly %s", paramName, expectedVals)
+			stubCol := fmt.Sprintf(" Query: %s %s", paramName, expectedVals)
 			if diffType == "not_present" {
 				fmt.Printf("%-*s | %s<<<<< Query is not present\n",
 					colWidth, truncate(stubCol, colWidth),
@@ -93,7 +190,7 @@ func LogMismatch(method, fullURL string, result types.MatchResult) {
 			headerName := parts[1]
 			expectedVal := parts[2]
 
-			stubCol := fmt.Sprintf(" Header: %s [equalTo %s]", headerName, expectedVal)
+			stubCol := fmt.Sprintf(" Header: %s [%s]", headerName, expectedVal)
 			if diffType == "not_present" {
 				fmt.Printf("%-*s | %s<<<<< Header is not present\n",
 					colWidth, truncate(stubCol, colWidth),
@@ -107,10 +204,22 @@ func LogMismatch(method, fullURL string, result types.MatchResult) {
 			}
 		}
 
-		// Body diff
+		// Body diff, with what was actually sent
 		if result.BodyDiff != "" {
 			fmt.Printf(" %-*s | <<<<< %s\n", colWidth-1, "Body [equalToJson]", result.BodyDiff)
 		}
+		if !result.BodyMatch {
+			note := ""
+			if expectsJSON(m.Request.BodyPatterns) && !strings.Contains(strings.ToLower(body.ContentType), "json") {
+				note = " <<<<< Content-Type is not JSON"
+			}
+			fmt.Printf(" %-*s | %s%s\n", colWidth-1, "Request body", body.summary(), note)
+		}
+
+		// Scenario diff
+		if result.ScenarioDiff != "" {
+			fmt.Printf(" %-*s | <<<<< Scenario %s\n", colWidth-1, truncate("Scenario: "+m.ScenarioName, colWidth-1), result.ScenarioDiff)
+		}
 	} else {
 		fmt.Printf(" No stub found for: %s %s\n", method, fullURL)
 	}
@@ -121,6 +230,30 @@ func LogMismatch(method, fullURL string, result types.MatchResult) {
 	fmt.Println()
 }
 
+// LogMismatchJSON prints a request mismatch as a single JSON "mismatch" event, which
+// CI log aggregation can parse.
+func LogMismatchJSON(method, fullURL string, body RequestBody, result types.MatchResult) {
+	event := mismatchEvent{
+		Event:       "mismatch",
+		Timestamp:   time.Now().UTC().Format(timestampFormat),
+		Method:      method,
+		URL:         fullURL,
+		ContentType: body.ContentType,
+		BodySize:    body.Size,
+		BodyIsJSON:  body.JSON,
+	}
+	if m := result.Mapping; m != nil {
+		event.ClosestStubID = m.ID
+		if event.ClosestStubID == "" {
+			event.ClosestStubID = m.UUID
+		}
+		event.ClosestStubName = m.Name
+		diff := matching.NewMatchDiff(&result)
+		event.MatchDiff = &diff
+	}
+	printJSON(event)
+}
+
 func truncate(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"goodmock/internal/types"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns everything fn prints to stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	out, _ := io.ReadAll(r)
+	return string(out)
+}
+
+// jsonLines decodes each line of out as a JSON object.
+func jsonLines(t *testing.T, out string) []map[string]any {
+	t.Helper()
+	var events []map[string]any
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		var event map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestLogMismatchJSON(t *testing.T) {
+	stub := &types.Mapping{ID: "stub-1", Name: "list items", Request: types.Request{Method: "GET", URLPath: "/items"}}
+	out := captureStdout(t, func() {
+		LogMismatchJSON("GET", "/items?page=2", RequestBody{}, types.MatchResult{
+			Mapping:     stub,
+			URLMatch:    true,
+			MethodMatch: true,
+			HeaderMatch: true,
+			QueryDiffs:  []string{"mismatch|page|equalTo 1|2"},
+			BodyDiff:    "Body does not match",
+		})
+		LogMismatchJSON("POST", "/unknown", DescribeBody("text/plain", []byte("hello")), types.MatchResult{})
+	})
+
+	events := jsonLines(t, out)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2:\n%s", len(events), out)
+	}
+	got := events[0]
+	if got["event"] != "mismatch" || got["method"] != "GET" || got["url"] != "/items?page=2" {
+		t.Errorf("event = %v", got)
+	}
+	if got["closestStubId"] != "stub-1" || got["closestStubName"] != "list items" {
+		t.Errorf("closest stub = %v %v", got["closestStubId"], got["closestStubName"])
+	}
+	if diffs, _ := got["queryDiffs"].([]any); len(diffs) != 1 || diffs[0] != "mismatch|page|equalTo 1|2" {
+		t.Errorf("queryDiffs = %v", got["queryDiffs"])
+	}
+	if got["bodyDiff"] != "Body does not match" || got["urlMatch"] != true || got["queryMatch"] != false {
+		t.Errorf("diff fields = %v", got)
+	}
+	if _, ok := events[1]["closestStubId"]; ok || events[1]["url"] != "/unknown" {
+		t.Errorf("no-stub event = %v", events[1])
+	}
+	if got := events[1]; got["contentType"] != "text/plain" || got["bodySize"] != 5.0 || got["bodyIsJson"] != false {
+		t.Errorf("body fields = %v %v %v", got["contentType"], got["bodySize"], got["bodyIsJson"])
+	}
+}
+
+func TestLogRequestJSON(t *testing.T) {
+	out := captureStdout(t, func() {
+		LogRequest("POST", "/items", map[string][]string{"Content-Type": {"application/json"}}, `{"a":1}`)
+	})
+	events := jsonLines(t, out)
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if got := events[0]; got["event"] != "request" || got["method"] != "POST" || got["body"] != `{"a":1}` {
+		t.Errorf("event = %v", got)
+	}
+}
+
+func TestLogMismatchText(t *testing.T) {
+	out := captureStdout(t, func() {
+		LogMismatch("GET", "/unknown", RequestBody{}, types.MatchResult{})
+	})
+	if !strings.Contains(out, "Request was not matched") || strings.HasPrefix(out, "{") {
+		t.Errorf("output = %q, want the text table", out)
+	}
+}
+
+func TestLogMismatchReportsRequestBody(t *testing.T) {
+	stub := &types.Mapping{Request: types.Request{
+		Method:       "POST",
+		URL:          "/execute",
+		BodyPatterns: []types.BodyPattern{{EqualToJSON: json.RawMessage(`{"measures": ["m1"]}`)}},
+	}}
+	result := types.MatchResult{Mapping: stub, URLMatch: true, MethodMatch: true, QueryMatch: true, HeaderMatch: true, ScenarioMatch: true}
+
+	out := captureStdout(t, func() {
+		LogMismatch("POST", "/execute", DescribeBody("text/plain", []byte("measures=m1")), result)
+	})
+	if !strings.Contains(out, "text/plain, 11 bytes, not JSON") || !strings.Contains(out, "Content-Type is not JSON") {
+		t.Errorf("report should flag the text/plain body:\n%s", out)
+	}
+
+	out = captureStdout(t, func() {
+		LogMismatch("POST", "/execute", DescribeBody("application/json", []byte(`{"measures":["m2"]}`)), result)
+	})
+	if !strings.Contains(out, "application/json, 19 bytes, valid JSON") || strings.Contains(out, "Content-Type is not JSON") {
+		t.Errorf("report for a JSON body:\n%s", out)
+	}
+}
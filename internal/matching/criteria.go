@@ -0,0 +1,70 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"encoding/json"
+	"goodmock/internal/types"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// MatchesCriteria reports whether a journaled request satisfies WireMock request
+// criteria, using the same rules as stub matching. Unlike a stub, criteria without
+// a method or URL matcher accept any method or URL. The server's matching settings,
+// such as its number tolerance, apply as they do to stubs.
+func MatchesCriteria(s *types.Server, criteria *types.Request, req *types.LoggedRequest) bool {
+	m := &types.Mapping{Request: *criteria}
+	if m.Request.Method == "" {
+		m.Request.Method = "ANY"
+	}
+
+	path := req.URL
+	var query string
+	if idx := strings.IndexByte(req.URL, '?'); idx != -1 {
+		path, query = req.URL[:idx], req.URL[idx+1:]
+	}
+	var args fasthttp.Args
+	args.Parse(query)
+	var headers fasthttp.RequestHeader
+	for name, values := range req.Headers {
+		for _, v := range values {
+			headers.Add(name, v)
+		}
+	}
+
+	r := evaluateMapping(m, optionsFor(s), "", req.Method, path, req.URL, queryValues(&args), []byte(req.Body), &headers)
+	urlMatch := r.URLMatch || !hasURLMatcher(criteria)
+	return r.MethodMatch && urlMatch && r.QueryMatch && r.BodyMatch && r.HeaderMatch
+}
+
+func hasURLMatcher(r *types.Request) bool {
+	return r.URL != "" || r.URLPath != "" || r.URLPattern != "" || r.URLPathPattern != "" || r.URLPathTemplate != "" || r.URLPathGlob != ""
+}
+
+// MatchesMetadata reports whether a mapping's metadata satisfies a body-style matcher
+// such as equalToJson or matchesJsonPath. Mappings without metadata never match.
+func MatchesMetadata(pattern types.BodyPattern, metadata json.RawMessage) bool {
+	if len(metadata) == 0 {
+		return false
+	}
+	return matchBodyPattern(pattern, metadata, matchOptions{})
+}
+
+// NewMatchDiff extracts the per-criterion outcome of a match, as reported for near
+// misses in the journal and in JSON mismatch logs.
+func NewMatchDiff(result *types.MatchResult) types.MatchDiff {
+	return types.MatchDiff{
+		URLMatch:       result.URLMatch,
+		MethodMatch:    result.MethodMatch,
+		QueryMatch:     result.QueryMatch,
+		BodyMatch:      result.BodyMatch,
+		HeaderMatch:    result.HeaderMatch,
+		ScenarioMatch:  result.ScenarioMatch,
+		QueryDiffs:     result.QueryDiffs,
+		BodyDiff:       result.BodyDiff,
+		BodyFieldDiffs: result.BodyFieldDiffs,
+		HeaderDiffs:    result.HeaderDiffs,
+		ScenarioDiff:   result.ScenarioDiff,
+	}
+}
@@ -0,0 +1,161 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/types"
+	"sort"
+	"strings"
+)
+
+// maxBodyDiffs caps how many field diffs are reported for one body.
+const maxBodyDiffs = 10
+
+// jsonBodyDiffs walks an equalToJson pattern against the body and lists the fields
+// that differ, honouring the same ignoreArrayOrder and ignoreExtraElements flags as
+// jsonEqual, and the same number tolerance. It returns nil if either side isn't valid JSON.
+func jsonBodyDiffs(expected json.RawMessage, actual []byte, ignoreArrayOrder, ignoreExtraElements bool, tolerance float64) []types.BodyFieldDiff {
+	var expectedVal, actualVal any
+	if err := json.Unmarshal(expected, &expectedVal); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(actual, &actualVal); err != nil {
+		return nil
+	}
+	d := jsonDiffer{ignoreArrayOrder: ignoreArrayOrder, ignoreExtraElements: ignoreExtraElements, tolerance: tolerance}
+	d.diff("$", expectedVal, actualVal)
+	return d.diffs
+}
+
+type jsonDiffer struct {
+	ignoreArrayOrder    bool
+	ignoreExtraElements bool
+	tolerance           float64
+	diffs               []types.BodyFieldDiff
+}
+
+func (d *jsonDiffer) add(kind, path, expected, actual string) {
+	if len(d.diffs) < maxBodyDiffs {
+		d.diffs = append(d.diffs, types.BodyFieldDiff{Kind: kind, Path: path, Expected: expected, Actual: actual})
+	}
+}
+
+func (d *jsonDiffer) diff(path string, expected, actual any) {
+	if matched, ok := matchJSONUnitPlaceholder(expected, actual); ok {
+		if !matched {
+			d.add("type", path, expected.(string), diffTypeName(actual))
+		}
+		return
+	}
+	if expectedType, actualType := diffTypeName(expected), diffTypeName(actual); expectedType != actualType {
+		d.add("type", path, expectedType, actualType)
+		return
+	}
+	switch exp := expected.(type) {
+	case map[string]any:
+		act := actual.(map[string]any)
+		for _, key := range sortedKeys(exp) {
+			if av, ok := act[key]; ok {
+				d.diff(path+"."+key, exp[key], av)
+			} else {
+				d.add("missing", path+"."+key, compactJSON(exp[key]), "")
+			}
+		}
+		if !d.ignoreExtraElements {
+			for _, key := range sortedKeys(act) {
+				if _, ok := exp[key]; !ok {
+					d.add("unexpected", path+"."+key, "", compactJSON(act[key]))
+				}
+			}
+		}
+	case []any:
+		act := actual.([]any)
+		if d.ignoreArrayOrder {
+			// Without an order there is no element to pin the difference on
+			if !jsonValuesEqual(exp, act, d.ignoreArrayOrder, d.ignoreExtraElements, d.tolerance) {
+				d.add("changed", path, compactJSON(exp), compactJSON(act))
+			}
+			return
+		}
+		for i := 0; i < len(exp) || i < len(act); i++ {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(act):
+				d.add("missing", itemPath, compactJSON(exp[i]), "")
+			case i >= len(exp):
+				d.add("unexpected", itemPath, "", compactJSON(act[i]))
+			default:
+				d.diff(itemPath, exp[i], act[i])
+			}
+		}
+	case float64:
+		if !numbersEqual(exp, actual.(float64), d.tolerance) {
+			d.add("changed", path, compactJSON(expected), compactJSON(actual))
+		}
+	default:
+		if expected != actual {
+			d.add("changed", path, compactJSON(expected), compactJSON(actual))
+		}
+	}
+}
+
+// diffTypeName names a value's JSON type, without telling integers from other numbers.
+func diffTypeName(v any) string {
+	if _, ok := v.(float64); ok {
+		return "number"
+	}
+	return jsonTypeName(v)
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compactJSON renders a decoded value for diagnostics, shortening long values.
+func compactJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return truncateDiff(string(data))
+}
+
+func truncateDiff(s string) string {
+	const maxLen = 80
+	if len(s) <= maxLen {
+		return s
+	}
+	return s[:maxLen-3] + "..."
+}
+
+// describeBodyFieldDiffs renders field diffs for the text mismatch log,
+// e.g. "$.user.name: expected "a", got "b"".
+func describeBodyFieldDiffs(diffs []types.BodyFieldDiff) string {
+	parts := make([]string, 0, len(diffs))
+	for _, diff := range diffs {
+		switch diff.Kind {
+		case "missing":
+			parts = append(parts, fmt.Sprintf("%s: missing, expected %s", diff.Path, diff.Expected))
+		case "unexpected":
+			parts = append(parts, fmt.Sprintf("%s: unexpected %s", diff.Path, diff.Actual))
+		case "type":
+			parts = append(parts, fmt.Sprintf("%s: expected type %s, got %s", diff.Path, diff.Expected, diff.Actual))
+		default:
+			parts = append(parts, fmt.Sprintf("%s: expected %s, got %s", diff.Path, diff.Expected, diff.Actual))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// byteDiff locates the first difference between an expected and actual body that
+// aren't JSON, e.g. "at byte 6: expected "world", got "there"".
+func byteDiff(expected, actual string) string {
+	i := 0
+	for i < len(expected) && i < len(actual) && expected[i] == actual[i] {
+		i++
+	}
+	return fmt.Sprintf("at byte %d: expected %q, got %q", i, truncateDiff(expected[i:]), truncateDiff(actual[i:]))
+}
@@ -0,0 +1,119 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is a single segment of a parsed JSONPath expression.
+// Exactly one of key, index or wildcard is meaningful.
+type jsonPathStep struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// parseJSONPath parses the supported JSONPath subset: $.a.b, $['a'], $.a[0] and $.a[*] (or $.a.*).
+func parseJSONPath(expr string) ([]jsonPathStep, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("JSONPath must start with $: %q", expr)
+	}
+	rest := expr[1:]
+	var steps []jsonPathStep
+
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			if name == "" {
+				return nil, fmt.Errorf("empty key in JSONPath %q", expr)
+			}
+			if name == "*" {
+				steps = append(steps, jsonPathStep{wildcard: true})
+			} else {
+				steps = append(steps, jsonPathStep{key: name})
+			}
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated [ in JSONPath %q", expr)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				idx, err := strconv.Atoi(inner)
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("unsupported subscript [%s] in JSONPath %q", inner, expr)
+				}
+				steps = append(steps, jsonPathStep{index: idx, isIndex: true})
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %q in JSONPath %q", rest[0], expr)
+		}
+	}
+	return steps, nil
+}
+
+// evalJSONPath returns all nodes of a decoded JSON document selected by the given steps.
+func evalJSONPath(doc any, steps []jsonPathStep) []any {
+	nodes := []any{doc}
+	for _, step := range steps {
+		var next []any
+		for _, node := range nodes {
+			switch n := node.(type) {
+			case map[string]any:
+				if step.wildcard {
+					for _, v := range n {
+						next = append(next, v)
+					}
+				} else if !step.isIndex {
+					if v, ok := n[step.key]; ok {
+						next = append(next, v)
+					}
+				}
+			case []any:
+				if step.wildcard {
+					next = append(next, n...)
+				} else if step.isIndex && step.index < len(n) {
+					next = append(next, n[step.index])
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// jsonPathMatches reports whether the expression selects at least one node in the document.
+// Like WireMock, a selected null or empty array doesn't count as a match.
+func jsonPathMatches(expr string, doc any) (bool, error) {
+	steps, err := parseJSONPath(expr)
+	if err != nil {
+		return false, err
+	}
+	for _, node := range evalJSONPath(doc, steps) {
+		if node == nil {
+			continue
+		}
+		if arr, ok := node.([]any); ok && len(arr) == 0 {
+			continue
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,427 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// jsonSchema is a compiled JSON Schema supporting a draft-07 subset: type, enum,
+// const, properties, required, additionalProperties, items, min/maxItems,
+// min/maxLength, pattern, numeric bounds and allOf/anyOf/oneOf/not.
+// Boolean schemas (true/false) are supported as well.
+type jsonSchema struct {
+	always *bool // set for boolean schemas
+
+	types    []string
+	enum     []any
+	constVal any
+	hasConst bool
+
+	properties           map[string]*jsonSchema
+	required             []string
+	additionalProperties *jsonSchema
+
+	items    *jsonSchema
+	minItems *int
+	maxItems *int
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+
+	allOf []*jsonSchema
+	anyOf []*jsonSchema
+	oneOf []*jsonSchema
+	not   *jsonSchema
+}
+
+// schemaAnnotations are keywords that carry no validation meaning and are ignored.
+var schemaAnnotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "format": true, "readOnly": true, "writeOnly": true,
+}
+
+// schemaCache holds compiled schemas keyed by their raw JSON. Invalid schemas are
+// stored as nil so the error is only logged once.
+var schemaCache sync.Map
+
+// compileSchemaCached compiles a schema once and reuses it on subsequent requests.
+// Returns nil for invalid schemas, which callers must treat as a non-match.
+func compileSchemaCached(raw json.RawMessage) *jsonSchema {
+	key := string(raw)
+	if cached, ok := schemaCache.Load(key); ok {
+		return cached.(*jsonSchema)
+	}
+	schema, err := compileSchema(raw)
+	if err != nil {
+		log.Printf("Warning: invalid JSON schema in stub matcher: %v", err)
+		schema = nil
+	}
+	actual, _ := schemaCache.LoadOrStore(key, schema)
+	return actual.(*jsonSchema)
+}
+
+// compileSchema parses and compiles a raw JSON Schema document.
+func compileSchema(raw json.RawMessage) (*jsonSchema, error) {
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	return compileSchemaValue(doc, "#")
+}
+
+func compileSchemaValue(doc any, at string) (*jsonSchema, error) {
+	if b, ok := doc.(bool); ok {
+		return &jsonSchema{always: &b}, nil
+	}
+	obj, ok := doc.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", at)
+	}
+
+	s := &jsonSchema{}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := obj[key]
+		path := at + "/" + key
+		var err error
+		switch key {
+		case "type":
+			s.types, err = schemaTypes(value, path)
+		case "enum":
+			list, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be an array", path)
+			}
+			s.enum = list
+		case "const":
+			s.constVal, s.hasConst = value, true
+		case "properties":
+			props, ok := value.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be an object", path)
+			}
+			s.properties = make(map[string]*jsonSchema, len(props))
+			for name, sub := range props {
+				if s.properties[name], err = compileSchemaValue(sub, path+"/"+name); err != nil {
+					return nil, err
+				}
+			}
+		case "required":
+			s.required, err = schemaStrings(value, path)
+		case "additionalProperties":
+			s.additionalProperties, err = compileSchemaValue(value, path)
+		case "items":
+			if _, isArray := value.([]any); isArray {
+				return nil, fmt.Errorf("%s: tuple validation is not supported", path)
+			}
+			s.items, err = compileSchemaValue(value, path)
+		case "minItems":
+			s.minItems, err = schemaCount(value, path)
+		case "maxItems":
+			s.maxItems, err = schemaCount(value, path)
+		case "minLength":
+			s.minLength, err = schemaCount(value, path)
+		case "maxLength":
+			s.maxLength, err = schemaCount(value, path)
+		case "pattern":
+			expr, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be a string", path)
+			}
+			if s.pattern, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		case "minimum":
+			s.minimum, err = schemaNumber(value, path)
+		case "maximum":
+			s.maximum, err = schemaNumber(value, path)
+		case "exclusiveMinimum":
+			s.exclusiveMinimum, err = schemaNumber(value, path)
+		case "exclusiveMaximum":
+			s.exclusiveMaximum, err = schemaNumber(value, path)
+		case "allOf":
+			s.allOf, err = schemaList(value, path)
+		case "anyOf":
+			s.anyOf, err = schemaList(value, path)
+		case "oneOf":
+			s.oneOf, err = schemaList(value, path)
+		case "not":
+			s.not, err = compileSchemaValue(value, path)
+		default:
+			if !schemaAnnotations[key] {
+				return nil, fmt.Errorf("%s: unsupported keyword", path)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func schemaTypes(value any, path string) ([]string, error) {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = []string{v}
+	case []any:
+		var err error
+		if names, err = schemaStrings(v, path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: must be a string or array of strings", path)
+	}
+	for _, name := range names {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fmt.Errorf("%s: unknown type %q", path, name)
+		}
+	}
+	return names, nil
+}
+
+func schemaStrings(value any, path string) ([]string, error) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be an array of strings", path)
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		str, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: must be an array of strings", path)
+		}
+		out = append(out, str)
+	}
+	return out, nil
+}
+
+func schemaCount(value any, path string) (*int, error) {
+	n, ok := value.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return nil, fmt.Errorf("%s: must be a non-negative integer", path)
+	}
+	count := int(n)
+	return &count, nil
+}
+
+func schemaNumber(value any, path string) (*float64, error) {
+	n, ok := value.(float64)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a number", path)
+	}
+	return &n, nil
+}
+
+func schemaList(value any, path string) ([]*jsonSchema, error) {
+	list, ok := value.([]any)
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: must be a non-empty array of schemas", path)
+	}
+	out := make([]*jsonSchema, 0, len(list))
+	for i, item := range list {
+		sub, err := compileSchemaValue(item, fmt.Sprintf("%s/%d", path, i))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sub)
+	}
+	return out, nil
+}
+
+// validateJSONSchema checks a request body against a raw schema and returns a
+// description of the first violation, or "" if the body is valid.
+func validateJSONSchema(raw json.RawMessage, body []byte) string {
+	schema := compileSchemaCached(raw)
+	if schema == nil {
+		return "invalid JSON schema"
+	}
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "body is not valid JSON"
+	}
+	if err := schema.validate(doc, "$"); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func (s *jsonSchema) validate(v any, at string) error {
+	if s.always != nil {
+		if !*s.always {
+			return fmt.Errorf("%s: schema false never matches", at)
+		}
+		return nil
+	}
+
+	if len(s.types) > 0 && !schemaTypeMatches(s.types, v) {
+		return fmt.Errorf("%s: expected type %s, got %s", at, strings.Join(s.types, " or "), jsonTypeName(v))
+	}
+	if s.enum != nil {
+		found := false
+		for _, candidate := range s.enum {
+			if jsonValuesEqual(candidate, v, false, false, 0) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the enum values", at)
+		}
+	}
+	if s.hasConst && !jsonValuesEqual(s.constVal, v, false, false, 0) {
+		return fmt.Errorf("%s: value does not equal const", at)
+	}
+
+	switch val := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := val[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, name)
+			}
+		}
+		names := make([]string, 0, len(val))
+		for name := range val {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, declared := s.properties[name]
+			if !declared {
+				sub = s.additionalProperties
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(val[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(val) < *s.minItems {
+			return fmt.Errorf("%s: expected at least %d items, got %d", at, *s.minItems, len(val))
+		}
+		if s.maxItems != nil && len(val) > *s.maxItems {
+			return fmt.Errorf("%s: expected at most %d items, got %d", at, *s.maxItems, len(val))
+		}
+		if s.items != nil {
+			for i, item := range val {
+				if err := s.items.validate(item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(val)
+		if s.minLength != nil && length < *s.minLength {
+			return fmt.Errorf("%s: expected at least %d characters, got %d", at, *s.minLength, length)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			return fmt.Errorf("%s: expected at most %d characters, got %d", at, *s.maxLength, length)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			return fmt.Errorf("%s: does not match pattern %s", at, s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && val < *s.minimum {
+			return fmt.Errorf("%s: %v is less than minimum %v", at, val, *s.minimum)
+		}
+		if s.maximum != nil && val > *s.maximum {
+			return fmt.Errorf("%s: %v is greater than maximum %v", at, val, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && val <= *s.exclusiveMinimum {
+			return fmt.Errorf("%s: %v is not greater than %v", at, val, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && val >= *s.exclusiveMaximum {
+			return fmt.Errorf("%s: %v is not less than %v", at, val, *s.exclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, at); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, sub := range s.anyOf {
+			if sub.validate(v, at) == nil {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: value matches none of anyOf", at)
+		}
+	}
+	if s.oneOf != nil {
+		count := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, at) == nil {
+				count++
+			}
+		}
+		if count != 1 {
+			return fmt.Errorf("%s: value matches %d of oneOf, expected exactly 1", at, count)
+		}
+	}
+	if s.not != nil && s.not.validate(v, at) == nil {
+		return fmt.Errorf("%s: value matches schema under not", at)
+	}
+	return nil
+}
+
+// schemaTypeMatches reports whether v is one of the given JSON Schema types.
+// A whole number satisfies both "number" and "integer".
+func schemaTypeMatches(types []string, v any) bool {
+	actual := jsonTypeName(v)
+	for _, t := range types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the JSON Schema type name of a decoded JSON value.
+func jsonTypeName(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case float64:
+		if val == math.Trunc(val) && !math.IsInf(val, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	}
+	return fmt.Sprintf("%T", v)
+}
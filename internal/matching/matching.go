@@ -2,40 +2,67 @@
 package matching
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"goodmock/internal/jsonutil"
 	"goodmock/internal/types"
+	"log"
+	"math"
+	"net"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 
 	"github.com/valyala/fasthttp"
 )
 
 // MatchRequest finds the best matching stub for the incoming request.
-// When multiple mappings match, returns the most specific one (most query params + body patterns + headers).
+// When multiple mappings match, the one with the lowest priority number wins; among equal
+// priorities, returns the most specific one (most query params + body patterns + headers),
+// and remaining ties are broken by tieBreaksBefore.
 func MatchRequest(s *types.Server, method, path, fullURI string, queryArgs *fasthttp.Args, body []byte, reqHeaders *fasthttp.RequestHeader) types.MatchResult {
 	s.Mu.RLock()
 	defer s.Mu.RUnlock()
 
 	var bestMatch types.MatchResult
 	var bestScore int
+	var bestPriority int
 	bestMatched := false
 
+	// Collect query values once instead of scanning the args for every matcher
+	query := queryValues(queryArgs)
+	opts := optionsFor(s)
+
 	for i := range s.Mappings {
 		m := &s.Mappings[i]
-		result := evaluateMapping(m, method, path, fullURI, queryArgs, body, reqHeaders)
+		result := evaluateMapping(m, opts, scenarioState(s, m), method, path, fullURI, query, body, reqHeaders)
 
 		if result.Matched {
 			// Calculate specificity: more criteria = more specific
-			specificity := len(m.Request.QueryParameters) + len(m.Request.BodyPatterns) + len(m.Request.Headers)
+			specificity := len(m.Request.QueryParameters) + len(m.Request.FormParameters) + len(m.Request.BodyPatterns) + len(m.Request.Headers) + len(m.Request.Cookies)
+			if m.Request.BasicAuth != nil {
+				specificity++
+			}
+			if m.Request.Scheme != "" {
+				specificity++
+			}
+			if m.Request.Host != "" {
+				specificity++
+			}
 			// URL exact match (includes query string) is more specific than urlPath
 			if m.Request.URL != "" {
 				specificity += 100
 			}
 
-			if !bestMatched || specificity > bestScore {
+			priority := effectivePriority(m)
+			if !bestMatched || priority < bestPriority || (priority == bestPriority && specificity > bestScore) ||
+				(priority == bestPriority && specificity == bestScore && tieBreaksBefore(m, bestMatch.Mapping)) {
 				bestMatched = true
+				bestPriority = priority
 				bestScore = specificity
 				bestMatch = result
 				bestMatch.Mapping = m
@@ -58,6 +85,9 @@ func MatchRequest(s *types.Server, method, path, fullURI string, queryArgs *fast
 			if result.HeaderMatch {
 				score += 16
 			}
+			if result.ScenarioMatch {
+				score += 32
+			}
 			if score > bestScore {
 				bestScore = score
 				bestMatch = result
@@ -66,68 +96,122 @@ func MatchRequest(s *types.Server, method, path, fullURI string, queryArgs *fast
 		}
 	}
 
+	bestMatch.Score = bestScore
 	return bestMatch
 }
 
-// evaluateMapping checks how well a mapping matches the request
-func evaluateMapping(m *types.Mapping, method, path, fullURI string, queryArgs *fasthttp.Args, body []byte, reqHeaders *fasthttp.RequestHeader) types.MatchResult {
+// queryValues groups query arguments by name, keeping repeated values in request order.
+func queryValues(args *fasthttp.Args) map[string][]string {
+	values := make(map[string][]string, args.Len())
+	args.VisitAll(func(key, value []byte) {
+		values[string(key)] = append(values[string(key)], string(value))
+	})
+	return values
+}
+
+// matchOptions carries the server-wide matching settings down to the matchers.
+type matchOptions struct {
+	numberTolerance           float64 // largest difference at which JSON numbers still compare equal
+	normalizeTrailingSlash    bool    // url and urlPath ignore a single trailing slash on the path
+	caseInsensitiveQueryNames bool    // query parameter names match regardless of case
+}
+
+// optionsFor collects the matching settings configured on the server.
+func optionsFor(s *types.Server) matchOptions {
+	return matchOptions{
+		numberTolerance:           s.NumberTolerance,
+		normalizeTrailingSlash:    s.NormalizeTrailingSlash,
+		caseInsensitiveQueryNames: s.CaseInsensitiveQueryNames,
+	}
+}
+
+// effectivePriority returns the mapping's priority, or DefaultPriority if unset.
+func effectivePriority(m *types.Mapping) int {
+	if m.Priority != nil {
+		return *m.Priority
+	}
+	return types.DefaultPriority
+}
+
+// scenarioState returns the current state of the mapping's scenario, or "" if it isn't part of one.
+// The caller must hold s.Mu.
+func scenarioState(s *types.Server, m *types.Mapping) string {
+	if m.ScenarioName == "" {
+		return ""
+	}
+	if state, ok := s.Scenarios[m.ScenarioName]; ok {
+		return state
+	}
+	return types.ScenarioStarted
+}
+
+// evaluateMapping checks how well a mapping matches the request.
+// currentState is the current state of the mapping's scenario (ignored for non-scenario mappings).
+func evaluateMapping(m *types.Mapping, opts matchOptions, currentState, method, path, fullURI string, query map[string][]string, body []byte, reqHeaders *fasthttp.RequestHeader) types.MatchResult {
 	result := types.MatchResult{}
 
-	// Check method - "ANY" matches all methods
-	result.MethodMatch = strings.EqualFold(m.Request.Method, method) || strings.EqualFold(m.Request.Method, "ANY")
+	// Check scenario state - a mapping only applies while its scenario is in the required state
+	if m.ScenarioName == "" || m.RequiredScenarioState == "" || m.RequiredScenarioState == currentState {
+		result.ScenarioMatch = true
+	} else {
+		result.ScenarioDiff = fmt.Sprintf("requires state %q, current state is %q", m.RequiredScenarioState, currentState)
+	}
+
+	// Check method
+	result.MethodMatch = matchMethod(&m.Request, method)
 
 	// Check URL/path
 	// In WireMock, "url" matches the full URI (path + query string),
 	// while "urlPath" matches just the path component.
 	if m.Request.URL != "" {
-		result.URLMatch = m.Request.URL == fullURI
+		result.URLMatch = equalURL(m.Request.URL, fullURI, opts.normalizeTrailingSlash)
 	} else if m.Request.URLPath != "" {
-		result.URLMatch = m.Request.URLPath == path
+		result.URLMatch = equalURL(m.Request.URLPath, path, opts.normalizeTrailingSlash)
+	} else if m.Request.URLPathGlob != "" {
+		// urlPathGlob matches the path with * for one segment and ** for several
+		if re := compileCached(globRegex(m.Request.URLPathGlob)); re != nil {
+			result.URLMatch = re.MatchString(path)
+		}
 	} else if m.Request.URLPattern != "" {
 		// urlPattern in WireMock matches against the full URI (path + query string)
-		re, err := regexp.Compile(m.Request.URLPattern)
-		if err == nil {
-			result.URLMatch = re.MatchString(fullURI)
+		if re := compileCached(m.Request.URLPattern); re != nil {
+			result.URLGroups, result.URLMatch = matchURLRegex(re, fullURI, &result)
+		}
+	} else if m.Request.URLPathPattern != "" {
+		// urlPathPattern matches the path only, query parameters are matched separately
+		if re := compileCached(m.Request.URLPathPattern); re != nil {
+			result.URLGroups, result.URLMatch = matchURLRegex(re, path, &result)
 		}
+	} else if m.Request.URLPathTemplate != "" {
+		// urlPathTemplate matches the path with {name} placeholders for single segments
+		result.PathVariables, result.URLMatch = matchPathTemplate(m.Request.URLPathTemplate, path)
 	}
 
 	// Check query parameters
 	if len(m.Request.QueryParameters) == 0 {
 		result.QueryMatch = true
 	} else {
-		result.QueryMatch = true
-		result.QueryDiffs = make([]string, 0)
-
-		for paramName, matcher := range m.Request.QueryParameters {
-			expectedValues := getExpectedValues(matcher)
-
-			var actualValues []string
-			queryArgs.VisitAll(func(key, value []byte) {
-				if string(key) == paramName {
-					actualValues = append(actualValues, string(value))
-				}
-			})
-
-			if !matchQueryParam(expectedValues, actualValues) {
-				result.QueryMatch = false
-				if len(actualValues) == 0 {
-					result.QueryDiffs = append(result.QueryDiffs,
-						fmt.Sprintf("not_present|%s|%v", paramName, expectedValues))
-				} else {
-					result.QueryDiffs = append(result.QueryDiffs,
-						fmt.Sprintf("mismatch|%s|%v|%s", paramName, expectedValues, strings.Join(actualValues, ",")))
-				}
-			}
-		}
+		result.QueryDiffs = matchParams(m.Request.QueryParameters, query, opts.caseInsensitiveQueryNames)
+		result.QueryMatch = len(result.QueryDiffs) == 0
 	}
 
 	// Check body patterns
 	if len(m.Request.BodyPatterns) == 0 {
 		result.BodyMatch = true
 	} else {
-		result.BodyMatch = matchBodyPatterns(m.Request.BodyPatterns, body)
+		result.BodyMatch = matchBodyPatterns(m.Request.BodyPatterns, body, opts)
 		if !result.BodyMatch {
-			result.BodyDiff = "Body does not match"
+			result.BodyDiff, result.BodyFieldDiffs = bodyDiff(m.Request.BodyPatterns, body, opts)
+		}
+	}
+
+	// Check form parameters - only urlencoded bodies carry form fields
+	if len(m.Request.FormParameters) > 0 {
+		if diffs := matchParams(m.Request.FormParameters, formValues(reqHeaders, body), false); len(diffs) > 0 {
+			result.BodyMatch = false
+			if result.BodyDiff == "" {
+				result.BodyDiff = "Form parameters do not match: " + strings.Join(diffs, "; ")
+			}
 		}
 	}
 
@@ -139,72 +223,859 @@ func evaluateMapping(m *types.Mapping, method, path, fullURI string, queryArgs *
 		result.HeaderDiffs = make([]string, 0)
 
 		for headerName, matcher := range m.Request.Headers {
-			actualValue := string(reqHeaders.Peek(headerName))
-			if !matchHeader(matcher, actualValue) {
+			actualValues := headerValues(reqHeaders, headerName)
+			if !matchHeaderValues(matcher, actualValues) {
 				result.HeaderMatch = false
+				actualValue := strings.Join(actualValues, ",")
 				if actualValue == "" {
 					result.HeaderDiffs = append(result.HeaderDiffs,
-						fmt.Sprintf("not_present|%s|%s", headerName, matcher.EqualTo))
+						fmt.Sprintf("not_present|%s|%s", headerName, describeHeaderMatcher(matcher)))
+				} else {
+					result.HeaderDiffs = append(result.HeaderDiffs,
+						fmt.Sprintf("mismatch|%s|%s|%s", headerName, describeHeaderMatcher(matcher), actualValue))
+				}
+			}
+		}
+	}
+
+	// Check cookies - parsed from the Cookie header, reported alongside header diffs
+	if len(m.Request.Cookies) > 0 {
+		if result.HeaderDiffs == nil {
+			result.HeaderDiffs = make([]string, 0)
+		}
+		cookies := cookieValues(reqHeaders)
+		for cookieName, matcher := range m.Request.Cookies {
+			actualValues := cookies[cookieName]
+			if !matchHeaderValues(matcher, actualValues) {
+				result.HeaderMatch = false
+				label := "Cookie " + cookieName
+				if len(actualValues) == 0 {
+					result.HeaderDiffs = append(result.HeaderDiffs,
+						fmt.Sprintf("not_present|%s|%s", label, describeHeaderMatcher(matcher)))
 				} else {
 					result.HeaderDiffs = append(result.HeaderDiffs,
-						fmt.Sprintf("mismatch|%s|%s|%s", headerName, matcher.EqualTo, actualValue))
+						fmt.Sprintf("mismatch|%s|%s|%s", label, describeHeaderMatcher(matcher), strings.Join(actualValues, ",")))
 				}
 			}
 		}
 	}
 
-	result.Matched = result.MethodMatch && result.URLMatch && result.QueryMatch && result.BodyMatch && result.HeaderMatch
+	// Check basic auth credentials - a missing or malformed header is a mismatch
+	if m.Request.BasicAuth != nil {
+		if result.HeaderDiffs == nil {
+			result.HeaderDiffs = make([]string, 0)
+		}
+		if diff := matchBasicAuth(m.Request.BasicAuth, string(reqHeaders.Peek("Authorization"))); diff != "" {
+			result.HeaderMatch = false
+			result.HeaderDiffs = append(result.HeaderDiffs, diff)
+		}
+	}
+
+	// Check scheme and virtual host - reported alongside header diffs
+	if m.Request.Scheme != "" || m.Request.Host != "" {
+		if result.HeaderDiffs == nil {
+			result.HeaderDiffs = make([]string, 0)
+		}
+		if m.Request.Scheme != "" {
+			if actual := requestScheme(reqHeaders); !strings.EqualFold(m.Request.Scheme, actual) {
+				result.HeaderMatch = false
+				result.HeaderDiffs = append(result.HeaderDiffs, fmt.Sprintf("mismatch|Scheme|%s|%s", m.Request.Scheme, actual))
+			}
+		}
+		if m.Request.Host != "" {
+			if diff := matchHost(m.Request.Host, string(reqHeaders.Host())); diff != "" {
+				result.HeaderMatch = false
+				result.HeaderDiffs = append(result.HeaderDiffs, diff)
+			}
+		}
+	}
+
+	result.Matched = result.MethodMatch && result.URLMatch && result.QueryMatch && result.BodyMatch && result.HeaderMatch && result.ScenarioMatch
 	return result
 }
 
+// matchURLRegex matches a urlPattern or urlPathPattern and returns its capture groups
+// for response templating, with the whole match first. Named groups are also stored as
+// path variables on result. Patterns without groups skip the submatch search.
+func matchURLRegex(re *regexp.Regexp, s string, result *types.MatchResult) ([]string, bool) {
+	if re.NumSubexp() == 0 {
+		return nil, re.MatchString(s)
+	}
+	groups := re.FindStringSubmatch(s)
+	if groups == nil {
+		return nil, false
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			if result.PathVariables == nil {
+				result.PathVariables = make(map[string]string)
+			}
+			result.PathVariables[name] = groups[i]
+		}
+	}
+	return groups, true
+}
+
 // matchBodyPatterns checks if the request body matches all body patterns
-func matchBodyPatterns(patterns []types.BodyPattern, body []byte) bool {
+func matchBodyPatterns(patterns []types.BodyPattern, body []byte, opts matchOptions) bool {
 	for _, pattern := range patterns {
-		if pattern.EqualToJSON != nil {
-			if !jsonEqual(pattern.EqualToJSON, body) {
-				return false
+		if !matchBodyPattern(pattern, body, opts) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchBodyPattern checks a single body pattern. Every matcher set on the pattern
+// must hold, all of its And sub-patterns must match, and at least one of its Or
+// sub-patterns must match.
+func matchBodyPattern(pattern types.BodyPattern, body []byte, opts matchOptions) bool {
+	if pattern.EqualToJSON != nil {
+		ignoreArrayOrder := pattern.IgnoreArrayOrder != nil && *pattern.IgnoreArrayOrder
+		ignoreExtraElements := pattern.IgnoreExtraElements != nil && *pattern.IgnoreExtraElements
+		if !jsonEqual(equalToJSONDocument(pattern), body, ignoreArrayOrder, ignoreExtraElements, opts.numberTolerance) {
+			return false
+		}
+	}
+	if pattern.MatchesJsonSchema != nil {
+		if validateJSONSchema(pattern.MatchesJsonSchema, body) != "" {
+			return false
+		}
+	}
+	if pattern.MatchesJsonPath != "" {
+		if !matchJSONPath(pattern.MatchesJsonPath, body) {
+			return false
+		}
+	}
+	if pattern.EqualToXML != "" {
+		if !xmlEqual(pattern.EqualToXML, body) {
+			return false
+		}
+	}
+	if pattern.EqualTo != "" {
+		expected, actual := equalToOperands(pattern, body)
+		if !stringEqual(expected, actual, pattern.CaseInsensitive) {
+			return false
+		}
+	}
+	if pattern.Contains != "" {
+		if !strings.Contains(string(body), pattern.Contains) {
+			return false
+		}
+	}
+	if pattern.DoesNotContain != "" {
+		if strings.Contains(string(body), pattern.DoesNotContain) {
+			return false
+		}
+	}
+	if pattern.Matches != "" {
+		re := compileCached(pattern.Matches)
+		if re == nil || !re.Match(body) {
+			return false
+		}
+	}
+	if pattern.DoesNotMatch != "" {
+		re := compileCached(pattern.DoesNotMatch)
+		if re == nil || re.Match(body) {
+			return false
+		}
+	}
+	if pattern.AnythingButEmpty && len(body) == 0 {
+		return false
+	}
+	if len(pattern.JsonPathMatchers) > 0 && !matchJSONPathMatchers(pattern.JsonPathMatchers, body) {
+		return false
+	}
+	if pattern.BinaryEqualTo != "" {
+		expected := decodeBinaryCached(pattern.BinaryEqualTo)
+		if expected == nil || !bytes.Equal(expected, body) {
+			return false
+		}
+	}
+	if len(pattern.And) > 0 && !matchBodyPatterns(pattern.And, body, opts) {
+		return false
+	}
+	if len(pattern.Or) > 0 {
+		for _, alternative := range pattern.Or {
+			if matchBodyPattern(alternative, body, opts) {
+				return true
 			}
 		}
+		return false
 	}
 	return true
 }
 
-// jsonEqual compares two JSON values for equality.
-// In WireMock mappings, equalToJson can be either a JSON object or a JSON string
-// containing JSON (e.g. "{\"key\":\"value\"}"). We handle both cases.
-func jsonEqual(expected json.RawMessage, actual []byte) bool {
-	var expectedVal, actualVal interface{}
-	if err := json.Unmarshal(expected, &expectedVal); err != nil {
+// bodyDiff describes why the body did not match. Schema violations, equalToJson
+// field differences, a missing body and equalTo byte differences are reported in
+// detail, in that order; other body matchers only report that the body differs.
+func bodyDiff(patterns []types.BodyPattern, body []byte, opts matchOptions) (string, []types.BodyFieldDiff) {
+	for _, pattern := range patterns {
+		if pattern.MatchesJsonSchema == nil {
+			continue
+		}
+		if violation := validateJSONSchema(pattern.MatchesJsonSchema, body); violation != "" {
+			return "Body does not match schema: " + violation, nil
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern.EqualToJSON == nil {
+			continue
+		}
+		ignoreArrayOrder := pattern.IgnoreArrayOrder != nil && *pattern.IgnoreArrayOrder
+		ignoreExtraElements := pattern.IgnoreExtraElements != nil && *pattern.IgnoreExtraElements
+		if jsonEqual(equalToJSONDocument(pattern), body, ignoreArrayOrder, ignoreExtraElements, opts.numberTolerance) {
+			continue
+		}
+		if diffs := jsonBodyDiffs(equalToJSONDocument(pattern), body, ignoreArrayOrder, ignoreExtraElements, opts.numberTolerance); len(diffs) > 0 {
+			return "Body does not match: " + describeBodyFieldDiffs(diffs), diffs
+		}
+		var doc any
+		if json.Unmarshal(body, &doc) != nil {
+			return "Body does not match: body is not valid JSON", nil
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern.AnythingButEmpty && len(body) == 0 {
+			return "Body does not match: body is empty", nil
+		}
+	}
+	for _, pattern := range patterns {
+		if pattern.EqualTo == "" || pattern.CaseInsensitive {
+			continue
+		}
+		if expected, actual := equalToOperands(pattern, body); expected != actual {
+			return "Body does not match " + byteDiff(expected, actual), nil
+		}
+	}
+	return "Body does not match", nil
+}
+
+// equalURL compares a stub's url or urlPath with the request's, ignoring a trailing
+// slash on the path part when normalizeTrailingSlash is set.
+func equalURL(expected, actual string, normalizeTrailingSlash bool) bool {
+	if expected == actual {
+		return true
+	}
+	return normalizeTrailingSlash && trimTrailingSlash(expected) == trimTrailingSlash(actual)
+}
+
+// trimTrailingSlash drops one trailing slash from the path of a URI, keeping its query
+// string and the root path "/".
+func trimTrailingSlash(uri string) string {
+	path, query, hasQuery := strings.Cut(uri, "?")
+	if len(path) > 1 && strings.HasSuffix(path, "/") {
+		path = path[:len(path)-1]
+	}
+	if hasQuery {
+		return path + "?" + query
+	}
+	return path
+}
+
+// matchMethod checks the request method against the stub's methodPattern regex if
+// set, otherwise against its method: a single method, a comma-separated list such
+// as "GET,HEAD", or "ANY" for every method.
+func matchMethod(req *types.Request, method string) bool {
+	if req.MethodPattern != "" {
+		re := compileCached(methodRegex(req.MethodPattern))
+		return re != nil && re.MatchString(method)
+	}
+	for _, candidate := range strings.Split(req.Method, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strings.EqualFold(candidate, method) || strings.EqualFold(candidate, "ANY") {
+			return true
+		}
+	}
+	return false
+}
+
+// methodRegex anchors a methodPattern so it must match the whole method name.
+func methodRegex(pattern string) string {
+	return "^(?:" + pattern + ")$"
+}
+
+// tieBreaksBefore orders equally ranked stubs so the winner doesn't depend on load order:
+// a literal url or urlPath beats a pattern, then stubs are ordered by name, then id.
+// Stubs that tie on all of these keep load order.
+func tieBreaksBefore(m, other *types.Mapping) bool {
+	if literal, otherLiteral := hasLiteralURL(m), hasLiteralURL(other); literal != otherLiteral {
+		return literal
+	}
+	if m.Name != other.Name {
+		return m.Name < other.Name
+	}
+	return mappingID(m) < mappingID(other)
+}
+
+func hasLiteralURL(m *types.Mapping) bool {
+	return m.Request.URL != "" || m.Request.URLPath != ""
+}
+
+// mappingID returns the mapping's id, or its uuid if it only has that.
+func mappingID(m *types.Mapping) string {
+	if m.ID != "" {
+		return m.ID
+	}
+	return m.UUID
+}
+
+// stringEqual compares the raw body to the expected value byte-for-byte,
+// optionally ignoring case.
+func stringEqual(expected, actual string, caseInsensitive bool) bool {
+	if caseInsensitive {
+		return strings.ToLower(expected) == strings.ToLower(actual)
+	}
+	return expected == actual
+}
+
+// equalToOperands returns the stub's equalTo text and the request body as compared,
+// trimmed of surrounding whitespace when the pattern asks for it.
+func equalToOperands(pattern types.BodyPattern, body []byte) (expected, actual string) {
+	if pattern.IgnoreSurroundingWhitespace {
+		return strings.TrimSpace(pattern.EqualTo), strings.TrimSpace(string(body))
+	}
+	return pattern.EqualTo, string(body)
+}
+
+// matchJSONPath parses the body as JSON and checks that the expression selects at least one node.
+func matchJSONPath(expr string, body []byte) bool {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return false
+	}
+	matched, err := jsonPathMatches(expr, doc)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return false
+	}
+	return matched
+}
+
+// matchJSONPathMatchers reports whether the body is JSON and every path matcher holds.
+func matchJSONPathMatchers(matchers []types.JsonPathMatcher, body []byte) bool {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
 		return false
 	}
-	// If equalToJson was stored as a string, parse it as JSON
-	if str, ok := expectedVal.(string); ok {
-		if err := json.Unmarshal([]byte(str), &expectedVal); err != nil {
+	for _, matcher := range matchers {
+		steps, err := parseJSONPath(matcher.Path)
+		if err != nil {
+			log.Printf("Warning: %v", err)
+			return false
+		}
+		if !slices.ContainsFunc(evalJSONPath(doc, steps), func(node any) bool { return jsonPathValueMatches(matcher, node) }) {
 			return false
 		}
 	}
+	return true
+}
+
+// jsonPathValueMatches checks a node selected by a path matcher against its equalTo,
+// contains and matches. Strings are compared as-is, other values as compact JSON.
+func jsonPathValueMatches(matcher types.JsonPathMatcher, node any) bool {
+	value, ok := node.(string)
+	if !ok {
+		data, err := json.Marshal(node)
+		if err != nil {
+			return false
+		}
+		value = string(data)
+	}
+	if matcher.EqualTo != "" && value != matcher.EqualTo {
+		return false
+	}
+	if matcher.Contains != "" && !strings.Contains(value, matcher.Contains) {
+		return false
+	}
+	if matcher.Matches != "" {
+		re := compileCached(matcher.Matches)
+		if re == nil || !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// equalToJSONDocument returns the JSON document an equalToJson pattern expects.
+// In WireMock mappings, equalToJson can be either a JSON value or a JSON string
+// containing JSON (e.g. "{\"key\":\"value\"}"), which is how recordings store it.
+// Only a top-level string is decoded, and not with equalToJsonLiteral, which
+// expects the body to be that JSON string itself.
+func equalToJSONDocument(pattern types.BodyPattern) json.RawMessage {
+	var str string
+	if !pattern.EqualToJSONLiteral && json.Unmarshal(pattern.EqualToJSON, &str) == nil {
+		return json.RawMessage(str)
+	}
+	return pattern.EqualToJSON
+}
+
+// jsonEqual compares two JSON documents for equality. String values in expected may be
+// JSON Unit placeholders such as "${json-unit.any-string}".
+func jsonEqual(expected json.RawMessage, actual []byte, ignoreArrayOrder, ignoreExtraElements bool, tolerance float64) bool {
+	if !ignoreExtraElements && tolerance == 0 && !bytes.Contains(expected, []byte(jsonUnitPrefix)) {
+		// Equal values have equal canonical forms; sorting arrays makes order irrelevant
+		expectedCanonical, err := jsonutil.Canonicalize(expected, ignoreArrayOrder)
+		if err != nil {
+			return false
+		}
+		actualCanonical, err := jsonutil.Canonicalize(actual, ignoreArrayOrder)
+		return err == nil && bytes.Equal(expectedCanonical, actualCanonical)
+	}
+	var expectedVal, actualVal any
+	if err := json.Unmarshal(expected, &expectedVal); err != nil {
+		return false
+	}
 	if err := json.Unmarshal(actual, &actualVal); err != nil {
 		return false
 	}
-	expectedNorm, err1 := json.Marshal(expectedVal)
-	actualNorm, err2 := json.Marshal(actualVal)
-	if err1 != nil || err2 != nil {
+	return jsonValuesEqual(expectedVal, actualVal, ignoreArrayOrder, ignoreExtraElements, tolerance)
+}
+
+// jsonValuesEqual recursively compares two decoded JSON values.
+// ignoreArrayOrder treats arrays as multisets (element counts must still agree);
+// ignoreExtraElements allows the actual object to carry keys the expected one lacks;
+// numbers may differ by up to tolerance.
+func jsonValuesEqual(expected, actual any, ignoreArrayOrder, ignoreExtraElements bool, tolerance float64) bool {
+	if matched, ok := matchJSONUnitPlaceholder(expected, actual); ok {
+		return matched
+	}
+	switch exp := expected.(type) {
+	case map[string]any:
+		act, ok := actual.(map[string]any)
+		if !ok {
+			return false
+		}
+		if !ignoreExtraElements && len(exp) != len(act) {
+			return false
+		}
+		for k, ev := range exp {
+			av, exists := act[k]
+			if !exists || !jsonValuesEqual(ev, av, ignoreArrayOrder, ignoreExtraElements, tolerance) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		act, ok := actual.([]any)
+		if !ok || len(exp) != len(act) {
+			return false
+		}
+		if ignoreArrayOrder {
+			return unorderedElementsEqual(exp, act, ignoreArrayOrder, ignoreExtraElements, tolerance)
+		}
+		for i := range exp {
+			if !jsonValuesEqual(exp[i], act[i], ignoreArrayOrder, ignoreExtraElements, tolerance) {
+				return false
+			}
+		}
+		return true
+	case float64:
+		act, ok := actual.(float64)
+		return ok && numbersEqual(exp, act, tolerance)
+	default:
+		return expected == actual
+	}
+}
+
+// jsonUnitPrefix starts the JSON Unit placeholders WireMock accepts as string values
+// in equalToJson, e.g. "${json-unit.any-string}".
+const jsonUnitPrefix = "${json-unit."
+
+// matchJSONUnitPlaceholder checks actual against expected when expected is a JSON Unit
+// placeholder: any-string, any-number and any-boolean require a value of that type,
+// ignore accepts any value. ok is false when expected is not a placeholder.
+func matchJSONUnitPlaceholder(expected, actual any) (matched, ok bool) {
+	s, isString := expected.(string)
+	if !isString || !strings.HasPrefix(s, jsonUnitPrefix) {
+		return false, false
+	}
+	switch s {
+	case "${json-unit.any-string}":
+		_, matched = actual.(string)
+	case "${json-unit.any-number}":
+		_, matched = actual.(float64)
+	case "${json-unit.any-boolean}":
+		_, matched = actual.(bool)
+	case "${json-unit.ignore}":
+		matched = true
+	default:
+		return false, false // unknown placeholders compare as plain strings
+	}
+	return matched, true
+}
+
+// numbersEqual compares two decoded JSON numbers; tolerance is the largest difference
+// at which they still compare equal, 0 requiring exact equality.
+func numbersEqual(expected, actual, tolerance float64) bool {
+	return expected == actual || math.Abs(expected-actual) <= tolerance
+}
+
+// unorderedElementsEqual reports whether every expected element can be paired with a
+// distinct actual element. Uses augmenting paths so that relaxed element comparison
+// (e.g. ignoreExtraElements) can't be defeated by an unlucky greedy pairing.
+func unorderedElementsEqual(expected, actual []any, ignoreArrayOrder, ignoreExtraElements bool, tolerance float64) bool {
+	pairedWith := make([]int, len(actual)) // actual index -> expected index, -1 if free
+	for i := range pairedWith {
+		pairedWith[i] = -1
+	}
+
+	var tryPair func(ei int, visited []bool) bool
+	tryPair = func(ei int, visited []bool) bool {
+		for ai := range actual {
+			if visited[ai] || !jsonValuesEqual(expected[ei], actual[ai], ignoreArrayOrder, ignoreExtraElements, tolerance) {
+				continue
+			}
+			visited[ai] = true
+			if pairedWith[ai] == -1 || tryPair(pairedWith[ai], visited) {
+				pairedWith[ai] = ei
+				return true
+			}
+		}
 		return false
 	}
-	return string(expectedNorm) == string(actualNorm)
+
+	for ei := range expected {
+		if !tryPair(ei, make([]bool, len(actual))) {
+			return false
+		}
+	}
+	return true
 }
 
 // matchHeader checks if an actual header value matches the expected matcher
 func matchHeader(matcher types.HeaderMatcher, actual string) bool {
+	if matcher.Absent {
+		return actual == ""
+	}
 	if matcher.EqualTo != "" {
-		return matcher.EqualTo == actual
+		return stringEqual(matcher.EqualTo, actual, matcher.CaseInsensitive)
 	}
 	if matcher.Contains != "" {
+		if matcher.CaseInsensitive {
+			return strings.Contains(strings.ToLower(actual), strings.ToLower(matcher.Contains))
+		}
 		return strings.Contains(actual, matcher.Contains)
 	}
+	if matcher.Matches != "" {
+		re := compileCached(matcher.Matches)
+		return re != nil && re.MatchString(actual)
+	}
+	if matcher.DoesNotMatch != "" {
+		re := compileCached(matcher.DoesNotMatch)
+		return re != nil && !re.MatchString(actual)
+	}
 	return true
 }
 
+// headerValues returns every value sent for a header, in request order.
+func headerValues(h *fasthttp.RequestHeader, name string) []string {
+	raw := h.PeekAll(name)
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		values = append(values, string(v))
+	}
+	return values
+}
+
+// matchBasicAuth checks an Authorization header against expected Basic credentials and
+// returns a header diff entry, or "" if they match. The password is never echoed.
+func matchBasicAuth(expected *types.BasicAuthCredentials, authorization string) string {
+	want := "basicAuth " + expected.Username
+	if authorization == "" {
+		return fmt.Sprintf("not_present|Authorization|%s", want)
+	}
+	scheme, encoded, _ := strings.Cut(authorization, " ")
+	if !strings.EqualFold(scheme, "Basic") {
+		return fmt.Sprintf("mismatch|Authorization|%s|%s scheme", want, scheme)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Sprintf("mismatch|Authorization|%s|malformed credentials", want)
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return fmt.Sprintf("mismatch|Authorization|%s|malformed credentials", want)
+	}
+	if username != expected.Username || password != expected.Password {
+		return fmt.Sprintf("mismatch|Authorization|%s|basicAuth %s", want, username)
+	}
+	return ""
+}
+
+// requestScheme returns the scheme the client used, taken from the first X-Forwarded-Proto
+// value. Requests without the header are assumed to be plain http.
+func requestScheme(h *fasthttp.RequestHeader) string {
+	proto, _, _ := strings.Cut(string(h.Peek("X-Forwarded-Proto")), ",")
+	if proto = strings.TrimSpace(proto); proto == "" {
+		return "http"
+	}
+	return proto
+}
+
+// matchHost compares a Host header against an expected host, ignoring case, and returns
+// a header diff entry or "". The port is ignored unless expected specifies one.
+func matchHost(expected, host string) string {
+	if host == "" {
+		return fmt.Sprintf("not_present|Host|%s", expected)
+	}
+	actual := host
+	if !strings.Contains(expected, ":") {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			actual = h
+		}
+	}
+	if !strings.EqualFold(expected, actual) {
+		return fmt.Sprintf("mismatch|Host|%s|%s", expected, host)
+	}
+	return ""
+}
+
+// cookieValues parses the request's Cookie headers into name -> values.
+func cookieValues(h *fasthttp.RequestHeader) map[string][]string {
+	cookies := make(map[string][]string)
+	h.VisitAllCookie(func(key, value []byte) {
+		cookies[string(key)] = append(cookies[string(key)], string(value))
+	})
+	return cookies
+}
+
+// matchHeaderValues applies a header matcher to all values of a header. Any matching
+// value is enough unless MatchAllValues is set; absent requires that none was sent.
+func matchHeaderValues(matcher types.HeaderMatcher, values []string) bool {
+	if matcher.Absent {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return matchHeader(matcher, "")
+	}
+	for _, v := range values {
+		ok := matchHeader(matcher, v)
+		if matcher.MatchAllValues && !ok {
+			return false
+		}
+		if !matcher.MatchAllValues && ok {
+			return true
+		}
+	}
+	return matcher.MatchAllValues
+}
+
+// describeHeaderMatcher renders a header matcher for mismatch diagnostics (e.g. "equalTo foo").
+func describeHeaderMatcher(matcher types.HeaderMatcher) string {
+	switch {
+	case matcher.Absent:
+		return "absent"
+	case matcher.EqualTo != "":
+		return "equalTo " + matcher.EqualTo
+	case matcher.Contains != "":
+		return "contains " + matcher.Contains
+	case matcher.Matches != "":
+		return "matches " + matcher.Matches
+	case matcher.DoesNotMatch != "":
+		return "doesNotMatch " + matcher.DoesNotMatch
+	}
+	return "anything"
+}
+
+// regexCache holds compiled matcher regexes keyed by pattern. Invalid patterns are
+// stored as nil so the compile error is only logged once.
+var regexCache sync.Map
+
+// compileCached compiles a matcher regex once and reuses it on subsequent requests.
+// Returns nil for invalid patterns, which callers must treat as a non-match.
+func compileCached(pattern string) *regexp.Regexp {
+	if cached, ok := regexCache.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("Warning: invalid regex %q in stub matcher: %v", pattern, err)
+		re = nil
+	}
+	actual, _ := regexCache.LoadOrStore(pattern, re)
+	return actual.(*regexp.Regexp)
+}
+
+// binaryCache holds decoded binaryEqualTo bodies keyed by their base64 encoding.
+// Invalid encodings are stored as nil so the decode error is only logged once.
+var binaryCache sync.Map
+
+// decodeBinaryCached decodes a binaryEqualTo pattern once and reuses it on subsequent
+// requests. Returns nil for invalid base64, which callers must treat as a non-match.
+func decodeBinaryCached(encoded string) []byte {
+	if cached, ok := binaryCache.Load(encoded); ok {
+		return cached.([]byte)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Printf("Warning: invalid base64 in binaryEqualTo stub matcher: %v", err)
+		decoded = nil
+	}
+	actual, _ := binaryCache.LoadOrStore(encoded, decoded)
+	return actual.([]byte)
+}
+
+// Precompile compiles a mapping's URL, header and body regexes, JSON schemas and path template
+// into the shared caches, so invalid patterns are reported when the mapping is
+// loaded rather than on its first request.
+func Precompile(m *types.Mapping) {
+	for _, pattern := range []string{m.Request.URLPattern, m.Request.URLPathPattern} {
+		if pattern != "" {
+			compileCached(pattern)
+		}
+	}
+	if m.Request.URLPathTemplate != "" {
+		compilePathTemplateCached(m.Request.URLPathTemplate)
+	}
+	if m.Request.URLPathGlob != "" {
+		compileCached(globRegex(m.Request.URLPathGlob))
+	}
+	if m.Request.MethodPattern != "" {
+		compileCached(methodRegex(m.Request.MethodPattern))
+	}
+	for _, params := range []map[string]types.QueryParamMatcher{m.Request.QueryParameters, m.Request.FormParameters} {
+		for _, q := range params {
+			if q.Matches != "" {
+				compileCached(q.Matches)
+			}
+		}
+	}
+	for _, headers := range []map[string]types.HeaderMatcher{m.Request.Headers, m.Request.Cookies} {
+		for _, h := range headers {
+			for _, pattern := range []string{h.Matches, h.DoesNotMatch} {
+				if pattern != "" {
+					compileCached(pattern)
+				}
+			}
+		}
+	}
+	precompileBodyPatterns(m.Request.BodyPatterns)
+}
+
+// precompileBodyPatterns compiles body regexes, including those nested in and/or.
+func precompileBodyPatterns(patterns []types.BodyPattern) {
+	for _, bp := range patterns {
+		for _, pattern := range []string{bp.Matches, bp.DoesNotMatch} {
+			if pattern != "" {
+				compileCached(pattern)
+			}
+		}
+		if bp.MatchesJsonSchema != nil {
+			compileSchemaCached(bp.MatchesJsonSchema)
+		}
+		if bp.BinaryEqualTo != "" {
+			decodeBinaryCached(bp.BinaryEqualTo)
+		}
+		for _, matcher := range bp.JsonPathMatchers {
+			if matcher.Matches != "" {
+				compileCached(matcher.Matches)
+			}
+		}
+		precompileBodyPatterns(bp.And)
+		precompileBodyPatterns(bp.Or)
+	}
+}
+
+// matchParams checks named parameter values against their matchers and returns
+// one diff per failed matcher, or nil if all of them match. With foldNames, a
+// matcher sees the values of every parameter whose name equals its own ignoring case.
+func matchParams(matchers map[string]types.QueryParamMatcher, values map[string][]string, foldNames bool) []string {
+	var diffs []string
+	for paramName, matcher := range matchers {
+		actualValues := values[paramName]
+		if foldNames {
+			actualValues = foldedValues(values, paramName)
+		}
+
+		if matcher.Absent {
+			if len(actualValues) > 0 {
+				diffs = append(diffs,
+					fmt.Sprintf("mismatch|%s|absent|%s", paramName, strings.Join(actualValues, ",")))
+			}
+			continue
+		}
+
+		if matcher.Matches != "" || matcher.Contains != "" {
+			if !matchQueryParamAny(matcher, actualValues) {
+				if len(actualValues) == 0 {
+					diffs = append(diffs,
+						fmt.Sprintf("not_present|%s|%s", paramName, describeQueryMatcher(matcher)))
+				} else {
+					diffs = append(diffs,
+						fmt.Sprintf("mismatch|%s|%s|%s", paramName, describeQueryMatcher(matcher), strings.Join(actualValues, ",")))
+				}
+			}
+			continue
+		}
+
+		if len(matcher.Includes) > 0 {
+			included := make([]string, 0, len(matcher.Includes))
+			for _, m := range matcher.Includes {
+				included = append(included, m.EqualTo)
+			}
+			if !includesValues(included, actualValues, matcher.CaseInsensitive) {
+				if len(actualValues) == 0 {
+					diffs = append(diffs,
+						fmt.Sprintf("not_present|%s|includes %v", paramName, included))
+				} else {
+					diffs = append(diffs,
+						fmt.Sprintf("mismatch|%s|includes %v|%s", paramName, included, strings.Join(actualValues, ",")))
+				}
+			}
+			continue
+		}
+
+		expectedValues := getExpectedValues(matcher)
+		if !matchQueryParam(expectedValues, actualValues, matcher.CaseInsensitive, matcher.OrderSensitive) {
+			expectation := "exactly"
+			if matcher.OrderSensitive {
+				expectation = "exactly in order"
+			}
+			if len(actualValues) == 0 {
+				diffs = append(diffs,
+					fmt.Sprintf("not_present|%s|%s %v", paramName, expectation, expectedValues))
+			} else {
+				diffs = append(diffs,
+					fmt.Sprintf("mismatch|%s|%s %v|%s", paramName, expectation, expectedValues, strings.Join(actualValues, ",")))
+			}
+		}
+	}
+	return diffs
+}
+
+// foldedValues collects the values of every parameter named name, ignoring case.
+// Names are visited in sorted order so the values come out in a stable order.
+func foldedValues(values map[string][]string, name string) []string {
+	var names []string
+	for key := range values {
+		if strings.EqualFold(key, name) {
+			names = append(names, key)
+		}
+	}
+	if len(names) == 1 {
+		return values[names[0]]
+	}
+	sort.Strings(names)
+	var folded []string
+	for _, key := range names {
+		folded = append(folded, values[key]...)
+	}
+	return folded
+}
+
+// formValues parses an application/x-www-form-urlencoded body into its fields.
+// Any other content type yields no fields.
+func formValues(h *fasthttp.RequestHeader, body []byte) map[string][]string {
+	contentType := string(h.ContentType())
+	if mediaType, _, _ := strings.Cut(contentType, ";"); !strings.EqualFold(strings.TrimSpace(mediaType), "application/x-www-form-urlencoded") {
+		return nil
+	}
+	var args fasthttp.Args
+	args.ParseBytes(body)
+	return queryValues(&args)
+}
+
 // getExpectedValues extracts expected values from a query param matcher
 func getExpectedValues(matcher types.QueryParamMatcher) []string {
 	if matcher.EqualTo != "" {
@@ -218,21 +1089,80 @@ func getExpectedValues(matcher types.QueryParamMatcher) []string {
 	return values
 }
 
-// matchQueryParam checks if actual values match expected values
-func matchQueryParam(expected, actual []string) bool {
+// matchQueryParamAny checks the matches/contains matchers, which succeed if any value satisfies them.
+func matchQueryParamAny(matcher types.QueryParamMatcher, actual []string) bool {
+	for _, v := range actual {
+		if matcher.Matches != "" {
+			if re := compileCached(matcher.Matches); re != nil && re.MatchString(v) {
+				return true
+			}
+			continue
+		}
+		if matcher.CaseInsensitive {
+			if strings.Contains(strings.ToLower(v), strings.ToLower(matcher.Contains)) {
+				return true
+			}
+		} else if strings.Contains(v, matcher.Contains) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeQueryMatcher renders a matches/contains query matcher for mismatch diagnostics.
+func describeQueryMatcher(matcher types.QueryParamMatcher) string {
+	if matcher.Matches != "" {
+		return "matches " + matcher.Matches
+	}
+	return "contains " + matcher.Contains
+}
+
+// includesValues reports whether every expected value is among the actual values,
+// which may hold others too. A value expected twice must be present twice.
+func includesValues(expected, actual []string, caseInsensitive bool) bool {
+	counts := make(map[string]int, len(actual))
+	for _, v := range actual {
+		if caseInsensitive {
+			v = strings.ToLower(v)
+		}
+		counts[v]++
+	}
+	for _, v := range expected {
+		if caseInsensitive {
+			v = strings.ToLower(v)
+		}
+		if counts[v] == 0 {
+			return false
+		}
+		counts[v]--
+	}
+	return true
+}
+
+// matchQueryParam checks if actual values match expected values, in any order
+// unless ordered is set.
+func matchQueryParam(expected, actual []string, caseInsensitive, ordered bool) bool {
 	if len(expected) != len(actual) {
 		return false
 	}
 
-	sortedExpected := make([]string, len(expected))
-	sortedActual := make([]string, len(actual))
-	copy(sortedExpected, expected)
-	copy(sortedActual, actual)
-	sort.Strings(sortedExpected)
-	sort.Strings(sortedActual)
+	wantValues := make([]string, len(expected))
+	gotValues := make([]string, len(actual))
+	copy(wantValues, expected)
+	copy(gotValues, actual)
+	if caseInsensitive {
+		for i := range wantValues {
+			wantValues[i] = strings.ToLower(wantValues[i])
+			gotValues[i] = strings.ToLower(gotValues[i])
+		}
+	}
+	if !ordered {
+		sort.Strings(wantValues)
+		sort.Strings(gotValues)
+	}
 
-	for i := range sortedExpected {
-		if sortedExpected[i] != sortedActual[i] {
+	for i := range wantValues {
+		if wantValues[i] != gotValues[i] {
 			return false
 		}
 	}
@@ -0,0 +1,2100 @@
+package matching
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/types"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// evaluate runs evaluateMapping for a request built from the given URI, headers and body.
+func evaluate(m types.Mapping, method, uri string, headers map[string]string, body string) types.MatchResult {
+	path := uri
+	var args fasthttp.Args
+	if idx := strings.IndexByte(uri, '?'); idx != -1 {
+		path = uri[:idx]
+		args.Parse(uri[idx+1:])
+	}
+	var h fasthttp.RequestHeader
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return evaluateMapping(&m, matchOptions{}, types.ScenarioStarted, method, path, uri, queryValues(&args), []byte(body), &h)
+}
+
+func TestMatchBodyPatternsEqualToJSON(t *testing.T) {
+	tests := []struct {
+		name                string
+		expected            string
+		body                string
+		ignoreArrayOrder    *bool
+		ignoreExtraElements *bool
+		want                bool
+	}{
+		{
+			name:     "identical bodies",
+			expected: `{"a": [1, 2, 3]}`,
+			body:     `{"a":[1,2,3]}`,
+			want:     true,
+		},
+		{
+			name:     "array order matters by default",
+			expected: `[1, 2, 3]`,
+			body:     `[3, 2, 1]`,
+			want:     false,
+		},
+		{
+			name:             "ignoreArrayOrder matches reordered array",
+			expected:         `[1, 2, 3]`,
+			body:             `[3, 2, 1]`,
+			ignoreArrayOrder: boolPtr(true),
+			want:             true,
+		},
+		{
+			name:             "ignoreArrayOrder recurses into nested arrays",
+			expected:         `{"outer": [[1, 2], ["a", "b"]]}`,
+			body:             `{"outer": [["b", "a"], [2, 1]]}`,
+			ignoreArrayOrder: boolPtr(true),
+			want:             true,
+		},
+		{
+			name:             "ignoreArrayOrder still requires equal duplicate counts",
+			expected:         `[1, 1, 2]`,
+			body:             `[1, 2, 2]`,
+			ignoreArrayOrder: boolPtr(true),
+			want:             false,
+		},
+		{
+			name:             "ignoreArrayOrder does not relax object values",
+			expected:         `{"a": 1, "b": 2}`,
+			body:             `{"a": 2, "b": 1}`,
+			ignoreArrayOrder: boolPtr(true),
+			want:             false,
+		},
+		{
+			name:             "ignoreArrayOrder false keeps strict ordering",
+			expected:         `[1, 2]`,
+			body:             `[2, 1]`,
+			ignoreArrayOrder: boolPtr(false),
+			want:             false,
+		},
+		{
+			name:     "extra elements rejected by default",
+			expected: `{"a": 1}`,
+			body:     `{"a": 1, "b": 2}`,
+			want:     false,
+		},
+		{
+			name:                "ignoreExtraElements allows extra object keys",
+			expected:            `{"a": 1}`,
+			body:                `{"a": 1, "b": 2}`,
+			ignoreExtraElements: boolPtr(true),
+			want:                true,
+		},
+		{
+			name:                "ignoreExtraElements still requires expected keys",
+			expected:            `{"a": 1, "c": 3}`,
+			body:                `{"a": 1, "b": 2}`,
+			ignoreExtraElements: boolPtr(true),
+			want:                false,
+		},
+		{
+			name:                "both flags with reordered array and extra keys",
+			expected:            `{"ids": [3, 1, 2], "filter": {"type": "x"}}`,
+			body:                `{"ids": [1, 2, 3], "filter": {"type": "x", "extra": true}, "page": 1}`,
+			ignoreArrayOrder:    boolPtr(true),
+			ignoreExtraElements: boolPtr(true),
+			want:                true,
+		},
+		{
+			name:                "both flags with mismatched array contents",
+			expected:            `{"ids": [3, 1, 2]}`,
+			body:                `{"ids": [1, 2, 4], "page": 1}`,
+			ignoreArrayOrder:    boolPtr(true),
+			ignoreExtraElements: boolPtr(true),
+			want:                false,
+		},
+		{
+			name:             "equalToJson stored as string",
+			expected:         `"[\"b\", \"a\"]"`,
+			body:             `["a", "b"]`,
+			ignoreArrayOrder: boolPtr(true),
+			want:             true,
+		},
+		{
+			name:     "invalid request body",
+			expected: `{"a": 1}`,
+			body:     `not json`,
+			want:     false,
+		},
+		{
+			name:     "key order and number formatting ignored",
+			expected: `{"b": 1.0, "a": [1e2]}`,
+			body:     `{"a":[100],"b":1}`,
+			want:     true,
+		},
+		{
+			name:                "ignoreExtraElements applies to objects inside arrays",
+			expected:            `{"items": [{"id": 1}, {"id": 2}]}`,
+			body:                `{"items": [{"id": 1, "label": "a"}, {"id": 2, "label": "b"}]}`,
+			ignoreExtraElements: boolPtr(true),
+			want:                true,
+		},
+		{
+			name:     "extra keys inside array elements rejected by default",
+			expected: `[{"a": 1}]`,
+			body:     `[{"a": 1, "b": 2}]`,
+			want:     false,
+		},
+		{
+			name:                "ignoreExtraElements keeps array order strict",
+			expected:            `[{"a": 1}, {"a": 2}]`,
+			body:                `[{"a": 2, "b": 0}, {"a": 1, "b": 0}]`,
+			ignoreExtraElements: boolPtr(true),
+			want:                false,
+		},
+		{
+			name:                "both flags with reordered objects carrying extra keys",
+			expected:            `[{"a": 1}, {"a": 2}]`,
+			body:                `[{"a": 2, "b": 0}, {"a": 1, "c": [1]}]`,
+			ignoreArrayOrder:    boolPtr(true),
+			ignoreExtraElements: boolPtr(true),
+			want:                true,
+		},
+		{
+			// A greedy pairing would give the first actual element to {"a": 1} and leave
+			// nothing for {"a": 1, "b": 2}
+			name:                "both flags pair relaxed elements without greedy mistakes",
+			expected:            `[{"a": 1}, {"a": 1, "b": 2}]`,
+			body:                `[{"a": 1, "b": 2, "c": 3}, {"a": 1, "b": 9}]`,
+			ignoreArrayOrder:    boolPtr(true),
+			ignoreExtraElements: boolPtr(true),
+			want:                true,
+		},
+		{
+			name:                "ignoreExtraElements does not allow extra array elements",
+			expected:            `[{"a": 1}]`,
+			body:                `[{"a": 1}, {"a": 2}]`,
+			ignoreArrayOrder:    boolPtr(true),
+			ignoreExtraElements: boolPtr(true),
+			want:                false,
+		},
+		{
+			name:             "ignoreArrayOrder applies to nested arrays",
+			expected:         `[{"ids": [2, 1]}, {"ids": [3]}]`,
+			body:             `[{"ids": [3]}, {"ids": [1, 2]}]`,
+			ignoreArrayOrder: boolPtr(true),
+			want:             true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []types.BodyPattern{{
+				EqualToJSON:         json.RawMessage(tt.expected),
+				IgnoreArrayOrder:    tt.ignoreArrayOrder,
+				IgnoreExtraElements: tt.ignoreExtraElements,
+			}}
+			if got := matchBodyPatterns(patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsJSONUnitPlaceholders(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		body     string
+		want     bool
+	}{
+		{"any-string matches a string", `{"id": "${json-unit.any-string}", "name": "report"}`, `{"id": "a1b2c3", "name": "report"}`, true},
+		{"any-string rejects a number", `{"id": "${json-unit.any-string}"}`, `{"id": 42}`, false},
+		{"any-number matches int and float", `{"count": "${json-unit.any-number}", "ratio": "${json-unit.any-number}"}`, `{"count": 3, "ratio": 0.25}`, true},
+		{"any-number rejects a numeric string", `{"count": "${json-unit.any-number}"}`, `{"count": "3"}`, false},
+		{"any-boolean", `{"enabled": "${json-unit.any-boolean}"}`, `{"enabled": false}`, true},
+		{"ignore accepts anything", `{"meta": "${json-unit.ignore}"}`, `{"meta": {"ts": 1700000000}}`, true},
+		{"key still required", `{"id": "${json-unit.any-string}", "name": "report"}`, `{"name": "report"}`, false},
+		{"other fields still compared", `{"id": "${json-unit.any-string}", "name": "report"}`, `{"id": "x", "name": "other"}`, false},
+		{"inside arrays", `[{"id": "${json-unit.any-number}"}, {"id": "${json-unit.any-number}"}]`, `[{"id": 1}, {"id": 2}]`, true},
+		{"unknown placeholder is a literal", `{"v": "${json-unit.any-date}"}`, `{"v": "2024-01-01"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []types.BodyPattern{{EqualToJSON: json.RawMessage(tt.expected)}}
+			if got := matchBodyPatterns(patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	diffs := jsonBodyDiffs(json.RawMessage(`{"id": "${json-unit.any-number}", "name": "${json-unit.any-string}"}`), []byte(`{"id": "7", "name": "x"}`), false, false, 0)
+	if len(diffs) != 1 || diffs[0].Path != "$.id" || diffs[0].Kind != "type" {
+		t.Errorf("jsonBodyDiffs() = %+v, want one type diff at $.id", diffs)
+	}
+}
+
+func TestMatchHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher types.HeaderMatcher
+		actual  string
+		want    bool
+	}{
+		{
+			name:    "equalTo exact",
+			matcher: types.HeaderMatcher{EqualTo: "application/json"},
+			actual:  "application/json",
+			want:    true,
+		},
+		{
+			name:    "contains substring",
+			matcher: types.HeaderMatcher{Contains: "json"},
+			actual:  "application/json; charset=utf-8",
+			want:    true,
+		},
+		{
+			name:    "matches bearer token",
+			matcher: types.HeaderMatcher{Matches: `^Bearer .+$`},
+			actual:  "Bearer abc.def.ghi",
+			want:    true,
+		},
+		{
+			name:    "matches rejects basic auth",
+			matcher: types.HeaderMatcher{Matches: `^Bearer .+$`},
+			actual:  "Basic dXNlcjpwYXNz",
+			want:    false,
+		},
+		{
+			name:    "matches rejects empty bearer",
+			matcher: types.HeaderMatcher{Matches: `^Bearer .+$`},
+			actual:  "Bearer ",
+			want:    false,
+		},
+		{
+			name:    "matches is a partial match without anchors",
+			matcher: types.HeaderMatcher{Matches: `Bearer`},
+			actual:  "Token Bearer xyz",
+			want:    true,
+		},
+		{
+			name:    "doesNotMatch inverts",
+			matcher: types.HeaderMatcher{DoesNotMatch: `^Bearer .+$`},
+			actual:  "Basic dXNlcjpwYXNz",
+			want:    true,
+		},
+		{
+			name:    "doesNotMatch rejects matching value",
+			matcher: types.HeaderMatcher{DoesNotMatch: `^Bearer .+$`},
+			actual:  "Bearer abc",
+			want:    false,
+		},
+		{
+			name:    "invalid matches regex fails closed",
+			matcher: types.HeaderMatcher{Matches: `^Bearer (`},
+			actual:  "Bearer (",
+			want:    false,
+		},
+		{
+			name:    "invalid doesNotMatch regex fails closed",
+			matcher: types.HeaderMatcher{DoesNotMatch: `[`},
+			actual:  "anything",
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchHeader(tt.matcher, tt.actual); got != tt.want {
+				t.Errorf("matchHeader() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateMappingAbsent(t *testing.T) {
+	m := types.Mapping{
+		Request: types.Request{
+			Method:  "GET",
+			URLPath: "/api/items",
+			Headers: map[string]types.HeaderMatcher{
+				"X-Debug": {Absent: true},
+			},
+			QueryParameters: map[string]types.QueryParamMatcher{
+				"debug": {Absent: true},
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		uri        string
+		headers    map[string]string
+		wantMatch  bool
+		wantHeader bool
+		wantQuery  bool
+	}{
+		{
+			name:       "header and query both absent",
+			uri:        "/api/items?limit=10",
+			wantMatch:  true,
+			wantHeader: true,
+			wantQuery:  true,
+		},
+		{
+			name:       "header present",
+			uri:        "/api/items",
+			headers:    map[string]string{"X-Debug": "1"},
+			wantMatch:  false,
+			wantHeader: false,
+			wantQuery:  true,
+		},
+		{
+			name:       "query parameter present",
+			uri:        "/api/items?debug=true",
+			wantMatch:  false,
+			wantHeader: true,
+			wantQuery:  false,
+		},
+		{
+			name:       "query parameter present without value",
+			uri:        "/api/items?debug",
+			wantMatch:  false,
+			wantHeader: true,
+			wantQuery:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(m, "GET", tt.uri, tt.headers, "")
+			if result.Matched != tt.wantMatch {
+				t.Errorf("Matched = %v, want %v", result.Matched, tt.wantMatch)
+			}
+			if result.HeaderMatch != tt.wantHeader {
+				t.Errorf("HeaderMatch = %v, want %v (diffs: %v)", result.HeaderMatch, tt.wantHeader, result.HeaderDiffs)
+			}
+			if result.QueryMatch != tt.wantQuery {
+				t.Errorf("QueryMatch = %v, want %v (diffs: %v)", result.QueryMatch, tt.wantQuery, result.QueryDiffs)
+			}
+		})
+	}
+}
+
+func TestAbsentTakesPrecedence(t *testing.T) {
+	m := types.Mapping{
+		Request: types.Request{
+			Method:  "GET",
+			URLPath: "/api/items",
+			Headers: map[string]types.HeaderMatcher{
+				"X-Debug": {Absent: true, EqualTo: "1"},
+			},
+			QueryParameters: map[string]types.QueryParamMatcher{
+				"debug": {Absent: true, EqualTo: "true"},
+			},
+		},
+	}
+
+	if result := evaluate(m, "GET", "/api/items", nil, ""); !result.Matched {
+		t.Errorf("expected match when header and query are absent, got diffs %v %v", result.HeaderDiffs, result.QueryDiffs)
+	}
+	if result := evaluate(m, "GET", "/api/items?debug=true", map[string]string{"X-Debug": "1"}, ""); result.Matched {
+		t.Error("expected no match when values equal to equalTo are present, absent must take precedence")
+	}
+}
+
+func TestMatchBodyPatternsMatchesJsonPath(t *testing.T) {
+	body := `{
+		"exec": {
+			"definition": {
+				"measures": [{"id": "m1"}, {"id": "m2"}],
+				"filters": [],
+				"dimension": null
+			}
+		},
+		"settings": {"limit": 10}
+	}`
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want bool
+	}{
+		{name: "nested key present", path: "$.exec.definition", want: true},
+		{name: "non-empty array present", path: "$.exec.definition.measures", want: true},
+		{name: "empty array selects nothing", path: "$.exec.definition.filters", want: false},
+		{name: "null value selects nothing", path: "$.exec.definition.dimension", want: false},
+		{name: "missing key", path: "$.exec.definition.attributes", want: false},
+		{name: "index within bounds", path: "$.exec.definition.measures[1]", want: true},
+		{name: "index out of bounds", path: "$.exec.definition.measures[2]", want: false},
+		{name: "wildcard over array", path: "$.exec.definition.measures[*].id", want: true},
+		{name: "wildcard over empty array", path: "$.exec.definition.filters[*]", want: false},
+		{name: "bracket key notation", path: "$['settings']['limit']", want: true},
+		{name: "dot wildcard", path: "$.settings.*", want: true},
+		{name: "index on object selects nothing", path: "$.settings[0]", want: false},
+		{name: "invalid expression", path: "exec.definition", want: false},
+		{name: "unterminated subscript", path: "$.exec[0", want: false},
+		{name: "non-JSON body", path: "$.exec", body: "not json", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := body
+			if tt.body != "" {
+				b = tt.body
+			}
+			patterns := []types.BodyPattern{{MatchesJsonPath: tt.path}}
+			if got := matchBodyPatterns(patterns, []byte(b), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsEqualToXML(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		body     string
+		want     bool
+	}{
+		{
+			name:     "identical documents",
+			expected: `<order id="1"><item>apple</item></order>`,
+			body:     `<order id="1"><item>apple</item></order>`,
+			want:     true,
+		},
+		{
+			name:     "attribute order ignored",
+			expected: `<order id="1" currency="EUR"><item sku="a" qty="2"/></order>`,
+			body:     `<order currency="EUR" id="1"><item qty="2" sku="a"/></order>`,
+			want:     true,
+		},
+		{
+			name:     "whitespace between elements ignored",
+			expected: `<order><item>apple</item><item>pear</item></order>`,
+			body:     "<order>\n  <item>apple</item>\n  <item>pear</item>\n</order>\n",
+			want:     true,
+		},
+		{
+			name:     "XML declaration and comments ignored",
+			expected: `<order><item>apple</item></order>`,
+			body:     `<?xml version="1.0" encoding="UTF-8"?><!-- generated --><order><item>apple</item></order>`,
+			want:     true,
+		},
+		{
+			name:     "element text compared exactly",
+			expected: `<order><item>apple</item></order>`,
+			body:     `<order><item> apple</item></order>`,
+			want:     false,
+		},
+		{
+			name:     "attribute value compared exactly",
+			expected: `<order id="1"/>`,
+			body:     `<order id="01"/>`,
+			want:     false,
+		},
+		{
+			name:     "missing attribute",
+			expected: `<order id="1" currency="EUR"/>`,
+			body:     `<order id="1"/>`,
+			want:     false,
+		},
+		{
+			name:     "child order matters",
+			expected: `<order><a/><b/></order>`,
+			body:     `<order><b/><a/></order>`,
+			want:     false,
+		},
+		{
+			name:     "different element name",
+			expected: `<order/>`,
+			body:     `<invoice/>`,
+			want:     false,
+		},
+		{
+			name:     "malformed request body",
+			expected: `<order/>`,
+			body:     `<order>`,
+			want:     false,
+		},
+		{
+			name:     "malformed expected document",
+			expected: `<order><item></order>`,
+			body:     `<order><item/></order>`,
+			want:     false,
+		},
+		{
+			name:     "non-XML body",
+			expected: `<order/>`,
+			body:     `{"order": {}}`,
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []types.BodyPattern{{EqualToXML: tt.expected}}
+			if got := matchBodyPatterns(patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsEqualTo(t *testing.T) {
+	tests := []struct {
+		name            string
+		expected        string
+		caseInsensitive bool
+		trim            bool
+		body            string
+		want            bool
+	}{
+		{name: "exact text", expected: "id,name\n1,foo", body: "id,name\n1,foo", want: true},
+		{name: "trailing newline in request", expected: "id,name\n1,foo", body: "id,name\n1,foo\n", want: false},
+		{name: "trailing newline in stub", expected: "hello\n", body: "hello", want: false},
+		{name: "CRLF vs LF", expected: "a\nb", body: "a\r\nb", want: false},
+		{name: "case differs", expected: "Hello World", body: "hello world", want: false},
+		{name: "case differs with caseInsensitive", expected: "Hello World", caseInsensitive: true, body: "hello WORLD", want: true},
+		{name: "caseInsensitive keeps exact whitespace", expected: "Hello", caseInsensitive: true, body: "hello ", want: false},
+		{name: "JSON body compared as text", expected: `{"a":1}`, body: `{"a": 1}`, want: false},
+		{name: "empty request body", expected: "hello", body: "", want: false},
+		{name: "trimmed: trailing LF in request", expected: "id,name\n1,foo", trim: true, body: "id,name\n1,foo\n", want: true},
+		{name: "trimmed: trailing CRLF in request", expected: "id,name\n1,foo", trim: true, body: "id,name\n1,foo\r\n", want: true},
+		{name: "trimmed: CRLF in request, LF in stub", expected: "hello\n", trim: true, body: "hello\r\n", want: true},
+		{name: "trimmed: trailing newline in stub only", expected: "hello\n\n", trim: true, body: "hello", want: true},
+		{name: "trimmed: leading whitespace", expected: "hello", trim: true, body: "  \thello", want: true},
+		{name: "trimmed: inner CRLF still differs", expected: "a\nb", trim: true, body: "a\r\nb\n", want: false},
+		{name: "trimmed: different text", expected: "hello", trim: true, body: "hello world\n", want: false},
+		{name: "trimmed with caseInsensitive", expected: "Hello", caseInsensitive: true, trim: true, body: "hello \r\n", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []types.BodyPattern{{EqualTo: tt.expected, CaseInsensitive: tt.caseInsensitive, IgnoreSurroundingWhitespace: tt.trim}}
+			if got := matchBodyPatterns(patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsContains(t *testing.T) {
+	body := `{"execution": {"fingerprint": "a1b2c3", "measures": [{"id": "m1"}]}}`
+
+	tests := []struct {
+		name     string
+		patterns []types.BodyPattern
+		want     bool
+	}{
+		{
+			name:     "contains present substring",
+			patterns: []types.BodyPattern{{Contains: `"fingerprint": "a1b2c3"`}},
+			want:     true,
+		},
+		{
+			name:     "contains missing substring",
+			patterns: []types.BodyPattern{{Contains: "ffffff"}},
+			want:     false,
+		},
+		{
+			name:     "doesNotContain missing substring",
+			patterns: []types.BodyPattern{{DoesNotContain: "attributes"}},
+			want:     true,
+		},
+		{
+			name:     "doesNotContain present substring",
+			patterns: []types.BodyPattern{{DoesNotContain: "measures"}},
+			want:     false,
+		},
+		{
+			name: "contains and equalToJson both satisfied",
+			patterns: []types.BodyPattern{
+				{Contains: "a1b2c3"},
+				{EqualToJSON: json.RawMessage(`{"execution": {"measures": [{"id": "m1"}], "fingerprint": "a1b2c3"}}`)},
+			},
+			want: true,
+		},
+		{
+			name: "contains satisfied but equalToJson not",
+			patterns: []types.BodyPattern{
+				{Contains: "a1b2c3"},
+				{EqualToJSON: json.RawMessage(`{"execution": {"fingerprint": "a1b2c3"}}`)},
+			},
+			want: false,
+		},
+		{
+			name: "equalToJson satisfied but contains not",
+			patterns: []types.BodyPattern{
+				{Contains: "zzz"},
+				{EqualToJSON: json.RawMessage(body)},
+			},
+			want: false,
+		},
+		{
+			name: "contains and doesNotContain together",
+			patterns: []types.BodyPattern{
+				{Contains: "measures"},
+				{DoesNotContain: "filters"},
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBodyPatterns(tt.patterns, []byte(body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsAnythingButEmpty(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []types.BodyPattern
+		body     string
+		want     bool
+	}{
+		{name: "non-empty body", patterns: []types.BodyPattern{{AnythingButEmpty: true}}, body: `{"a":1}`, want: true},
+		{name: "whitespace counts as a body", patterns: []types.BodyPattern{{AnythingButEmpty: true}}, body: " ", want: true},
+		{name: "empty body", patterns: []types.BodyPattern{{AnythingButEmpty: true}}, body: "", want: false},
+		{name: "combined with contains", patterns: []types.BodyPattern{{AnythingButEmpty: true}, {Contains: "b"}}, body: "abc", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBodyPatterns(tt.patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if diff, _ := bodyDiff([]types.BodyPattern{{AnythingButEmpty: true}}, nil, matchOptions{}); diff != "Body does not match: body is empty" {
+		t.Errorf("bodyDiff() = %q", diff)
+	}
+}
+
+func TestMatchBodyPatternsBinaryEqualTo(t *testing.T) {
+	blob := []byte{0x08, 0x96, 0x01, 0x12, 0x00, 0xff, 0xfe}
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	tests := []struct {
+		name     string
+		patterns []types.BodyPattern
+		body     []byte
+		want     bool
+	}{
+		{name: "same bytes", patterns: []types.BodyPattern{{BinaryEqualTo: encoded}}, body: blob, want: true},
+		{name: "different byte", patterns: []types.BodyPattern{{BinaryEqualTo: encoded}}, body: []byte{0x08, 0x96, 0x01, 0x12, 0x00, 0xff, 0xfd}, want: false},
+		{name: "extra trailing byte", patterns: []types.BodyPattern{{BinaryEqualTo: encoded}}, body: append(append([]byte{}, blob...), 0x00), want: false},
+		{name: "empty body", patterns: []types.BodyPattern{{BinaryEqualTo: encoded}}, body: nil, want: false},
+		{name: "invalid base64", patterns: []types.BodyPattern{{BinaryEqualTo: "not base64!"}}, body: []byte("not base64!"), want: false},
+		{name: "inside or", patterns: []types.BodyPattern{{Or: []types.BodyPattern{{EqualTo: "x"}, {BinaryEqualTo: encoded}}}}, body: blob, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBodyPatterns(tt.patterns, tt.body, matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsJsonPathMatchers(t *testing.T) {
+	body := `{"user": {"id": 42, "roles": ["viewer", "editor"]}, "query": "SELECT * FROM sales WHERE year = 2024", "limit": null}`
+	userAndQuery := []types.JsonPathMatcher{
+		{Path: "$.user.id", EqualTo: "42"},
+		{Path: "$.query", Matches: "^SELECT .* FROM sales"},
+	}
+	tests := []struct {
+		name     string
+		matchers []types.JsonPathMatcher
+		body     string
+		want     bool
+	}{
+		{name: "both nested fields match", matchers: userAndQuery, body: body, want: true},
+		{name: "one field differs", matchers: userAndQuery, body: strings.Replace(body, `"id": 42`, `"id": 43`, 1), want: false},
+		{name: "field missing", matchers: userAndQuery, body: `{"query": "SELECT * FROM sales"}`, want: false},
+		{name: "any array element", matchers: []types.JsonPathMatcher{{Path: "$.user.roles[*]", EqualTo: "editor"}}, body: body, want: true},
+		{name: "contains", matchers: []types.JsonPathMatcher{{Path: "$.query", Contains: "year = 2024"}}, body: body, want: true},
+		{name: "all conditions on one matcher", matchers: []types.JsonPathMatcher{{Path: "$.query", Contains: "sales", EqualTo: "SELECT"}}, body: body, want: false},
+		{name: "object compared as JSON", matchers: []types.JsonPathMatcher{{Path: "$.user.roles", EqualTo: `["viewer","editor"]`}}, body: body, want: true},
+		{name: "existence only", matchers: []types.JsonPathMatcher{{Path: "$.limit"}}, body: body, want: true},
+		{name: "not JSON", matchers: []types.JsonPathMatcher{{Path: "$.query"}}, body: "query=1", want: false},
+		{name: "invalid path", matchers: []types.JsonPathMatcher{{Path: "query"}}, body: body, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []types.BodyPattern{{JsonPathMatchers: tt.matchers}}
+			if got := matchBodyPatterns(patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsMatches(t *testing.T) {
+	isoDate := `\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})?)?`
+
+	tests := []struct {
+		name     string
+		patterns []types.BodyPattern
+		body     string
+		want     bool
+	}{
+		{
+			name:     "ISO-8601 timestamp embedded in JSON",
+			patterns: []types.BodyPattern{{Matches: isoDate}},
+			body:     `{"event": "login", "at": "2026-03-10T12:34:56.789Z"}`,
+			want:     true,
+		},
+		{
+			name:     "ISO-8601 date in plain text",
+			patterns: []types.BodyPattern{{Matches: isoDate}},
+			body:     "report generated on 2026-03-10 by batch job",
+			want:     true,
+		},
+		{
+			name:     "no date in body",
+			patterns: []types.BodyPattern{{Matches: isoDate}},
+			body:     `{"event": "login"}`,
+			want:     false,
+		},
+		{
+			name:     "date across multiple lines",
+			patterns: []types.BodyPattern{{Matches: `(?s)^begin.*2026-03-10.*end$`}},
+			body:     "begin\nday: 2026-03-10\nend",
+			want:     true,
+		},
+		{
+			name:     "doesNotMatch without date",
+			patterns: []types.BodyPattern{{DoesNotMatch: isoDate}},
+			body:     `{"event": "login"}`,
+			want:     true,
+		},
+		{
+			name:     "doesNotMatch with date",
+			patterns: []types.BodyPattern{{DoesNotMatch: isoDate}},
+			body:     `{"at": "2026-03-10"}`,
+			want:     false,
+		},
+		{
+			name:     "invalid matches regex fails",
+			patterns: []types.BodyPattern{{Matches: `(\d+`}},
+			body:     "123",
+			want:     false,
+		},
+		{
+			name:     "invalid doesNotMatch regex fails",
+			patterns: []types.BodyPattern{{DoesNotMatch: `(\d+`}},
+			body:     "abc",
+			want:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBodyPatterns(tt.patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsLogical(t *testing.T) {
+	// Two equivalent payload shapes for the same export request
+	either := types.BodyPattern{Or: []types.BodyPattern{
+		{EqualToJSON: json.RawMessage(`{"format": "csv", "id": "report1"}`)},
+		{EqualToJSON: json.RawMessage(`{"export": {"format": "csv", "id": "report1"}}`)},
+	}}
+	both := types.BodyPattern{And: []types.BodyPattern{
+		{Contains: "report1"},
+		{MatchesJsonPath: "$.format"},
+	}}
+
+	tests := []struct {
+		name     string
+		patterns []types.BodyPattern
+		body     string
+		want     bool
+	}{
+		{"or first shape", []types.BodyPattern{either}, `{"id": "report1", "format": "csv"}`, true},
+		{"or second shape", []types.BodyPattern{either}, `{"export": {"id": "report1", "format": "csv"}}`, true},
+		{"or neither shape", []types.BodyPattern{either}, `{"id": "report1", "format": "xlsx"}`, false},
+		{"and both hold", []types.BodyPattern{both}, `{"id": "report1", "format": "csv"}`, true},
+		{"and one fails", []types.BodyPattern{both}, `{"id": "report1"}`, false},
+		{"top-level entries still combine with and", []types.BodyPattern{either, {DoesNotContain: "export"}}, `{"export": {"id": "report1", "format": "csv"}}`, false},
+		{"or alongside own matcher", []types.BodyPattern{{Contains: "csv", Or: []types.BodyPattern{{Contains: "report1"}, {Contains: "report2"}}}}, `{"id": "report2", "format": "csv"}`, true},
+		{"nested and inside or", []types.BodyPattern{{Or: []types.BodyPattern{{Contains: "xlsx"}, both}}}, `{"id": "report1", "format": "csv"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBodyPatterns(tt.patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchBodyPatternsJsonSchema(t *testing.T) {
+	schema := json.RawMessage(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"required": ["id", "limit"],
+		"properties": {
+			"id": {"type": "string", "minLength": 1},
+			"limit": {"type": "number", "minimum": 0},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`)
+
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{"valid", `{"id": "report1", "limit": 100}`, true},
+		{"extra properties allowed", `{"id": "x", "limit": 0.5, "offset": 10}`, true},
+		{"valid array items", `{"id": "x", "limit": 1, "tags": ["a", "b"]}`, true},
+		{"numeric id", `{"id": 1, "limit": 100}`, false},
+		{"string limit", `{"id": "report1", "limit": "100"}`, false},
+		{"missing limit", `{"id": "report1"}`, false},
+		{"negative limit", `{"id": "report1", "limit": -1}`, false},
+		{"empty id", `{"id": "", "limit": 1}`, false},
+		{"bad array item", `{"id": "x", "limit": 1, "tags": ["a", 2]}`, false},
+		{"not an object", `["report1", 100]`, false},
+		{"not JSON", `id=report1&limit=100`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := []types.BodyPattern{{MatchesJsonSchema: schema}}
+			if got := matchBodyPatterns(patterns, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("matchBodyPatterns() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		body   string
+		want   bool
+	}{
+		{"integer accepts whole number", `{"type": "integer"}`, `3`, true},
+		{"integer rejects fraction", `{"type": "integer"}`, `3.5`, false},
+		{"type list", `{"type": ["string", "null"]}`, `null`, true},
+		{"enum", `{"enum": ["csv", "xlsx"]}`, `"pdf"`, false},
+		{"const object", `{"const": {"a": 1}}`, `{"a": 1}`, true},
+		{"additionalProperties false", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, false},
+		{"pattern", `{"type": "string", "pattern": "^[a-z]+$"}`, `"abc"`, true},
+		{"maxItems", `{"maxItems": 1}`, `[1, 2]`, false},
+		{"exclusiveMaximum", `{"exclusiveMaximum": 10}`, `10`, false},
+		{"anyOf", `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, `1`, true},
+		{"oneOf matching both", `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`, `1`, false},
+		{"not", `{"not": {"type": "null"}}`, `null`, false},
+		{"boolean false schema", `false`, `{}`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violation := validateJSONSchema(json.RawMessage(tt.schema), []byte(tt.body))
+			if got := violation == ""; got != tt.want {
+				t.Errorf("validateJSONSchema() = %q, want valid=%v", violation, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	for _, schema := range []string{
+		`{"type": "text"}`,
+		`{"$ref": "#/definitions/id"}`,
+		`{"items": [{"type": "string"}]}`,
+		`{"pattern": "("}`,
+		`{"minLength": -1}`,
+		`"object"`,
+		`{`,
+	} {
+		if _, err := compileSchema(json.RawMessage(schema)); err == nil {
+			t.Errorf("compileSchema(%s) succeeded, want error", schema)
+		}
+	}
+}
+
+func TestEvaluateMappingJsonSchemaDiff(t *testing.T) {
+	stub := types.Mapping{Request: types.Request{
+		Method: "POST",
+		URL:    "/api/search",
+		BodyPatterns: []types.BodyPattern{{MatchesJsonSchema: json.RawMessage(
+			`{"type": "object", "required": ["id"], "properties": {"id": {"type": "string"}}}`)}},
+	}}
+
+	result := evaluate(stub, "POST", "/api/search", nil, `{"id": 42}`)
+	if result.Matched {
+		t.Fatal("expected schema violation to prevent a match")
+	}
+	want := "Body does not match schema: $.id: expected type string, got integer"
+	if result.BodyDiff != want {
+		t.Errorf("BodyDiff = %q, want %q", result.BodyDiff, want)
+	}
+}
+
+func TestEqualToJSONStringHandling(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern types.BodyPattern
+		body    string
+		want    bool
+	}{
+		{
+			name:    "string field holding JSON is not re-parsed",
+			pattern: types.BodyPattern{EqualToJSON: json.RawMessage(`{"query": "{\"filter\": {\"id\": 1}}"}`)},
+			body:    `{"query": "{\"filter\": {\"id\": 1}}"}`,
+			want:    true,
+		},
+		{
+			name:    "string field does not match the decoded object",
+			pattern: types.BodyPattern{EqualToJSON: json.RawMessage(`{"query": "{\"filter\": {\"id\": 1}}"}`)},
+			body:    `{"query": {"filter": {"id": 1}}}`,
+			want:    false,
+		},
+		{
+			name:    "top-level string is encoded JSON, as recorded",
+			pattern: types.BodyPattern{EqualToJSON: json.RawMessage(`"{\"id\": 1}"`)},
+			body:    `{"id": 1}`,
+			want:    true,
+		},
+		{
+			name:    "literal top-level string matches a JSON string body",
+			pattern: types.BodyPattern{EqualToJSON: json.RawMessage(`"{\"id\": 1}"`), EqualToJSONLiteral: true},
+			body:    `"{\"id\": 1}"`,
+			want:    true,
+		},
+		{
+			name:    "literal top-level string does not match the decoded object",
+			pattern: types.BodyPattern{EqualToJSON: json.RawMessage(`"{\"id\": 1}"`), EqualToJSONLiteral: true},
+			body:    `{"id": 1}`,
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchBodyPattern(tt.pattern, []byte(tt.body), matchOptions{}); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLPathGlob(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		{"/api/*/objects/**", "/api/demo/objects/metrics/m1", true},
+		{"/api/*/objects/**", "/api/demo/objects/m1", true},
+		{"/api/*/objects/**", "/api/demo/objects", true},
+		{"/api/*/objects/**", "/api/demo/nested/objects/m1", false}, // * doesn't cross /
+		{"/api/*/objects/**", "/api/demo/objectsX/m1", false},
+		{"/api/*", "/api/demo", true},
+		{"/api/*", "/api/demo/more", false},
+		{"/files/*.json", "/files/report.json", true},
+		{"/files/*.json", "/files/sub/report.json", false},
+		{"/a/**/b", "/a/b", true},
+		{"/a/**/b", "/a/x/y/b", true},
+		{"/a/**/b", "/a/x/y/c", false},
+		{"/static/**.css", "/static/themes/dark.css", true},
+		{"/v1.0/*", "/v1x0/items", false}, // literal parts are not regex
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			stub := types.Mapping{Request: types.Request{Method: "GET", URLPathGlob: tt.glob}}
+			if got := evaluate(stub, "GET", tt.path+"?q=1", nil, "").URLMatch; got != tt.want {
+				t.Errorf("URLMatch = %v, want %v (regex %s)", got, tt.want, globRegex(tt.glob))
+			}
+		})
+	}
+}
+
+func TestMethodListsAndPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		request types.Request
+		method  string
+		want    bool
+	}{
+		{"single method", types.Request{Method: "GET"}, "GET", true},
+		{"single method mismatch", types.Request{Method: "GET"}, "HEAD", false},
+		{"ANY", types.Request{Method: "ANY"}, "PATCH", true},
+		{"list first", types.Request{Method: "GET,HEAD"}, "GET", true},
+		{"list second with spaces", types.Request{Method: "GET, HEAD"}, "HEAD", true},
+		{"list miss", types.Request{Method: "GET,HEAD"}, "POST", false},
+		{"pattern", types.Request{MethodPattern: "GET|OPTIONS"}, "OPTIONS", true},
+		{"pattern is anchored", types.Request{MethodPattern: "GET"}, "GETX", false},
+		{"pattern wins over method", types.Request{Method: "POST", MethodPattern: "P(UT|ATCH)"}, "PATCH", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.URL = "/api/resource"
+			result := evaluate(types.Mapping{Request: tt.request}, tt.method, "/api/resource", nil, "")
+			if result.Matched != tt.want {
+				t.Errorf("%s against %+v: Matched = %v, want %v", tt.method, tt.request, result.Matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRequestMethodList(t *testing.T) {
+	s := &types.Server{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET,HEAD", URL: "/cors"},
+		Response: types.Response{Status: 200},
+	}}}
+	for _, method := range []string{"GET", "HEAD"} {
+		var args fasthttp.Args
+		var h fasthttp.RequestHeader
+		if result := MatchRequest(s, method, "/cors", "/cors", &args, nil, &h); !result.Matched {
+			t.Errorf("%s /cors not matched by a GET,HEAD stub", method)
+		}
+	}
+}
+
+func TestJSONNumberEquality(t *testing.T) {
+	tests := []struct {
+		name      string
+		expected  string
+		body      string
+		tolerance float64
+		want      bool
+	}{
+		{name: "integer vs trailing zero", expected: `{"n": 1}`, body: `{"n": 1.0}`, want: true},
+		{name: "float-formatted integer", expected: `{"count": 5}`, body: `{"count": 5.0}`, want: true},
+		{name: "exponent vs plain", expected: `{"n": 1e3}`, body: `{"n": 1000}`, want: true},
+		{name: "float drift is exact by default", expected: `{"n": 0.3}`, body: `{"n": 0.30000000000000004}`, want: false},
+		{name: "float drift within tolerance", expected: `{"n": 0.3}`, body: `{"n": 0.30000000000000004}`, tolerance: 1e-9, want: true},
+		{name: "difference beyond tolerance", expected: `{"n": 0.3}`, body: `{"n": 0.31}`, tolerance: 1e-9, want: false},
+		{name: "strings stay strict", expected: `{"n": "1"}`, body: `{"n": "1.0"}`, tolerance: 1, want: false},
+		{name: "number vs string", expected: `{"n": 1}`, body: `{"n": "1"}`, tolerance: 1, want: false},
+		{name: "tolerance in unordered arrays", expected: `[0.3, 1]`, body: `[1, 0.30000000000000004]`, tolerance: 1e-9, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern := types.BodyPattern{EqualToJSON: json.RawMessage(tt.expected), IgnoreArrayOrder: boolPtr(true)}
+			if got := matchBodyPattern(pattern, []byte(tt.body), matchOptions{numberTolerance: tt.tolerance}); got != tt.want {
+				t.Errorf("match(%s, %s) = %v, want %v", tt.expected, tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRequestNumberTolerance(t *testing.T) {
+	s := &types.Server{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "POST", URL: "/sum", BodyPatterns: []types.BodyPattern{{EqualToJSON: json.RawMessage(`{"n": 0.3}`)}}},
+	}}}
+	body := []byte(`{"n": 0.30000000000000004}`)
+	var args fasthttp.Args
+	var h fasthttp.RequestHeader
+	if MatchRequest(s, "POST", "/sum", "/sum", &args, body, &h).Matched {
+		t.Error("float drift matched without a tolerance")
+	}
+	s.NumberTolerance = 1e-9
+	if !MatchRequest(s, "POST", "/sum", "/sum", &args, body, &h).Matched {
+		t.Error("float drift not matched within the server's tolerance")
+	}
+}
+
+func TestEvaluateMappingJsonBodyDiff(t *testing.T) {
+	stub := func(pattern types.BodyPattern) types.Mapping {
+		return types.Mapping{Request: types.Request{Method: "POST", URL: "/api/items", BodyPatterns: []types.BodyPattern{pattern}}}
+	}
+	tests := []struct {
+		name     string
+		pattern  types.BodyPattern
+		body     string
+		wantDiff string
+		wantPath string
+	}{
+		{
+			name:     "changed value",
+			pattern:  types.BodyPattern{EqualToJSON: json.RawMessage(`{"user": {"name": "alice", "age": 30}}`)},
+			body:     `{"user": {"name": "bob", "age": 30}}`,
+			wantDiff: `Body does not match: $.user.name: expected "alice", got "bob"`,
+			wantPath: "$.user.name",
+		},
+		{
+			name:     "missing key",
+			pattern:  types.BodyPattern{EqualToJSON: json.RawMessage(`{"id": 1, "tags": ["a"]}`)},
+			body:     `{"id": 1}`,
+			wantDiff: `Body does not match: $.tags: missing, expected ["a"]`,
+			wantPath: "$.tags",
+		},
+		{
+			name:     "unexpected key",
+			pattern:  types.BodyPattern{EqualToJSON: json.RawMessage(`{"id": 1}`)},
+			body:     `{"id": 1, "debug": true}`,
+			wantDiff: `Body does not match: $.debug: unexpected true`,
+			wantPath: "$.debug",
+		},
+		{
+			name:     "type mismatch in array",
+			pattern:  types.BodyPattern{EqualToJSON: json.RawMessage(`{"items": [{"qty": 1}]}`)},
+			body:     `{"items": [{"qty": "1"}]}`,
+			wantDiff: `Body does not match: $.items[0].qty: expected type number, got string`,
+			wantPath: "$.items[0].qty",
+		},
+		{
+			name:     "non-JSON body",
+			pattern:  types.BodyPattern{EqualToJSON: json.RawMessage(`{"id": 1}`)},
+			body:     `id=1`,
+			wantDiff: "Body does not match: body is not valid JSON",
+		},
+		{
+			name:     "byte diff for equalTo",
+			pattern:  types.BodyPattern{EqualTo: "hello world"},
+			body:     "hello there",
+			wantDiff: `Body does not match at byte 6: expected "world", got "there"`,
+		},
+		{
+			name:     "byte diff for trimmed equalTo",
+			pattern:  types.BodyPattern{EqualTo: "\nhello world\n", IgnoreSurroundingWhitespace: true},
+			body:     "  hello there\r\n",
+			wantDiff: `Body does not match at byte 6: expected "world", got "there"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(stub(tt.pattern), "POST", "/api/items", nil, tt.body)
+			if result.Matched {
+				t.Fatal("expected body mismatch")
+			}
+			if result.BodyDiff != tt.wantDiff {
+				t.Errorf("BodyDiff = %q, want %q", result.BodyDiff, tt.wantDiff)
+			}
+			if tt.wantPath == "" {
+				if len(result.BodyFieldDiffs) != 0 {
+					t.Errorf("BodyFieldDiffs = %+v, want none", result.BodyFieldDiffs)
+				}
+			} else if len(result.BodyFieldDiffs) != 1 || result.BodyFieldDiffs[0].Path != tt.wantPath {
+				t.Errorf("BodyFieldDiffs = %+v, want one at %s", result.BodyFieldDiffs, tt.wantPath)
+			}
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestMatchRequestPriority(t *testing.T) {
+	broad := types.Mapping{
+		Name:     "catch-all",
+		Priority: intPtr(5),
+		Request:  types.Request{Method: "ANY", URLPattern: "/api/.*"},
+	}
+	narrow := types.Mapping{
+		Name:     "workspaces",
+		Priority: intPtr(1),
+		Request:  types.Request{Method: "GET", URLPath: "/api/workspaces"},
+	}
+	specificLowPriority := types.Mapping{
+		Name:     "workspaces with query",
+		Priority: intPtr(9),
+		Request: types.Request{
+			Method:          "GET",
+			URLPath:         "/api/workspaces",
+			QueryParameters: map[string]types.QueryParamMatcher{"limit": {EqualTo: "10"}},
+		},
+	}
+	unprioritized := types.Mapping{
+		Name:    "workspaces default priority",
+		Request: types.Request{Method: "GET", URLPath: "/api/workspaces"},
+	}
+
+	tests := []struct {
+		name     string
+		mappings []types.Mapping
+		uri      string
+		want     string
+	}{
+		{
+			name:     "higher priority narrow stub wins over broad",
+			mappings: []types.Mapping{broad, narrow},
+			uri:      "/api/workspaces",
+			want:     "workspaces",
+		},
+		{
+			name:     "load order does not matter",
+			mappings: []types.Mapping{narrow, broad},
+			uri:      "/api/workspaces",
+			want:     "workspaces",
+		},
+		{
+			name:     "broad stub serves other paths",
+			mappings: []types.Mapping{broad, narrow},
+			uri:      "/api/users",
+			want:     "catch-all",
+		},
+		{
+			name:     "priority overrides specificity",
+			mappings: []types.Mapping{specificLowPriority, narrow},
+			uri:      "/api/workspaces?limit=10",
+			want:     "workspaces",
+		},
+		{
+			name:     "absent priority defaults to 5 and specificity breaks the tie",
+			mappings: []types.Mapping{unprioritized, specificLowPriority, broad},
+			uri:      "/api/workspaces?limit=10",
+			want:     "workspaces default priority",
+		},
+		{
+			name: "equal priority falls back to specificity",
+			mappings: []types.Mapping{broad, {
+				Name:     "workspaces with query at priority 5",
+				Priority: intPtr(5),
+				Request: types.Request{
+					Method:          "GET",
+					URLPath:         "/api/workspaces",
+					QueryParameters: map[string]types.QueryParamMatcher{"limit": {EqualTo: "10"}},
+				},
+			}},
+			uri:  "/api/workspaces?limit=10",
+			want: "workspaces with query at priority 5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &types.Server{Mappings: tt.mappings}
+			path := tt.uri
+			var args fasthttp.Args
+			if idx := strings.IndexByte(tt.uri, '?'); idx != -1 {
+				path = tt.uri[:idx]
+				args.Parse(tt.uri[idx+1:])
+			}
+			var h fasthttp.RequestHeader
+			result := MatchRequest(s, "GET", path, tt.uri, &args, nil, &h)
+			if !result.Matched {
+				t.Fatal("expected a match")
+			}
+			if result.Mapping.Name != tt.want {
+				t.Errorf("matched %q, want %q", result.Mapping.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRequestTieBreak(t *testing.T) {
+	stub := func(id, name string) types.Mapping {
+		return types.Mapping{ID: id, Name: name, Request: types.Request{Method: "GET", URLPath: "/api/items"}}
+	}
+	pattern := types.Mapping{ID: "a", Name: "a", Request: types.Request{Method: "GET", URLPattern: "/api/.*"}}
+
+	tests := []struct {
+		name     string
+		mappings []types.Mapping
+		wantID   string
+	}{
+		{"name decides", []types.Mapping{stub("1", "items b"), stub("2", "items a")}, "2"},
+		{"name decides in reverse load order", []types.Mapping{stub("2", "items a"), stub("1", "items b")}, "2"},
+		{"id decides between equal names", []types.Mapping{stub("b", "items"), stub("a", "items")}, "a"},
+		{"uuid stands in for a missing id", []types.Mapping{stub("b", ""), {UUID: "a", Request: types.Request{Method: "GET", URLPath: "/api/items"}}}, "a"},
+		{"literal path beats a pattern", []types.Mapping{pattern, stub("z", "z")}, "z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &types.Server{Mappings: tt.mappings}
+			var args fasthttp.Args
+			var h fasthttp.RequestHeader
+			result := MatchRequest(s, "GET", "/api/items", "/api/items", &args, nil, &h)
+			if !result.Matched {
+				t.Fatal("expected a match")
+			}
+			if got := mappingID(result.Mapping); got != tt.wantID {
+				t.Errorf("matched %q, want %q", got, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestTrailingSlashNormalization(t *testing.T) {
+	mappings := map[string]types.Mapping{
+		"urlPath":         {Request: types.Request{Method: "GET", URLPath: "/api/foo"}},
+		"urlPath slashed": {Request: types.Request{Method: "GET", URLPath: "/api/foo/"}},
+		"url":             {Request: types.Request{Method: "GET", URL: "/api/foo?page=1"}},
+		"urlPattern":      {Request: types.Request{Method: "GET", URLPattern: "^/api/foo$"}},
+	}
+	tests := []struct {
+		mapping    string
+		uri        string
+		normalized bool
+		want       bool
+	}{
+		{"urlPath", "/api/foo/", false, false},
+		{"urlPath", "/api/foo/", true, true},
+		{"urlPath", "/api/foo", true, true},
+		{"urlPath slashed", "/api/foo", true, true},
+		{"urlPath", "/api/foo//", true, false},
+		{"url", "/api/foo/?page=1", false, false},
+		{"url", "/api/foo/?page=1", true, true},
+		{"url", "/api/foo/?page=2", true, false},
+		{"urlPattern", "/api/foo/", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s normalized=%v", tt.mapping, tt.uri, tt.normalized), func(t *testing.T) {
+			m := mappings[tt.mapping]
+			path, rawQuery, _ := strings.Cut(tt.uri, "?")
+			var args fasthttp.Args
+			args.Parse(rawQuery)
+			var h fasthttp.RequestHeader
+			result := evaluateMapping(&m, matchOptions{normalizeTrailingSlash: tt.normalized}, "", "GET", path, tt.uri, queryValues(&args), nil, &h)
+			if result.URLMatch != tt.want {
+				t.Errorf("URLMatch = %v, want %v", result.URLMatch, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveQueryNames(t *testing.T) {
+	m := types.Mapping{Request: types.Request{
+		Method:          "GET",
+		URLPath:         "/items",
+		QueryParameters: map[string]types.QueryParamMatcher{"limit": {EqualTo: "10"}},
+	}}
+	tests := []struct {
+		uri       string
+		foldNames bool
+		want      bool
+	}{
+		{"/items?limit=10", false, true},
+		{"/items?Limit=10", false, false},
+		{"/items?Limit=10", true, true},
+		{"/items?LIMIT=10", true, true},
+		{"/items?Limit=20", true, false},
+		{"/items?Limit=10&limit=10", true, false}, // two values for one single-value matcher
+		{"/items?limits=10", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s foldNames=%v", tt.uri, tt.foldNames), func(t *testing.T) {
+			s := &types.Server{Mappings: []types.Mapping{m}, CaseInsensitiveQueryNames: tt.foldNames}
+			path, rawQuery, _ := strings.Cut(tt.uri, "?")
+			var args fasthttp.Args
+			args.Parse(rawQuery)
+			var h fasthttp.RequestHeader
+			if got := MatchRequest(s, "GET", path, tt.uri, &args, nil, &h).Matched; got != tt.want {
+				t.Errorf("Matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasExactlyOrder(t *testing.T) {
+	hasExactly := func(ordered bool, values ...string) types.Mapping {
+		matcher := types.QueryParamMatcher{OrderSensitive: ordered}
+		for _, v := range values {
+			matcher.HasExactly = append(matcher.HasExactly, types.EqualMatcher{EqualTo: v})
+		}
+		return types.Mapping{Request: types.Request{
+			Method:          "GET",
+			URLPath:         "/items",
+			QueryParameters: map[string]types.QueryParamMatcher{"sort": matcher},
+		}}
+	}
+	tests := []struct {
+		name    string
+		mapping types.Mapping
+		uri     string
+		want    bool
+	}{
+		{"unordered accepts declared order", hasExactly(false, "name", "date"), "/items?sort=name&sort=date", true},
+		{"unordered accepts any order", hasExactly(false, "name", "date"), "/items?sort=date&sort=name", true},
+		{"ordered accepts declared order", hasExactly(true, "name", "date"), "/items?sort=name&sort=date", true},
+		{"ordered rejects another order", hasExactly(true, "name", "date"), "/items?sort=date&sort=name", false},
+		{"ordered still requires every value", hasExactly(true, "name", "date"), "/items?sort=name", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(tt.mapping, "GET", tt.uri, nil, "")
+			if result.QueryMatch != tt.want {
+				t.Errorf("QueryMatch = %v, want %v (diffs %v)", result.QueryMatch, tt.want, result.QueryDiffs)
+			}
+		})
+	}
+
+	result := evaluate(hasExactly(true, "name", "date"), "GET", "/items?sort=date&sort=name", nil, "")
+	if want := "mismatch|sort|exactly in order [name date]|date,name"; len(result.QueryDiffs) != 1 || result.QueryDiffs[0] != want {
+		t.Errorf("QueryDiffs = %v, want [%s]", result.QueryDiffs, want)
+	}
+}
+
+func TestQueryParamIncludes(t *testing.T) {
+	includes := func(values ...string) types.Mapping {
+		var matcher types.QueryParamMatcher
+		for _, v := range values {
+			matcher.Includes = append(matcher.Includes, types.EqualMatcher{EqualTo: v})
+		}
+		return types.Mapping{Request: types.Request{
+			Method:          "GET",
+			URLPath:         "/items",
+			QueryParameters: map[string]types.QueryParamMatcher{"include": matcher},
+		}}
+	}
+	tests := []struct {
+		name    string
+		mapping types.Mapping
+		uri     string
+		want    bool
+	}{
+		{"only value", includes("labels"), "/items?include=labels", true},
+		{"among extra values", includes("labels"), "/items?include=facts&include=labels&include=metrics", true},
+		{"several values in any order", includes("labels", "facts"), "/items?include=facts&include=metrics&include=labels", true},
+		{"value missing", includes("labels"), "/items?include=facts&include=metrics", false},
+		{"parameter missing", includes("labels"), "/items", false},
+		{"duplicate needs two occurrences", includes("labels", "labels"), "/items?include=labels&include=facts", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(tt.mapping, "GET", tt.uri, nil, "")
+			if result.QueryMatch != tt.want {
+				t.Errorf("QueryMatch = %v, want %v (diffs %v)", result.QueryMatch, tt.want, result.QueryDiffs)
+			}
+		})
+	}
+
+	result := evaluate(includes("labels"), "GET", "/items?include=facts", nil, "")
+	if want := "mismatch|include|includes [labels]|facts"; len(result.QueryDiffs) != 1 || result.QueryDiffs[0] != want {
+		t.Errorf("QueryDiffs = %v, want [%s]", result.QueryDiffs, want)
+	}
+}
+
+func TestEvaluateMappingURLPathPattern(t *testing.T) {
+	m := types.Mapping{
+		Request: types.Request{
+			Method:         "GET",
+			URLPathPattern: `^/api/v1/workspaces/[^/]+/objects$`,
+		},
+	}
+	withQuery := m
+	withQuery.Request.QueryParameters = map[string]types.QueryParamMatcher{"size": {EqualTo: "100"}}
+
+	tests := []struct {
+		name    string
+		mapping types.Mapping
+		uri     string
+		wantURL bool
+		want    bool
+	}{
+		{name: "path matches", mapping: m, uri: "/api/v1/workspaces/demo/objects", wantURL: true, want: true},
+		{name: "query string ignored by path regex", mapping: m, uri: "/api/v1/workspaces/demo/objects?size=100&page=2", wantURL: true, want: true},
+		{name: "anchored pattern rejects longer path", mapping: m, uri: "/api/v1/workspaces/demo/objects/1", wantURL: false, want: false},
+		{name: "segment wildcard does not cross slashes", mapping: m, uri: "/api/v1/workspaces/a/b/objects", wantURL: false, want: false},
+		{name: "query params matched separately", mapping: withQuery, uri: "/api/v1/workspaces/demo/objects?size=100", wantURL: true, want: true},
+		{name: "query param mismatch", mapping: withQuery, uri: "/api/v1/workspaces/demo/objects?size=10", wantURL: true, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(tt.mapping, "GET", tt.uri, nil, "")
+			if result.URLMatch != tt.wantURL {
+				t.Errorf("URLMatch = %v, want %v", result.URLMatch, tt.wantURL)
+			}
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", result.Matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateMappingURLPatternIncludesQuery(t *testing.T) {
+	// Contrast with urlPathPattern: urlPattern sees the query string
+	m := types.Mapping{Request: types.Request{Method: "GET", URLPattern: `^/api/items$`}}
+	if evaluate(m, "GET", "/api/items?x=1", nil, "").URLMatch {
+		t.Error("urlPattern anchored at $ should not match a URI with a query string")
+	}
+}
+
+func TestEvaluateMappingURLPathTemplate(t *testing.T) {
+	m := types.Mapping{
+		Request: types.Request{
+			Method:          "GET",
+			URLPathTemplate: "/workspaces/{workspaceId}/objects/{objectId}",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		uri      string
+		want     bool
+		wantVars map[string]string
+	}{
+		{
+			name:     "extracts two variables",
+			uri:      "/workspaces/demo/objects/obj-42",
+			want:     true,
+			wantVars: map[string]string{"workspaceId": "demo", "objectId": "obj-42"},
+		},
+		{
+			name:     "query string is not part of the variable",
+			uri:      "/workspaces/demo/objects/obj-42?include=all",
+			want:     true,
+			wantVars: map[string]string{"workspaceId": "demo", "objectId": "obj-42"},
+		},
+		{
+			name:     "percent-encoding preserved",
+			uri:      "/workspaces/demo/objects/a%3Ab",
+			want:     true,
+			wantVars: map[string]string{"workspaceId": "demo", "objectId": "a%3Ab"},
+		},
+		{name: "variable does not span segments", uri: "/workspaces/demo/objects/a/b", want: false},
+		{name: "trailing slash on request does not match", uri: "/workspaces/demo/objects/obj-42/", want: false},
+		{name: "empty variable does not match", uri: "/workspaces//objects/obj-42", want: false},
+		{name: "literal segment must match", uri: "/workspaces/demo/things/obj-42", want: false},
+		{
+			name:     "trailing slash in template",
+			template: "/workspaces/{workspaceId}/",
+			uri:      "/workspaces/demo/",
+			want:     true,
+			wantVars: map[string]string{"workspaceId": "demo"},
+		},
+		{
+			name:     "variable inside a segment",
+			template: "/files/{name}.json",
+			uri:      "/files/report.json",
+			want:     true,
+			wantVars: map[string]string{"name": "report"},
+		},
+		{name: "template with slash in variable is rejected", template: "/files/{a/b}", uri: "/files/x/y", want: false},
+		{name: "unterminated variable is rejected", template: "/files/{name", uri: "/files/x", want: false},
+		{name: "duplicate variable is rejected", template: "/{id}/{id}", uri: "/a/b", want: false},
+		{name: "empty variable name is rejected", template: "/files/{}", uri: "/files/x", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := m
+			if tt.template != "" {
+				mapping.Request.URLPathTemplate = tt.template
+			}
+			result := evaluate(mapping, "GET", tt.uri, nil, "")
+			if result.Matched != tt.want {
+				t.Fatalf("Matched = %v, want %v", result.Matched, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+			if len(result.PathVariables) != len(tt.wantVars) {
+				t.Fatalf("PathVariables = %v, want %v", result.PathVariables, tt.wantVars)
+			}
+			for k, v := range tt.wantVars {
+				if result.PathVariables[k] != v {
+					t.Errorf("PathVariables[%q] = %q, want %q", k, result.PathVariables[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateMappingInvalidURLPattern(t *testing.T) {
+	for _, m := range []types.Mapping{
+		{Request: types.Request{Method: "GET", URLPattern: `/api/(items`}},
+		{Request: types.Request{Method: "GET", URLPathPattern: `/api/(items`}},
+	} {
+		Precompile(&m)
+		if result := evaluate(m, "GET", "/api/(items", nil, ""); result.URLMatch || result.Matched {
+			t.Errorf("invalid pattern %+v should never match", m.Request)
+		}
+	}
+}
+
+// regexHeavyServer returns a server with n urlPattern stubs, none of which match /api/miss.
+func regexHeavyServer(n int) *types.Server {
+	s := &types.Server{Scenarios: make(map[string]string)}
+	for i := 0; i < n; i++ {
+		s.Mappings = append(s.Mappings, types.Mapping{Request: types.Request{
+			Method:     "GET",
+			URLPattern: fmt.Sprintf(`^/api/v1/workspaces/[a-z0-9-]+/objects/type%d\?.*page=\d+$`, i),
+		}})
+	}
+	return s
+}
+
+func BenchmarkURLPatternMatching(b *testing.B) {
+	s := regexHeavyServer(200)
+	var args fasthttp.Args
+	var h fasthttp.RequestHeader
+	uri := "/api/v1/workspaces/demo/objects/type199?page=3"
+
+	// Baseline: what matching did before patterns were cached
+	b.Run("compile-per-request", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := range s.Mappings {
+				if re, err := regexp.Compile(s.Mappings[j].Request.URLPattern); err == nil {
+					re.MatchString(uri)
+				}
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		for i := range s.Mappings {
+			Precompile(&s.Mappings[i])
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			MatchRequest(s, "GET", "/api/v1/workspaces/demo/objects/type199", uri, &args, nil, &h)
+		}
+	})
+}
+
+func BenchmarkQueryParameterMatching(b *testing.B) {
+	var args fasthttp.Args
+	for i := 0; i < 20; i++ {
+		args.Add(fmt.Sprintf("param%d", i), fmt.Sprintf("value%d", i))
+	}
+	matchers := make(map[string]types.QueryParamMatcher)
+	for i := 0; i < 10; i++ {
+		matchers[fmt.Sprintf("param%d", i*2)] = types.QueryParamMatcher{EqualTo: fmt.Sprintf("value%d", i*2)}
+	}
+	s := &types.Server{
+		Scenarios: make(map[string]string),
+		Mappings: []types.Mapping{{Request: types.Request{
+			Method:          "GET",
+			URLPath:         "/api/search",
+			QueryParameters: matchers,
+		}}},
+	}
+	uri := "/api/search?" + args.String()
+	var h fasthttp.RequestHeader
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if !MatchRequest(s, "GET", "/api/search", uri, &args, nil, &h).Matched {
+			b.Fatal("expected a match")
+		}
+	}
+}
+
+func TestEvaluateMappingMultiValueHeader(t *testing.T) {
+	stub := func(matcher types.HeaderMatcher) types.Mapping {
+		return types.Mapping{Request: types.Request{
+			Method:  "GET",
+			URL:     "/tags",
+			Headers: map[string]types.HeaderMatcher{"X-Tag": matcher},
+		}}
+	}
+	tests := []struct {
+		name    string
+		matcher types.HeaderMatcher
+		want    bool
+	}{
+		{"equalTo second value", types.HeaderMatcher{EqualTo: "beta"}, true},
+		{"contains any value", types.HeaderMatcher{Contains: "alp"}, true},
+		{"matches any value", types.HeaderMatcher{Matches: `^b`}, true},
+		{"no value matches", types.HeaderMatcher{EqualTo: "gamma"}, false},
+		{"all values must match", types.HeaderMatcher{Matches: `^(alpha|beta)$`, MatchAllValues: true}, true},
+		{"one value fails all-values", types.HeaderMatcher{EqualTo: "beta", MatchAllValues: true}, false},
+		{"absent with values sent", types.HeaderMatcher{Absent: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := stub(tt.matcher)
+			var h fasthttp.RequestHeader
+			h.Add("X-Tag", "alpha")
+			h.Add("X-Tag", "beta")
+			result := evaluateMapping(&m, matchOptions{}, "", "GET", "/tags", "/tags", nil, nil, &h)
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v (diffs %v)", result.Matched, tt.want, result.HeaderDiffs)
+			}
+			if !tt.want && (len(result.HeaderDiffs) != 1 || !strings.Contains(result.HeaderDiffs[0], "alpha,beta")) {
+				t.Errorf("HeaderDiffs = %v, want both values reported", result.HeaderDiffs)
+			}
+		})
+	}
+}
+
+func TestCaseInsensitiveHeaderAndQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		request types.Request
+		want    bool
+	}{
+		{
+			name:    "header equalTo caseInsensitive",
+			request: types.Request{Headers: map[string]types.HeaderMatcher{"Content-Type": {EqualTo: "application/json", CaseInsensitive: true}}},
+			want:    true,
+		},
+		{
+			name:    "header equalTo case-sensitive by default",
+			request: types.Request{Headers: map[string]types.HeaderMatcher{"Content-Type": {EqualTo: "application/json"}}},
+			want:    false,
+		},
+		{
+			name:    "header contains caseInsensitive",
+			request: types.Request{Headers: map[string]types.HeaderMatcher{"Content-Type": {Contains: "json", CaseInsensitive: true}}},
+			want:    true,
+		},
+		{
+			name:    "regex unaffected by caseInsensitive",
+			request: types.Request{Headers: map[string]types.HeaderMatcher{"Content-Type": {Matches: "^application/json$", CaseInsensitive: true}}},
+			want:    false,
+		},
+		{
+			name:    "query equalTo caseInsensitive",
+			request: types.Request{QueryParameters: map[string]types.QueryParamMatcher{"format": {EqualTo: "csv", CaseInsensitive: true}}},
+			want:    true,
+		},
+		{
+			name:    "query hasExactly caseInsensitive",
+			request: types.Request{QueryParameters: map[string]types.QueryParamMatcher{"format": {HasExactly: []types.EqualMatcher{{EqualTo: "csv"}}, CaseInsensitive: true}}},
+			want:    true,
+		},
+		{
+			name:    "query equalTo case-sensitive by default",
+			request: types.Request{QueryParameters: map[string]types.QueryParamMatcher{"format": {EqualTo: "csv"}}},
+			want:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.request.Method = "GET"
+			tt.request.URLPath = "/export"
+			m := types.Mapping{Request: tt.request}
+			result := evaluate(m, "GET", "/export?format=CSV", map[string]string{"Content-Type": "APPLICATION/JSON"}, "")
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", result.Matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryParamMatchesContainsAbsent(t *testing.T) {
+	stub := types.Mapping{Request: types.Request{
+		Method:  "GET",
+		URLPath: "/items",
+		QueryParameters: map[string]types.QueryParamMatcher{
+			"offset": {Matches: `^\d+$`},
+			"sort":   {Contains: "name"},
+			"debug":  {Absent: true},
+		},
+	}}
+	tests := []struct {
+		uri       string
+		want      bool
+		wantDiffs int
+	}{
+		{"/items?offset=20&sort=name,asc", true, 0},
+		{"/items?offset=20&offset=x&sort=date&sort=name", true, 0},
+		{"/items?offset=abc&sort=name", false, 1},
+		{"/items?sort=name", false, 1},
+		{"/items?offset=20&sort=date", false, 1},
+		{"/items?offset=20&sort=name&debug=true", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			result := evaluate(stub, "GET", tt.uri, nil, "")
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v (diffs %v)", result.Matched, tt.want, result.QueryDiffs)
+			}
+			if len(result.QueryDiffs) != tt.wantDiffs {
+				t.Errorf("QueryDiffs = %v, want %d entries", result.QueryDiffs, tt.wantDiffs)
+			}
+		})
+	}
+}
+
+func TestEvaluateMappingCookies(t *testing.T) {
+	stub := types.Mapping{Request: types.Request{
+		Method: "GET",
+		URL:    "/profile",
+		Cookies: map[string]types.HeaderMatcher{
+			"JSESSIONID": {Matches: `^[A-F0-9]{32}$`},
+			"tracking":   {Absent: true},
+		},
+	}}
+	tests := []struct {
+		name   string
+		cookie string
+		want   bool
+	}{
+		{"session matches", "theme=dark; JSESSIONID=0123456789ABCDEF0123456789ABCDEF", true},
+		{"session malformed", "JSESSIONID=not-a-session", false},
+		{"session missing", "theme=dark", false},
+		{"absent cookie sent", "JSESSIONID=0123456789ABCDEF0123456789ABCDEF; tracking=1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(stub, "GET", "/profile", map[string]string{"Cookie": tt.cookie}, "")
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v (diffs %v)", result.Matched, tt.want, result.HeaderDiffs)
+			}
+			if !tt.want && (len(result.HeaderDiffs) != 1 || !strings.Contains(result.HeaderDiffs[0], "Cookie ")) {
+				t.Errorf("HeaderDiffs = %v, want one cookie diff", result.HeaderDiffs)
+			}
+		})
+	}
+
+	contains := types.Mapping{Request: types.Request{
+		Method:  "GET",
+		URL:     "/profile",
+		Cookies: map[string]types.HeaderMatcher{"locale": {EqualTo: "en-US"}, "prefs": {Contains: "compact"}},
+	}}
+	if !evaluate(contains, "GET", "/profile", map[string]string{"Cookie": "locale=en-US; prefs=layout:compact"}, "").Matched {
+		t.Error("equalTo and contains cookie matchers should match")
+	}
+}
+
+func TestEvaluateMappingBasicAuth(t *testing.T) {
+	stub := types.Mapping{Request: types.Request{
+		Method:    "GET",
+		URL:       "/secure",
+		BasicAuth: &types.BasicAuthCredentials{Username: "admin", Password: "s3cret"},
+	}}
+	basic := func(creds string) string { return "Basic " + base64.StdEncoding.EncodeToString([]byte(creds)) }
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          bool
+		wantDiff      string
+	}{
+		{"correct credentials", basic("admin:s3cret"), true, ""},
+		{"wrong password", basic("admin:nope"), false, "mismatch|Authorization|basicAuth admin|basicAuth admin"},
+		{"wrong user", basic("guest:s3cret"), false, "mismatch|Authorization|basicAuth admin|basicAuth guest"},
+		{"missing header", "", false, "not_present|Authorization|basicAuth admin"},
+		{"bearer token", "Bearer abc", false, "mismatch|Authorization|basicAuth admin|Bearer scheme"},
+		{"not base64", "Basic !!!", false, "mismatch|Authorization|basicAuth admin|malformed credentials"},
+		{"no colon", basic("admin"), false, "mismatch|Authorization|basicAuth admin|malformed credentials"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.authorization != "" {
+				headers["Authorization"] = tt.authorization
+			}
+			result := evaluate(stub, "GET", "/secure", headers, "")
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", result.Matched, tt.want)
+			}
+			if tt.wantDiff != "" && (len(result.HeaderDiffs) != 1 || result.HeaderDiffs[0] != tt.wantDiff) {
+				t.Errorf("HeaderDiffs = %v, want [%s]", result.HeaderDiffs, tt.wantDiff)
+			}
+			for _, d := range result.HeaderDiffs {
+				if strings.Contains(d, "s3cret") || strings.Contains(d, "nope") {
+					t.Errorf("diff leaks a password: %s", d)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateMappingSchemeAndHost(t *testing.T) {
+	stub := types.Mapping{Request: types.Request{
+		Method: "GET",
+		URL:    "/status",
+		Scheme: "https",
+		Host:   "api.example.com",
+	}}
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		want     bool
+		wantDiff string
+	}{
+		{"forwarded https", map[string]string{"Host": "api.example.com", "X-Forwarded-Proto": "https"}, true, ""},
+		{"host port ignored", map[string]string{"Host": "API.example.com:8443", "X-Forwarded-Proto": "HTTPS"}, true, ""},
+		{"first forwarded proto", map[string]string{"Host": "api.example.com", "X-Forwarded-Proto": "https, http"}, true, ""},
+		{"plain http", map[string]string{"Host": "api.example.com"}, false, "mismatch|Scheme|https|http"},
+		{"other host", map[string]string{"Host": "admin.example.com", "X-Forwarded-Proto": "https"}, false, "mismatch|Host|api.example.com|admin.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(stub, "GET", "/status", tt.headers, "")
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v (diffs %v)", result.Matched, tt.want, result.HeaderDiffs)
+			}
+			if tt.wantDiff != "" && (len(result.HeaderDiffs) != 1 || result.HeaderDiffs[0] != tt.wantDiff) {
+				t.Errorf("HeaderDiffs = %v, want [%s]", result.HeaderDiffs, tt.wantDiff)
+			}
+		})
+	}
+
+	withPort := types.Mapping{Request: types.Request{Method: "GET", URL: "/status", Host: "localhost:8081"}}
+	if evaluate(withPort, "GET", "/status", map[string]string{"Host": "localhost:8080"}, "").Matched {
+		t.Error("a host matcher with a port should compare the port")
+	}
+	if !evaluate(withPort, "GET", "/status", map[string]string{"Host": "localhost:8081"}, "").Matched {
+		t.Error("host with the expected port should match")
+	}
+}
+
+func TestEvaluateMappingFormParameters(t *testing.T) {
+	stub := types.Mapping{Request: types.Request{
+		Method: "POST",
+		URL:    "/oauth/token",
+		FormParameters: map[string]types.QueryParamMatcher{
+			"grant_type": {EqualTo: "client_credentials"},
+			"password":   {Absent: true},
+		},
+	}}
+	form := "application/x-www-form-urlencoded"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        bool
+	}{
+		{"client credentials", form, "grant_type=client_credentials&client_id=app", true},
+		{"charset parameter", form + "; charset=UTF-8", "client_id=app&grant_type=client_credentials", true},
+		{"percent-encoded", form, "grant_type=client%5Fcredentials", true},
+		{"other grant type", form, "grant_type=refresh_token", false},
+		{"forbidden field", form, "grant_type=client_credentials&password=x", false},
+		{"missing field", form, "client_id=app", false},
+		{"json body", "application/json", `{"grant_type":"client_credentials"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := evaluate(stub, "POST", "/oauth/token", map[string]string{"Content-Type": tt.contentType}, tt.body)
+			if result.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v (body diff %q)", result.Matched, tt.want, result.BodyDiff)
+			}
+			if !tt.want && !strings.HasPrefix(result.BodyDiff, "Form parameters do not match") {
+				t.Errorf("BodyDiff = %q, want form parameter diff", result.BodyDiff)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping types.Mapping
+		want    []string
+	}{
+		{
+			name: "valid mapping",
+			mapping: types.Mapping{
+				Request: types.Request{
+					Method:          "POST",
+					URLPathTemplate: "/items/{id}",
+					QueryParameters: map[string]types.QueryParamMatcher{"q": {Matches: "^a+$"}},
+					BodyPatterns:    []types.BodyPattern{{EqualToJSON: json.RawMessage(`"{\"a\": 1}"`)}, {MatchesJsonPath: "$.a"}},
+				},
+				Response: types.Response{Status: 200, JsonBody: map[string]any{"ok": true}},
+			},
+		},
+		{
+			name: "conflicting matchers",
+			mapping: types.Mapping{
+				Request: types.Request{
+					Method:          "GET",
+					URL:             "/a",
+					URLPathGlob:     "/a/*",
+					QueryParameters: map[string]types.QueryParamMatcher{"q": {EqualTo: "1", Contains: "1"}},
+				},
+				Response: types.Response{Status: 200, Body: "x", Base64Body: "eA=="},
+			},
+			want: []string{
+				"request: only one of url, urlPath, urlPattern, urlPathPattern, urlPathTemplate and urlPathGlob may be set",
+				"queryParameters.q: only one of equalTo, hasExactly, includes, matches and contains may be set",
+				"response: only one of body, jsonBody, base64Body and bodyFileName may be set",
+			},
+		},
+		{
+			name: "invalid response weights",
+			mapping: types.Mapping{
+				Request: types.Request{Method: "GET", URL: "/a"},
+				Response: types.Response{WeightedResponses: []types.Response{
+					{Status: 200, Weight: floatPtr(-1)},
+					{Status: 500, Weight: floatPtr(0)},
+				}},
+			},
+			want: []string{
+				"response.weightedResponses[0].weight: must not be negative",
+				"response.weightedResponses: at least one weight must be positive",
+			},
+		},
+		{
+			name: "nested body patterns that don't compile",
+			mapping: types.Mapping{Request: types.Request{
+				Method: "POST",
+				BodyPatterns: []types.BodyPattern{{Or: []types.BodyPattern{
+					{EqualToXML: "<a>"},
+					{MatchesJsonPath: "a.b"},
+					{BinaryEqualTo: "AQI"},
+					{JsonPathMatchers: []types.JsonPathMatcher{{Path: "$.a", Matches: "(b"}}},
+				}}},
+			}},
+			want: []string{
+				"bodyPatterns[0].or[0].equalToXml: not valid XML: XML syntax error on line 1: unexpected EOF",
+				`bodyPatterns[0].or[1].matchesJsonPath: JSONPath must start with $: "a.b"`,
+				"bodyPatterns[0].or[2].binaryEqualTo: not valid base64: illegal base64 data at input byte 0",
+				"bodyPatterns[0].or[3].jsonPathMatchers[0].matches: invalid regex \"(b\": error parsing regexp: missing closing ): `(b`",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Validate(&tt.mapping); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindShadowed(t *testing.T) {
+	get := func(name, url string) types.Mapping {
+		return types.Mapping{Name: name, Request: types.Request{Method: "GET", URL: url}}
+	}
+	prioritized := get("prioritized", "/a")
+	one := 1
+	prioritized.Priority = &one
+	scenario := get("scenario", "/a")
+	scenario.ScenarioName = "flow"
+	scenario.RequiredScenarioState = "Started"
+	byPath := get("by path", "")
+	byPath.Request.URLPath = "/a"
+	pattern := types.Mapping{Name: "pattern", Request: types.Request{Method: "GET", URLPattern: "/a"}}
+	withQuery := get("with query", "/a")
+	withQuery.Request.QueryParameters = map[string]types.QueryParamMatcher{"q": {EqualTo: "1"}}
+
+	mappings := []types.Mapping{
+		get("c", "/a"), prioritized, scenario, byPath, pattern, pattern, withQuery,
+		get("b", "/a"), get("other", "/b"), get("a", "/a"),
+	}
+	want := []Shadow{{Winner: 9, Shadowed: 0}, {Winner: 9, Shadowed: 7}}
+	if got := FindShadowed(mappings); !reflect.DeepEqual(got, want) {
+		t.Errorf("FindShadowed = %+v, want %+v", got, want)
+	}
+}
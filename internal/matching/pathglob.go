@@ -0,0 +1,44 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"regexp"
+	"strings"
+)
+
+// globRegex translates a urlPathGlob into an anchored regex. * matches within a
+// single path segment, so it never crosses a /, while ** matches across any number
+// of segments. A whole /** segment may also match no segments at all, so
+// /files/** covers /files and /a/**/b covers /a/b.
+func globRegex(glob string) string {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	literal := 0 // start of the pending literal run
+	for i := 0; i < len(glob); {
+		if glob[i] != '*' {
+			i++
+			continue
+		}
+		start := i
+		wholeSegment := strings.HasPrefix(glob[i:], "**") && i > 0 && glob[i-1] == '/' &&
+			(i+2 == len(glob) || glob[i+2] == '/')
+		if wholeSegment {
+			// Absorb the leading slash into the optional group
+			pattern.WriteString(regexp.QuoteMeta(glob[literal : start-1]))
+			pattern.WriteString("(?:/.*)?")
+			i += 2
+		} else if strings.HasPrefix(glob[i:], "**") {
+			pattern.WriteString(regexp.QuoteMeta(glob[literal:start]))
+			pattern.WriteString(".*")
+			i += 2
+		} else {
+			pattern.WriteString(regexp.QuoteMeta(glob[literal:start]))
+			pattern.WriteString("[^/]*")
+			i++
+		}
+		literal = i
+	}
+	pattern.WriteString(regexp.QuoteMeta(glob[literal:]))
+	pattern.WriteString("$")
+	return pattern.String()
+}
@@ -0,0 +1,101 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// pathTemplate is a compiled urlPathTemplate such as /workspaces/{workspaceId}/objects.
+type pathTemplate struct {
+	re    *regexp.Regexp
+	names []string
+}
+
+// pathTemplateCache holds compiled templates keyed by template string. Invalid
+// templates are stored as nil so the error is only logged once.
+var pathTemplateCache sync.Map
+
+// compilePathTemplate turns a path template into an anchored regex where every
+// {name} placeholder matches exactly one non-empty path segment.
+func compilePathTemplate(template string) (*pathTemplate, error) {
+	var pattern strings.Builder
+	var names []string
+	seen := make(map[string]bool)
+
+	pattern.WriteString("^")
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open == -1 {
+			if strings.IndexByte(rest, '}') != -1 {
+				return nil, fmt.Errorf("unbalanced } in path template %q", template)
+			}
+			pattern.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		if strings.IndexByte(rest[:open], '}') != -1 {
+			return nil, fmt.Errorf("unbalanced } in path template %q", template)
+		}
+		pattern.WriteString(regexp.QuoteMeta(rest[:open]))
+
+		end := strings.IndexByte(rest[open:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated { in path template %q", template)
+		}
+		name := rest[open+1 : open+end]
+		if name == "" || strings.ContainsAny(name, "/{") {
+			return nil, fmt.Errorf("invalid variable {%s} in path template %q: variables must name a single path segment", name, template)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate variable {%s} in path template %q", name, template)
+		}
+		seen[name] = true
+		names = append(names, name)
+		pattern.WriteString("([^/]+)")
+		rest = rest[open+end+1:]
+	}
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+	return &pathTemplate{re: re, names: names}, nil
+}
+
+// compilePathTemplateCached compiles a template once and reuses it on subsequent requests.
+// Returns nil for invalid templates, which callers must treat as a non-match.
+func compilePathTemplateCached(template string) *pathTemplate {
+	if cached, ok := pathTemplateCache.Load(template); ok {
+		return cached.(*pathTemplate)
+	}
+	pt, err := compilePathTemplate(template)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		pt = nil
+	}
+	actual, _ := pathTemplateCache.LoadOrStore(template, pt)
+	return actual.(*pathTemplate)
+}
+
+// matchPathTemplate matches a request path against a template, returning the
+// extracted variables. Variable values are kept percent-encoded, like the path itself.
+func matchPathTemplate(template, path string) (map[string]string, bool) {
+	pt := compilePathTemplateCached(template)
+	if pt == nil {
+		return nil, false
+	}
+	m := pt.re.FindStringSubmatch(path)
+	if m == nil {
+		return nil, false
+	}
+	vars := make(map[string]string, len(pt.names))
+	for i, name := range pt.names {
+		vars[name] = m[i+1]
+	}
+	return vars, true
+}
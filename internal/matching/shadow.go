@@ -0,0 +1,91 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/types"
+)
+
+// DeduplicationKey builds a key from a mapping's request fields for deduplication.
+// Uses method + url/urlPath + sorted query params + header matchers + body patterns.
+func DeduplicationKey(m types.Mapping) string {
+	path := m.Request.URL
+	if path == "" {
+		path = m.Request.URLPath
+	}
+
+	key := m.Request.Method + " " + path
+
+	// Append query parameters (deterministic order)
+	if len(m.Request.QueryParameters) > 0 {
+		qpJSON, _ := json.Marshal(m.Request.QueryParameters)
+		key += " " + string(qpJSON)
+	}
+
+	// Append captured header matchers
+	if len(m.Request.Headers) > 0 {
+		hJSON, _ := json.Marshal(m.Request.Headers)
+		key += " " + string(hJSON)
+	}
+
+	// Append body patterns
+	if len(m.Request.BodyPatterns) > 0 {
+		bpJSON, _ := json.Marshal(m.Request.BodyPatterns)
+		key += " " + string(bpJSON)
+	}
+
+	return key
+}
+
+// Shadow is a pair of mappings that match exactly the same requests. Shadowed never
+// serves a response because Winner is always chosen instead.
+type Shadow struct {
+	Winner   int // index into the mappings passed to FindShadowed
+	Shadowed int
+}
+
+// FindShadowed finds mappings with a url or urlPath whose request matchers, priority
+// and scenario state are identical to another mapping's, so only one of them can ever
+// be served. Mappings using URL patterns, globs or templates aren't compared.
+func FindShadowed(mappings []types.Mapping) []Shadow {
+	winners := make(map[string]int) // shadow key -> index of the mapping that wins
+	var shadows []Shadow
+	for i := range mappings {
+		m := &mappings[i]
+		if m.Request.URL == "" && m.Request.URLPath == "" {
+			continue
+		}
+		key := shadowKey(m)
+		w, seen := winners[key]
+		if !seen {
+			winners[key] = i
+			continue
+		}
+		if tieBreaksBefore(m, &mappings[w]) {
+			winners[key] = i
+			w, i = i, w
+		}
+		shadows = append(shadows, Shadow{Winner: w, Shadowed: i})
+	}
+	// A later mapping can take over as winner; report every loser against the final one
+	for n := range shadows {
+		shadows[n].Winner = winners[shadowKey(&mappings[shadows[n].Shadowed])]
+	}
+	return shadows
+}
+
+// shadowKey extends DeduplicationKey with everything else that decides whether a
+// mapping is chosen, so equal keys mean the mappings compete for the same requests.
+func shadowKey(m *types.Mapping) string {
+	rest, _ := json.Marshal(struct {
+		URLKind        bool // url and urlPath with the same value match different requests
+		MethodPattern  string
+		FormParameters map[string]types.QueryParamMatcher
+		Cookies        map[string]types.HeaderMatcher
+		BasicAuth      *types.BasicAuthCredentials
+		Scheme         string
+		Host           string
+	}{m.Request.URL != "", m.Request.MethodPattern, m.Request.FormParameters, m.Request.Cookies, m.Request.BasicAuth, m.Request.Scheme, m.Request.Host})
+	return fmt.Sprintf("%s %s %d %q %q", DeduplicationKey(*m), rest, effectivePriority(m), m.ScenarioName, m.RequiredScenarioState)
+}
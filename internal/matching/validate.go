@@ -0,0 +1,183 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/types"
+	"regexp"
+	"sort"
+)
+
+// Validate checks a mapping for problems that would otherwise only surface as requests
+// that never match: regexes, path templates, JSONPath expressions, JSON schemas and
+// equalToJson/equalToXml documents that don't compile, and matchers that contradict
+// each other. It returns one message per problem, or nil for a valid mapping.
+func Validate(m *types.Mapping) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	checkRegex := func(field, pattern string) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			report("%s: invalid regex %q: %v", field, pattern, err)
+		}
+	}
+
+	req := &m.Request
+	urlMatchers := 0
+	for _, url := range []string{req.URL, req.URLPath, req.URLPattern, req.URLPathPattern, req.URLPathTemplate, req.URLPathGlob} {
+		if url != "" {
+			urlMatchers++
+		}
+	}
+	if urlMatchers > 1 {
+		report("request: only one of url, urlPath, urlPattern, urlPathPattern, urlPathTemplate and urlPathGlob may be set")
+	}
+	if req.URLPattern != "" {
+		checkRegex("urlPattern", req.URLPattern)
+	}
+	if req.URLPathPattern != "" {
+		checkRegex("urlPathPattern", req.URLPathPattern)
+	}
+	if req.URLPathTemplate != "" {
+		if _, err := compilePathTemplate(req.URLPathTemplate); err != nil {
+			report("urlPathTemplate: %v", err)
+		}
+	}
+	if req.MethodPattern != "" {
+		checkRegex("methodPattern", req.MethodPattern)
+	}
+
+	for _, params := range []struct {
+		field    string
+		matchers map[string]types.QueryParamMatcher
+	}{{"queryParameters", req.QueryParameters}, {"formParameters", req.FormParameters}} {
+		for _, name := range sortedMatcherNames(params.matchers) {
+			q := params.matchers[name]
+			field := params.field + "." + name
+			if q.Matches != "" {
+				checkRegex(field+".matches", q.Matches)
+			}
+			set := 0
+			for _, used := range []bool{q.EqualTo != "", len(q.HasExactly) > 0, len(q.Includes) > 0, q.Matches != "", q.Contains != ""} {
+				if used {
+					set++
+				}
+			}
+			if set > 1 {
+				report("%s: only one of equalTo, hasExactly, includes, matches and contains may be set", field)
+			}
+		}
+	}
+	for _, headers := range []struct {
+		field    string
+		matchers map[string]types.HeaderMatcher
+	}{{"headers", req.Headers}, {"cookies", req.Cookies}} {
+		for _, name := range sortedMatcherNames(headers.matchers) {
+			h := headers.matchers[name]
+			field := headers.field + "." + name
+			if h.Matches != "" {
+				checkRegex(field+".matches", h.Matches)
+			}
+			if h.DoesNotMatch != "" {
+				checkRegex(field+".doesNotMatch", h.DoesNotMatch)
+			}
+		}
+	}
+	for i, bp := range req.BodyPatterns {
+		validateBodyPattern(fmt.Sprintf("bodyPatterns[%d]", i), bp, report)
+	}
+
+	resp := &m.Response
+	bodies := 0
+	for _, used := range []bool{resp.Body != "", resp.JsonBody != nil, resp.Base64Body != "", resp.BodyFileName != ""} {
+		if used {
+			bodies++
+		}
+	}
+	if bodies > 1 {
+		report("response: only one of body, jsonBody, base64Body and bodyFileName may be set")
+	}
+	if len(resp.WeightedResponses) > 0 {
+		total := 0.0
+		for i, w := range resp.WeightedResponses {
+			switch {
+			case w.Weight == nil:
+				total++
+			case *w.Weight < 0:
+				report("response.weightedResponses[%d].weight: must not be negative", i)
+			default:
+				total += *w.Weight
+			}
+		}
+		if total == 0 {
+			report("response.weightedResponses: at least one weight must be positive")
+		}
+	}
+	return problems
+}
+
+// validateBodyPattern checks a body pattern and the patterns nested in its and/or.
+func validateBodyPattern(field string, bp types.BodyPattern, report func(string, ...any)) {
+	if bp.EqualToJSON != nil {
+		var doc any
+		if err := json.Unmarshal(equalToJSONDocument(bp), &doc); err != nil {
+			report("%s.equalToJson: not valid JSON: %v", field, err)
+		}
+	}
+	if bp.MatchesJsonPath != "" {
+		if _, err := parseJSONPath(bp.MatchesJsonPath); err != nil {
+			report("%s.matchesJsonPath: %v", field, err)
+		}
+	}
+	if bp.MatchesJsonSchema != nil {
+		if _, err := compileSchema(bp.MatchesJsonSchema); err != nil {
+			report("%s.matchesJsonSchema: %v", field, err)
+		}
+	}
+	if bp.EqualToXML != "" {
+		if _, err := parseXML([]byte(bp.EqualToXML)); err != nil {
+			report("%s.equalToXml: not valid XML: %v", field, err)
+		}
+	}
+	if bp.BinaryEqualTo != "" {
+		if _, err := base64.StdEncoding.DecodeString(bp.BinaryEqualTo); err != nil {
+			report("%s.binaryEqualTo: not valid base64: %v", field, err)
+		}
+	}
+	for i, matcher := range bp.JsonPathMatchers {
+		if _, err := parseJSONPath(matcher.Path); err != nil {
+			report("%s.jsonPathMatchers[%d].path: %v", field, i, err)
+		}
+		if matcher.Matches != "" {
+			if _, err := regexp.Compile(matcher.Matches); err != nil {
+				report("%s.jsonPathMatchers[%d].matches: invalid regex %q: %v", field, i, matcher.Matches, err)
+			}
+		}
+	}
+	for _, re := range []struct{ name, pattern string }{{"matches", bp.Matches}, {"doesNotMatch", bp.DoesNotMatch}} {
+		if re.pattern == "" {
+			continue
+		}
+		if _, err := regexp.Compile(re.pattern); err != nil {
+			report("%s.%s: invalid regex %q: %v", field, re.name, re.pattern, err)
+		}
+	}
+	for i, nested := range bp.And {
+		validateBodyPattern(fmt.Sprintf("%s.and[%d]", field, i), nested, report)
+	}
+	for i, nested := range bp.Or {
+		validateBodyPattern(fmt.Sprintf("%s.or[%d]", field, i), nested, report)
+	}
+}
+
+func sortedMatcherNames[M any](matchers map[string]M) []string {
+	names := make([]string, 0, len(matchers))
+	for name := range matchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,103 @@
+// (C) 2025 GoodData Corporation
+package matching
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// xmlNode is a simplified XML tree used for structural comparison.
+// Text nodes have an empty name and carry their content in text.
+type xmlNode struct {
+	name     xml.Name
+	attrs    map[xml.Name]string
+	text     string
+	children []*xmlNode
+}
+
+// parseXML builds an xmlNode tree from a document. Whitespace-only character data
+// is dropped, and comments, processing instructions and directives are ignored.
+func parseXML(data []byte) (*xmlNode, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var root *xmlNode
+	var stack []*xmlNode
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: t.Name, attrs: make(map[xml.Name]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				node.attrs[a.Name] = a.Value
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root != nil {
+				return nil, errors.New("multiple root elements")
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 || strings.TrimSpace(string(t)) == "" {
+				continue
+			}
+			parent := stack[len(stack)-1]
+			parent.children = append(parent.children, &xmlNode{text: string(t)})
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("no root element")
+	}
+	return root, nil
+}
+
+// xmlNodesEqual compares two trees: names, attribute sets (order-insensitive),
+// text content and child order must all agree.
+func xmlNodesEqual(a, b *xmlNode) bool {
+	if a.name != b.name || a.text != b.text {
+		return false
+	}
+	if len(a.attrs) != len(b.attrs) || len(a.children) != len(b.children) {
+		return false
+	}
+	for k, v := range a.attrs {
+		if bv, ok := b.attrs[k]; !ok || bv != v {
+			return false
+		}
+	}
+	for i := range a.children {
+		if !xmlNodesEqual(a.children[i], b.children[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// xmlEqual parses both documents and compares them structurally.
+// Malformed XML on either side is a non-match.
+func xmlEqual(expected string, actual []byte) bool {
+	expectedRoot, err := parseXML([]byte(expected))
+	if err != nil {
+		return false
+	}
+	actualRoot, err := parseXML(actual)
+	if err != nil {
+		return false
+	}
+	return xmlNodesEqual(expectedRoot, actualRoot)
+}
@@ -4,13 +4,119 @@ package proxy
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"strings"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+// MaxRedirects caps Config.FollowRedirects so a redirect loop can't stall a request.
+const MaxRedirects = 20
+
+// Config tunes how requests are forwarded upstream. The zero value waits
+// indefinitely and never retries.
+type Config struct {
+	// Timeout bounds each upstream attempt; 0 disables it.
+	Timeout time.Duration
+	// Retries is how many extra attempts an idempotent request gets after a failure.
+	Retries int
+	// InsecureSkipVerify accepts any upstream certificate, e.g. self-signed staging hosts.
+	InsecureSkipVerify bool
+	// RootCAs verifies upstream certificates instead of the system pool when set.
+	RootCAs *x509.CertPool
+	// PreserveHost forwards the client's Host header instead of the upstream's host.
+	PreserveHost bool
+	// HostOverride, when set, is sent as the Host header and wins over PreserveHost.
+	HostOverride string
+	// FollowRedirects is how many redirect hops to follow before returning the
+	// response; 0 returns redirects as-is.
+	FollowRedirects int
+}
+
+// Client forwards requests upstream. It is created once at startup and shared,
+// so connections are pooled across requests.
+type Client struct {
+	client *fasthttp.Client
+	config Config
+}
+
+// NewClient returns a Client tuned by config.
+func NewClient(config Config) *Client {
+	// fasthttp retries idempotent requests on its own; leave that to Config.Retries
+	client := &fasthttp.Client{MaxIdemponentCallAttempts: 1}
+	if config.InsecureSkipVerify || config.RootCAs != nil {
+		client.TLSConfig = &tls.Config{
+			InsecureSkipVerify: config.InsecureSkipVerify,
+			RootCAs:            config.RootCAs,
+		}
+	}
+	return &Client{client: client, config: config}
+}
+
+// ErrorStatus returns the status to answer with when forwarding failed:
+// 504 if the upstream timed out, 502 otherwise.
+func ErrorStatus(err error) int {
+	if errors.Is(err, fasthttp.ErrTimeout) {
+		return fasthttp.StatusGatewayTimeout
+	}
+	return fasthttp.StatusBadGateway
+}
+
+// isIdempotent reports whether a request with this method is safe to send again.
+func isIdempotent(method string) bool {
+	switch method {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions,
+		fasthttp.MethodPut, fasthttp.MethodDelete, fasthttp.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// upstreamHost returns the Host header to send upstream, or "" to derive it from
+// the upstream URL.
+func (c *Client) upstreamHost(ctx *fasthttp.RequestCtx) string {
+	if c.config.HostOverride != "" {
+		return c.config.HostOverride
+	}
+	if c.config.PreserveHost {
+		return string(ctx.Host())
+	}
+	return ""
+}
+
+// do sends req, applying the configured timeout and retrying idempotent methods.
+func (c *Client) do(req *fasthttp.Request, resp *fasthttp.Response) error {
+	attempts := 1
+	if isIdempotent(string(req.Header.Method())) {
+		attempts += c.config.Retries
+	}
+	uri := req.URI().String()
+	var err error
+	for i := 0; i < attempts; i++ {
+		switch {
+		case c.config.FollowRedirects > 0:
+			// DoRedirects rewrites the URI while following, so each attempt starts over.
+			// It has no timeout variant; the request carries its own instead.
+			req.SetRequestURI(uri)
+			req.SetTimeout(c.config.Timeout)
+			err = c.client.DoRedirects(req, resp, c.config.FollowRedirects)
+		case c.config.Timeout > 0:
+			err = c.client.DoTimeout(req, resp, c.config.Timeout)
+		default:
+			err = c.client.Do(req, resp)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
 // ProxyRequest forwards a request to the upstream server and returns the response details.
-func ProxyRequest(client *fasthttp.Client, upstream string, ctx *fasthttp.RequestCtx) (int, map[string][]string, []byte, error) {
+func ProxyRequest(client *Client, upstream string, ctx *fasthttp.RequestCtx) (int, map[string][]string, []byte, error) {
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
 	defer fasthttp.ReleaseRequest(req)
@@ -29,12 +135,18 @@ func ProxyRequest(client *fasthttp.Client, upstream string, ctx *fasthttp.Reques
 		req.Header.SetBytesKV(key, value)
 	})
 
+	// Backends routing by Host may need the client's or an explicit value
+	if host := client.upstreamHost(ctx); host != "" {
+		req.Header.SetHost(host)
+		req.UseHostHeader = true
+	}
+
 	// Copy request body
 	if body := ctx.PostBody(); len(body) > 0 {
 		req.SetBody(body)
 	}
 
-	if err := client.Do(req, resp); err != nil {
+	if err := client.do(req, resp); err != nil {
 		return 0, nil, nil, err
 	}
 
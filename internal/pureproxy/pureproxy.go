@@ -18,14 +18,14 @@ import (
 type ProxyServer struct {
 	server   *types.Server
 	upstream string
-	client   *fasthttp.Client
+	client   *proxy.Client
 }
 
 func NewProxyServer(upstream, proxyHost, refererPath string, verbose bool) *ProxyServer {
 	return &ProxyServer{
 		server:   server.NewServer(proxyHost, refererPath, verbose, nil),
 		upstream: upstream,
-		client:   &fasthttp.Client{},
+		client:   proxy.NewClient(proxy.Config{}),
 	}
 }
 
@@ -44,7 +44,7 @@ func handleProxyRequest(ps *ProxyServer, ctx *fasthttp.RequestCtx) {
 	}
 
 	if ps.server.Verbose {
-		server.LogVerboseRequest(ctx, method, rawURI)
+		server.LogVerboseRequest(ps.server, ctx, method, rawURI)
 	}
 
 	// Transform request headers before proxying
@@ -58,8 +58,8 @@ func forwardAndRespond(ps *ProxyServer, ctx *fasthttp.RequestCtx) {
 	status, respHeaders, body, err := proxy.ProxyRequest(ps.client, ps.upstream, ctx)
 	if err != nil {
 		log.Printf("Proxy error: %v", err)
-		ctx.SetStatusCode(502)
-		ctx.SetBodyString(fmt.Sprintf(`{"error": "proxy error: %s"}`, err.Error()))
+		ctx.SetStatusCode(proxy.ErrorStatus(err))
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, "proxy error: "+err.Error()))
 		return
 	}
 
@@ -89,7 +89,7 @@ func forwardAndRespond(ps *ProxyServer, ctx *fasthttp.RequestCtx) {
 
 func RunProxy() {
 	port := common.GetPort()
-	const maxRequestBodySize = 16 * 1024 * 1024
+	maxRequestBodySize := common.MaxRequestBodySize()
 
 	upstream := os.Getenv("PROXY_HOST")
 	if upstream == "" {
@@ -104,6 +104,9 @@ func RunProxy() {
 
 	verbose := common.IsVerbose()
 	ps := NewProxyServer(upstream, upstream, refererPath, verbose)
+	ps.client = proxy.NewClient(common.ProxyConfig())
+	ps.server.AdminToken = common.AdminToken()
+	ps.server.JSONLogs = common.JSONLogFormat()
 
 	addr := fmt.Sprintf(":%d", port)
 
@@ -121,11 +124,11 @@ func RunProxy() {
 	httpServer := &fasthttp.Server{
 		Handler:            func(ctx *fasthttp.RequestCtx) { handleProxyRequest(ps, ctx) },
 		MaxRequestBodySize: maxRequestBodySize,
-		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
-			ctx.SetStatusCode(fasthttp.StatusBadRequest)
-			ctx.SetBodyString(err.Error())
-		},
+		ErrorHandler:       server.HandleServerError,
 	}
 
-	log.Fatal(httpServer.ListenAndServe(addr))
+	server.ApplyTuning(httpServer, common.ServerTuning())
+
+	certFile, keyFile := common.TLSFiles()
+	log.Fatal(server.ListenAndServe(httpServer, addr, certFile, keyFile))
 }
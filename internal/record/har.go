@@ -0,0 +1,238 @@
+// (C) 2025 GoodData Corporation
+package record
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// harFile is the subset of the HTTP Archive 1.2 format GoodMock reads and writes.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harPostData holds a request body. Encoding is not in the HAR spec, but some tools
+// (and GoodMock) set it to "base64" for binary request bodies, as for content.
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// exchangesToHAR renders recorded exchanges as a HAR document. URLs are made absolute
+// against upstream, and bodies that aren't text are base64-encoded.
+func exchangesToHAR(exchanges []RecordedExchange, upstream string, jsonContentTypes []string) ([]byte, error) {
+	entries := make([]harEntry, 0, len(exchanges))
+	started := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, ex := range exchanges {
+		req := harRequest{
+			Method:      ex.Method,
+			URL:         upstream + ex.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(ex.ReqHeaders),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(ex.ReqBody),
+		}
+		if u, err := url.Parse(ex.URL); err == nil {
+			req.QueryString = harHeaders(u.Query())
+		}
+		if len(ex.ReqBody) > 0 {
+			text, encoding := harBody(ex.ReqBody, ex.ReqHeaders, jsonContentTypes)
+			req.PostData = &harPostData{MimeType: firstHeader(ex.ReqHeaders, "Content-Type"), Text: text, Encoding: encoding}
+		}
+
+		text, encoding := harBody(ex.RespBody, ex.RespHeaders, jsonContentTypes)
+		entries = append(entries, harEntry{
+			StartedDateTime: started,
+			Request:         req,
+			Response: harResponse{
+				Status:      ex.Status,
+				StatusText:  fasthttp.StatusMessage(ex.Status),
+				HTTPVersion: "HTTP/1.1",
+				Cookies:     []harNameValue{},
+				Headers:     harHeaders(ex.RespHeaders),
+				Content: harContent{
+					Size:     len(ex.RespBody),
+					MimeType: firstHeader(ex.RespHeaders, "Content-Type"),
+					Text:     text,
+					Encoding: encoding,
+				},
+				RedirectURL: firstHeader(ex.RespHeaders, "Location"),
+				HeadersSize: -1,
+				BodySize:    len(ex.RespBody),
+			},
+		})
+	}
+	return json.MarshalIndent(harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "GoodMock", Version: "1"},
+		Entries: entries,
+	}}, "", "  ")
+}
+
+// harToExchanges converts the entries of a HAR document into recorded exchanges,
+// keeping only the path and query of each URL so they replay against any host.
+func harToExchanges(data []byte) ([]RecordedExchange, error) {
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, fmt.Errorf("invalid HAR: %w", err)
+	}
+	exchanges := make([]RecordedExchange, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: invalid url %q: %w", i, entry.Request.URL, err)
+		}
+		ex := RecordedExchange{
+			Method:      entry.Request.Method,
+			URL:         u.RequestURI(),
+			ReqHeaders:  harHeaderMap(entry.Request.Headers),
+			Status:      entry.Response.Status,
+			RespHeaders: harHeaderMap(entry.Response.Headers),
+		}
+		if pd := entry.Request.PostData; pd != nil {
+			if ex.ReqBody, err = harDecode(pd.Text, pd.Encoding); err != nil {
+				return nil, fmt.Errorf("entry %d: request body: %w", i, err)
+			}
+		}
+		if ex.RespBody, err = harDecode(entry.Response.Content.Text, entry.Response.Content.Encoding); err != nil {
+			return nil, fmt.Errorf("entry %d: response body: %w", i, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, nil
+}
+
+// harHeaders flattens a header or query map into HAR name/value pairs in sorted name order.
+func harHeaders(headers map[string][]string) []harNameValue {
+	pairs := make([]harNameValue, 0, len(headers))
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
+
+// harHeaderMap groups HAR headers by name. HTTP/2 pseudo-headers such as :authority,
+// which browsers include, are dropped.
+func harHeaderMap(pairs []harNameValue) map[string][]string {
+	headers := make(map[string][]string, len(pairs))
+	for _, h := range pairs {
+		if h.Name == "" || h.Name[0] == ':' {
+			continue
+		}
+		headers[h.Name] = append(headers[h.Name], h.Value)
+	}
+	return headers
+}
+
+// firstHeader returns the first value of the named header, ignoring case.
+func firstHeader(headers map[string][]string, name string) string {
+	for key, values := range headers {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+// harBody returns body as HAR text: verbatim for text content types, base64 otherwise.
+func harBody(body []byte, headers map[string][]string, jsonContentTypes []string) (string, string) {
+	if len(body) == 0 || isTextContentType(headers, jsonContentTypes) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+// harDecode reverses harBody.
+func harDecode(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		if text == "" {
+			return nil, nil
+		}
+		return []byte(text), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(text)
+	}
+	log.Printf("Warning: unknown HAR body encoding %q, using the text as-is", encoding)
+	return []byte(text), nil
+}
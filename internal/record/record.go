@@ -3,17 +3,23 @@ package record
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goodmock/internal/common"
 	"goodmock/internal/jsonutil"
+	"goodmock/internal/matching"
 	"goodmock/internal/proxy"
 	"goodmock/internal/server"
 	"goodmock/internal/types"
+	"io/fs"
 	"log"
+	"mime"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
@@ -26,6 +32,7 @@ import (
 type RecordedExchange struct {
 	Method      string
 	URL         string // raw URI (path + query string, percent-encoded)
+	ReqHeaders  map[string][]string
 	ReqBody     []byte
 	Status      int
 	RespHeaders map[string][]string
@@ -38,24 +45,35 @@ type RecordServer struct {
 	mu                 sync.Mutex
 	exchanges          []RecordedExchange
 	upstream           string
-	client             *fasthttp.Client
+	client             *proxy.Client
 	jsonContentTypes   []string
 	binaryContentTypes []string
 	preserveKeyOrder   bool
 	sortArrayMembers   bool
+	recordingsDir      string   // where persisted snapshots are written
+	captureHeaders     []string // request headers recorded as equalTo matchers
+	filesDir           string   // where extracted response bodies are written
+	extractBodiesOver  int      // extract bodies larger than this many bytes; 0 disables
+	stubsFirst         bool     // serve matching stubs instead of proxying them
+	responseRewrites   []types.RewriteRule
 }
 
 // NewRecordServer creates a new recording proxy server.
-func NewRecordServer(upstream, proxyHost, refererPath string, verbose bool, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool) *RecordServer {
+func NewRecordServer(upstream, proxyHost, refererPath string, verbose bool, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool, captureHeaders []string) *RecordServer {
+	s := server.NewServer(proxyHost, refererPath, verbose, nil)
+	s.JournalSize = 0 // record mode keeps its own log of exchanges
 	return &RecordServer{
-		server:             server.NewServer(proxyHost, refererPath, verbose, nil),
+		server:             s,
 		exchanges:          make([]RecordedExchange, 0),
 		upstream:           upstream,
-		client:             &fasthttp.Client{},
+		client:             proxy.NewClient(proxy.Config{}),
 		jsonContentTypes:   jsonContentTypes,
 		binaryContentTypes: binaryContentTypes,
 		preserveKeyOrder:   preserveKeyOrder,
 		sortArrayMembers:   sortArrayMembers,
+		captureHeaders:     captureHeaders,
+		recordingsDir:      "./mappings",
+		filesDir:           server.DefaultFilesDir,
 	}
 }
 
@@ -74,14 +92,30 @@ func handleRecordRequest(rs *RecordServer, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	// Decompress first so both the stubs and the recording see the plain body
+	if err := server.DecompressRequestBody(&ctx.Request, rs.server.MaxRequestBodySize); err != nil {
+		log.Printf("Rejected %s %s: request body over %d bytes once decompressed", method, rawURI, rs.server.MaxRequestBodySize)
+		server.RequestTooLarge(ctx, rs.server.MaxRequestBodySize)
+		return
+	}
 	if rs.server.Verbose {
-		server.LogVerboseRequest(ctx, method, rawURI)
+		server.LogVerboseRequest(rs.server, ctx, method, rawURI)
 	}
 
 	// Transform request headers before proxying
 	server.TransformRequestHeaders(&ctx.Request.Header, rs.server.ProxyHost, rs.server.RefererPath)
 
-	// In record mode, always proxy and record — no stub matching
+	// By default record mode always proxies; with stubs first, known endpoints are
+	// served from loaded stubs and only the rest is proxied and recorded
+	if rs.stubsFirst {
+		if result := server.ServeStub(rs.server, ctx); result.Matched {
+			if rs.server.Verbose {
+				log.Printf("[verbose] served %s %s from stub, not recorded", method, rawURI)
+			}
+			return
+		}
+	}
+
 	proxyAndRecord(rs, ctx)
 }
 
@@ -90,20 +124,29 @@ func proxyAndRecord(rs *RecordServer, ctx *fasthttp.RequestCtx) {
 	status, respHeaders, body, err := proxy.ProxyRequest(rs.client, rs.upstream, ctx)
 	if err != nil {
 		log.Printf("Proxy error: %v", err)
-		ctx.SetStatusCode(502)
-		ctx.SetBodyString(fmt.Sprintf(`{"error": "proxy error: %s"}`, err.Error()))
+		ctx.SetStatusCode(proxy.ErrorStatus(err))
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, "proxy error: "+err.Error()))
 		return
 	}
 
+	// Rewrite before recording so the client and the stored mapping see the same body
+	body = rewriteBody(body, respHeaders, rs.responseRewrites, rs.jsonContentTypes)
+
 	// Record the exchange
 	rawURI := string(ctx.RequestURI())
 	reqBody := ctx.PostBody()
 	reqBodyCopy := make([]byte, len(reqBody))
 	copy(reqBodyCopy, reqBody)
 
+	reqHeaders := make(map[string][]string)
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		reqHeaders[string(key)] = append(reqHeaders[string(key)], string(value))
+	})
+
 	exchange := RecordedExchange{
 		Method:      string(ctx.Method()),
 		URL:         rawURI,
+		ReqHeaders:  reqHeaders,
 		ReqBody:     reqBodyCopy,
 		Status:      status,
 		RespHeaders: respHeaders,
@@ -140,6 +183,18 @@ func proxyAndRecord(rs *RecordServer, ctx *fasthttp.RequestCtx) {
 	}
 }
 
+// rewriteBody applies the response rewrite rules to text and JSON bodies, in order.
+// Other bodies, such as images or archives, are returned unchanged.
+func rewriteBody(body []byte, headers map[string][]string, rules []types.RewriteRule, jsonContentTypes []string) []byte {
+	if len(rules) == 0 || !isTextContentType(headers, jsonContentTypes) {
+		return body
+	}
+	for _, rule := range rules {
+		body = bytes.ReplaceAll(body, []byte(rule.Find), []byte(rule.Replace))
+	}
+	return body
+}
+
 func clearExchanges(rs *RecordServer) {
 	rs.mu.Lock()
 	rs.exchanges = make([]RecordedExchange, 0)
@@ -147,15 +202,29 @@ func clearExchanges(rs *RecordServer) {
 }
 
 func handleRecordAdmin(rs *RecordServer, ctx *fasthttp.RequestCtx, path, method string) {
+	if !server.AdminAuthorized(rs.server, ctx, path) {
+		return
+	}
+
 	// Snapshot is record-mode specific
 	if path == "/__admin/recordings/snapshot" && method == "POST" {
 		handleSnapshot(rs, ctx)
 		return
 	}
 
+	// HAR export and import of the recorded exchanges
+	if path == "/__admin/recordings/har" && method == "GET" {
+		handleHARExport(rs, ctx)
+		return
+	}
+	if path == "/__admin/recordings/har" && method == "POST" {
+		handleHARImport(rs, ctx)
+		return
+	}
+
 	// Reset clears both stubs and recordings
 	if (path == "/__admin/reset" || path == "/__admin/mappings/reset") && method == "POST" {
-		server.ClearMappings(rs.server)
+		server.ResetMappings(rs.server, server.IncludePersistent(ctx))
 		clearExchanges(rs)
 		log.Println("All mappings and recordings reset")
 		ctx.SetStatusCode(fasthttp.StatusOK)
@@ -173,6 +242,45 @@ func handleRecordAdmin(rs *RecordServer, ctx *fasthttp.RequestCtx, path, method
 	server.HandleAdmin(rs.server, ctx, path, method)
 }
 
+// handleHARExport returns the recorded exchanges as a HAR document, for browser
+// devtools and other HAR tooling. The recording is left untouched.
+func handleHARExport(rs *RecordServer, ctx *fasthttp.RequestCtx) {
+	rs.mu.Lock()
+	exchanges := make([]RecordedExchange, len(rs.exchanges))
+	copy(exchanges, rs.exchanges)
+	rs.mu.Unlock()
+
+	data, err := exchangesToHAR(exchanges, rs.upstream, rs.jsonContentTypes)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(data)
+}
+
+// handleHARImport adds the entries of a posted HAR document, such as a browser session
+// saved from devtools, to the recorded exchanges. A snapshot then turns them into
+// mappings like any other recording.
+func handleHARImport(rs *RecordServer, ctx *fasthttp.RequestCtx) {
+	exchanges, err := harToExchanges(ctx.PostBody())
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+	rs.mu.Lock()
+	rs.exchanges = append(rs.exchanges, exchanges...)
+	rs.mu.Unlock()
+
+	log.Printf("Imported %d exchanges from HAR", len(exchanges))
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(fmt.Sprintf(`{"imported": %d}`, len(exchanges)))
+}
+
 // SnapshotRequest represents the body of a POST /__admin/recordings/snapshot request.
 type SnapshotRequest struct {
 	Filters struct {
@@ -211,15 +319,35 @@ func handleSnapshot(rs *RecordServer, ctx *fasthttp.RequestCtx) {
 	// as [] not null (Cypress spreads this array and null is not iterable)
 	mappings := make([]types.Mapping, 0)
 	if snapReq.RepeatsAsScenarios {
-		if m := exchangesToScenarioMappings(filtered, rs.jsonContentTypes, rs.binaryContentTypes, rs.preserveKeyOrder, rs.sortArrayMembers); m != nil {
+		if m := exchangesToScenarioMappings(filtered, rs.jsonContentTypes, rs.binaryContentTypes, rs.preserveKeyOrder, rs.sortArrayMembers, rs.captureHeaders); m != nil {
 			mappings = m
 		}
 	} else {
-		if m := exchangesToMappings(filtered, rs.jsonContentTypes, rs.binaryContentTypes, rs.preserveKeyOrder, rs.sortArrayMembers); m != nil {
+		if m := exchangesToMappings(filtered, rs.jsonContentTypes, rs.binaryContentTypes, rs.preserveKeyOrder, rs.sortArrayMembers, rs.captureHeaders); m != nil {
 			mappings = m
 		}
 	}
 
+	if rs.extractBodiesOver > 0 {
+		if err := extractBodies(mappings, rs.filesDir, rs.extractBodiesOver, rs.binaryContentTypes); err != nil {
+			log.Printf("Error extracting response bodies: %v", err)
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+			return
+		}
+	}
+
+	if snapReq.Persist {
+		files, err := persistMappings(rs.recordingsDir, mappings)
+		if err != nil {
+			log.Printf("Error persisting snapshot: %v", err)
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+			return
+		}
+		log.Printf("Persisted %d mappings to %s", len(files), rs.recordingsDir)
+	}
+
 	result := types.WiremockMappings{Mappings: mappings}
 	data, _ := json.Marshal(result)
 	ctx.Response.Header.Set("Content-Type", "application/json")
@@ -230,10 +358,138 @@ func handleSnapshot(rs *RecordServer, ctx *fasthttp.RequestCtx) {
 		len(mappings), snapReq.Filters.URLPattern, snapReq.RepeatsAsScenarios)
 }
 
+// extractBodies moves response bodies larger than threshold bytes into files in dir
+// and points the mappings at them via bodyFileName. The file holds exactly what replay
+// would have served, and is named after the mapping plus a content hash, so identical
+// bodies share one file.
+func extractBodies(mappings []types.Mapping, dir string, threshold int, binaryContentTypes []string) error {
+	for i := range mappings {
+		resp := &mappings[i].Response
+		contentType := responseContentType(resp.Headers)
+
+		var content []byte
+		ext := ""
+		switch {
+		case resp.JsonBody != nil:
+			data, err := json.Marshal(resp.JsonBody)
+			if err != nil {
+				return err
+			}
+			content, ext = data, ".json"
+		case resp.Body != "":
+			content = []byte(resp.Body)
+			if isContentType(map[string][]string{"Content-Type": {contentType}}, binaryContentTypes) {
+				decoded, err := base64.StdEncoding.DecodeString(resp.Body)
+				if err != nil {
+					return err
+				}
+				content = decoded
+			}
+			if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+				ext = exts[0]
+			}
+		}
+		if len(content) <= threshold {
+			continue
+		}
+
+		base := mappings[i].Name
+		if base == "" {
+			base = "root"
+		}
+		sum := sha256.Sum256(content)
+		name := fmt.Sprintf("%s-%x%s", base, sum[:6], ext)
+		if err := writeFileOnce(filepath.Join(dir, name), content); err != nil {
+			return err
+		}
+		resp.Body = ""
+		resp.JsonBody = nil
+		resp.BodyFileName = name
+	}
+	return nil
+}
+
+// writeFileOnce writes data to path unless the file already exists. Extracted bodies
+// are content-addressed, so an existing file already holds the same bytes.
+func writeFileOnce(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// responseContentType returns the Content-Type of recorded response headers, or "".
+func responseContentType(headers map[string]any) string {
+	for key, value := range headers {
+		if !strings.EqualFold(key, "Content-Type") {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			return v
+		case []interface{}:
+			if len(v) > 0 {
+				if s, ok := v[0].(string); ok {
+					return s
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// persistMappings writes each mapping to its own file in dir, in the same
+// {"mappings": [...]} format MAPPINGS_DIR loads. Files are named after the mapping;
+// a counter is appended when a name is already taken. Returns the written paths.
+func persistMappings(dir string, mappings []types.Mapping) ([]string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		data, err := json.MarshalIndent(types.WiremockMappings{Mappings: []types.Mapping{m}}, "", "  ")
+		if err != nil {
+			return files, err
+		}
+		base := m.Name
+		if base == "" {
+			base = "root"
+		}
+		for n := 1; ; n++ {
+			name := base + ".json"
+			if n > 1 {
+				name = fmt.Sprintf("%s-%d.json", base, n)
+			}
+			path := filepath.Join(dir, name)
+			// O_EXCL so an existing recording is never overwritten
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+			if errors.Is(err, fs.ErrExist) {
+				continue
+			}
+			if err != nil {
+				return files, err
+			}
+			_, err = f.Write(append(data, '\n'))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return files, err
+			}
+			files = append(files, path)
+			break
+		}
+	}
+	return files, nil
+}
+
 // exchangesToMappings converts exchanges to mappings, deduplicating by
 // method + path + query params + body (keeping the last occurrence).
 // This matches WireMock's snapshot behavior with repeatsAsScenarios=false.
-func exchangesToMappings(exchanges []RecordedExchange, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool) []types.Mapping {
+func exchangesToMappings(exchanges []RecordedExchange, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool, captureHeaders []string) []types.Mapping {
 	type dedupEntry struct {
 		key     string
 		mapping types.Mapping
@@ -243,8 +499,8 @@ func exchangesToMappings(exchanges []RecordedExchange, jsonContentTypes, binaryC
 	var entries []dedupEntry
 
 	for _, ex := range exchanges {
-		m := exchangeToMapping(ex, jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers)
-		key := deduplicationKey(m)
+		m := exchangeToMapping(ex, jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers, captureHeaders)
+		key := matching.DeduplicationKey(m)
 
 		if idx, exists := seen[key]; exists {
 			// Replace with later occurrence
@@ -263,33 +519,8 @@ func exchangesToMappings(exchanges []RecordedExchange, jsonContentTypes, binaryC
 	return mappings
 }
 
-// deduplicationKey builds a key from a mapping's request fields for deduplication.
-// Uses method + url/urlPath + sorted query params + body patterns.
-func deduplicationKey(m types.Mapping) string {
-	path := m.Request.URL
-	if path == "" {
-		path = m.Request.URLPath
-	}
-
-	key := m.Request.Method + " " + path
-
-	// Append query parameters (deterministic order)
-	if len(m.Request.QueryParameters) > 0 {
-		qpJSON, _ := json.Marshal(m.Request.QueryParameters)
-		key += " " + string(qpJSON)
-	}
-
-	// Append body patterns
-	if len(m.Request.BodyPatterns) > 0 {
-		bpJSON, _ := json.Marshal(m.Request.BodyPatterns)
-		key += " " + string(bpJSON)
-	}
-
-	return key
-}
-
 // exchangesToScenarioMappings converts exchanges to mappings, creating scenarios for repeated URLs.
-func exchangesToScenarioMappings(exchanges []RecordedExchange, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool) []types.Mapping {
+func exchangesToScenarioMappings(exchanges []RecordedExchange, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool, captureHeaders []string) []types.Mapping {
 	// Group by URL+method
 	type group struct {
 		key       string
@@ -314,15 +545,15 @@ func exchangesToScenarioMappings(exchanges []RecordedExchange, jsonContentTypes,
 		g := groups[key]
 		if len(g.exchanges) == 1 {
 			// Single occurrence — no scenario needed
-			mappings = append(mappings, exchangeToMapping(g.exchanges[0], jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers))
+			mappings = append(mappings, exchangeToMapping(g.exchanges[0], jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers, captureHeaders))
 		} else {
 			// Multiple occurrences — create scenario chain
-			scenarioName := generateMappingName(g.exchanges[0].URL)
+			scenarioName := server.GenerateMappingName(g.exchanges[0].URL)
 			for i, ex := range g.exchanges {
-				m := exchangeToMapping(ex, jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers)
+				m := exchangeToMapping(ex, jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers, captureHeaders)
 				m.ScenarioName = scenarioName
 				if i == 0 {
-					m.RequiredScenarioState = "Started"
+					m.RequiredScenarioState = types.ScenarioStarted
 				} else {
 					m.RequiredScenarioState = fmt.Sprintf("state_%d", i)
 				}
@@ -344,12 +575,12 @@ func sortMappings(mappings []types.Mapping) {
 		if mappings[i].Name != mappings[j].Name {
 			return mappings[i].Name < mappings[j].Name
 		}
-		return deduplicationKey(mappings[i]) < deduplicationKey(mappings[j])
+		return matching.DeduplicationKey(mappings[i]) < matching.DeduplicationKey(mappings[j])
 	})
 }
 
 // exchangeToMapping converts a recorded exchange to a WireMock mapping.
-func exchangeToMapping(ex RecordedExchange, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool) types.Mapping {
+func exchangeToMapping(ex RecordedExchange, jsonContentTypes, binaryContentTypes []string, preserveKeyOrder, sortArrayMembers bool, captureHeaders []string) types.Mapping {
 	// Split URL into path and query parameters
 	rawPath := ex.URL
 	var queryString string
@@ -358,7 +589,7 @@ func exchangeToMapping(ex RecordedExchange, jsonContentTypes, binaryContentTypes
 		queryString = ex.URL[idx+1:]
 	}
 
-	name := generateMappingName(ex.URL)
+	name := server.GenerateMappingName(ex.URL)
 
 	req := types.Request{
 		Method: ex.Method,
@@ -376,34 +607,18 @@ func exchangeToMapping(ex RecordedExchange, jsonContentTypes, binaryContentTypes
 		req.URL = rawPath
 	}
 
+	req.Headers = capturedHeaderMatchers(ex.ReqHeaders, captureHeaders)
+
 	// Add body pattern for requests with body
 	if len(ex.ReqBody) > 0 {
 		var bodyBytes []byte
-		if preserveKeyOrder {
-			if sortArrayMembers {
-				var parsed any
-				if json.Unmarshal(ex.ReqBody, &parsed) == nil {
-					parsed = jsonutil.SortArrays(parsed)
-					if b, err := json.Marshal(parsed); err == nil {
-						bodyBytes = b
-					}
-				}
-			} else {
-				compacted, err := compactJSON(ex.ReqBody)
-				if err == nil {
-					bodyBytes = compacted
-				}
-			}
-		} else {
-			var parsed any
-			if json.Unmarshal(ex.ReqBody, &parsed) == nil {
-				if sortArrayMembers {
-					parsed = jsonutil.SortArrays(parsed)
-				}
-				if b, err := json.Marshal(parsed); err == nil {
-					bodyBytes = b
-				}
+		if preserveKeyOrder && !sortArrayMembers {
+			compacted, err := compactJSON(ex.ReqBody)
+			if err == nil {
+				bodyBytes = compacted
 			}
+		} else if canonical, err := jsonutil.Canonicalize(ex.ReqBody, sortArrayMembers); err == nil {
+			bodyBytes = canonical
 		}
 		if bodyBytes != nil {
 			quoted, _ := json.Marshal(string(bodyBytes))
@@ -459,13 +674,9 @@ func exchangeToMapping(ex RecordedExchange, jsonContentTypes, binaryContentTypes
 				resp.Body = string(ex.RespBody)
 			}
 		} else {
-			// Unmarshal to interface{} — json.Marshal will sort keys alphabetically
-			var parsed any
-			if json.Unmarshal(ex.RespBody, &parsed) == nil {
-				if sortArrayMembers {
-					parsed = jsonutil.SortArrays(parsed)
-				}
-				resp.JsonBody = parsed
+			// Canonical form sorts keys alphabetically
+			if canonical, err := jsonutil.Canonicalize(ex.RespBody, sortArrayMembers); err == nil {
+				resp.JsonBody = json.RawMessage(canonical)
 			} else {
 				resp.Body = string(ex.RespBody)
 			}
@@ -481,6 +692,26 @@ func exchangeToMapping(ex RecordedExchange, jsonContentTypes, binaryContentTypes
 	}
 }
 
+// capturedHeaderMatchers builds equalTo matchers for the captured header names that
+// were sent with the request. Names are compared case-insensitively; only the first
+// value of a repeated header is used.
+func capturedHeaderMatchers(reqHeaders map[string][]string, captureHeaders []string) map[string]types.HeaderMatcher {
+	var matchers map[string]types.HeaderMatcher
+	for _, name := range captureHeaders {
+		for key, values := range reqHeaders {
+			if !strings.EqualFold(key, name) || len(values) == 0 {
+				continue
+			}
+			if matchers == nil {
+				matchers = make(map[string]types.HeaderMatcher)
+			}
+			matchers[normalizeHeaderName(key)] = types.HeaderMatcher{EqualTo: values[0]}
+			break
+		}
+	}
+	return matchers
+}
+
 // isContentType checks if the response Content-Type matches any of the given types.
 func isContentType(headers map[string][]string, contentTypes []string) bool {
 	if len(contentTypes) == 0 {
@@ -507,6 +738,31 @@ func isJSONContentType(headers map[string][]string, jsonTypes []string) bool {
 	return isContentType(headers, jsonTypes)
 }
 
+// isTextContentType checks if the response Content-Type is textual: text/*, one of
+// the JSON types, a +json or +xml suffix, or XML and JavaScript.
+func isTextContentType(headers map[string][]string, jsonTypes []string) bool {
+	if isJSONContentType(headers, jsonTypes) {
+		return true
+	}
+	for key, values := range headers {
+		if !strings.EqualFold(key, "Content-Type") {
+			continue
+		}
+		for _, v := range values {
+			mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(v, ";", 2)[0]))
+			switch {
+			case strings.HasPrefix(mediaType, "text/"),
+				strings.HasSuffix(mediaType, "+json"),
+				strings.HasSuffix(mediaType, "+xml"),
+				mediaType == "application/xml",
+				mediaType == "application/javascript":
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // normalizeHeaderName converts a header name to HTTP canonical form (Title-Case),
 // matching WireMock's header casing behavior.
 func normalizeHeaderName(name string) string {
@@ -575,20 +831,6 @@ func urlDecode(s string) string {
 	return decoded
 }
 
-// generateMappingName creates a WireMock-style name from a URL path.
-func generateMappingName(rawURL string) string {
-	path := rawURL
-	if idx := strings.IndexByte(rawURL, '?'); idx != -1 {
-		path = rawURL[:idx]
-	}
-	name := strings.TrimPrefix(path, "/")
-	name = strings.ReplaceAll(name, "/", "_")
-	name = strings.ReplaceAll(name, "%3A", "")
-	name = strings.ReplaceAll(name, "%3a", "")
-	name = strings.ToLower(name)
-	return name
-}
-
 // negativeLookaheadRe matches patterns like ((?!SOMETHING).)*
 var negativeLookaheadRe = regexp.MustCompile(`^\(?(?:\(\?\!(.+?)\)\.)\)\*$`)
 
@@ -623,7 +865,7 @@ func compileURLMatcher(pattern string) func(string) bool {
 
 func RunRecord() {
 	port := common.GetPort()
-	const maxRequestBodySize = 16 * 1024 * 1024
+	maxRequestBodySize := common.MaxRequestBodySize()
 
 	upstream := os.Getenv("PROXY_HOST")
 	if upstream == "" {
@@ -641,7 +883,21 @@ func RunRecord() {
 	binaryContentTypes := common.ParseBinaryContentTypes()
 	preserveKeyOrder := common.PreserveJSONKeyOrder()
 	sortArrayMembers := common.SortArrayMembers()
-	rs := NewRecordServer(upstream, upstream, refererPath, verbose, jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers)
+	captureHeaders := common.ParseCaptureHeaders()
+	rs := NewRecordServer(upstream, upstream, refererPath, verbose, jsonContentTypes, binaryContentTypes, preserveKeyOrder, sortArrayMembers, captureHeaders)
+	rs.recordingsDir = common.RecordingsDir()
+	rs.filesDir = common.FilesDir()
+	rs.extractBodiesOver = common.ExtractBodiesOver()
+	rs.stubsFirst = common.RecordStubsFirst()
+	rs.responseRewrites = common.ResponseRewrites()
+	rs.server.AdminToken = common.AdminToken()
+	rs.server.JSONLogs = common.JSONLogFormat()
+	rs.server.MaxRequestBodySize = maxRequestBodySize
+	rs.server.NumberTolerance = common.JSONNumberTolerance()
+	rs.server.NormalizeTrailingSlash = common.NormalizeTrailingSlash()
+	rs.server.CaseInsensitiveQueryNames = common.CaseInsensitiveQueryNames()
+	rs.client = proxy.NewClient(common.ProxyConfig())
+	rs.server.ProxyClient = rs.client
 
 	addr := fmt.Sprintf(":%d", port)
 
@@ -659,11 +915,11 @@ func RunRecord() {
 	httpServer := &fasthttp.Server{
 		Handler:            func(ctx *fasthttp.RequestCtx) { handleRecordRequest(rs, ctx) },
 		MaxRequestBodySize: maxRequestBodySize,
-		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
-			ctx.SetStatusCode(fasthttp.StatusBadRequest)
-			ctx.SetBodyString(err.Error())
-		},
+		ErrorHandler:       server.HandleServerError,
 	}
 
-	log.Fatal(httpServer.ListenAndServe(addr))
+	server.ApplyTuning(httpServer, common.ServerTuning())
+
+	certFile, keyFile := common.TLSFiles()
+	log.Fatal(server.ListenAndServe(httpServer, addr, certFile, keyFile))
 }
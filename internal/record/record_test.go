@@ -0,0 +1,493 @@
+package record
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/proxy"
+	"goodmock/internal/server"
+	"goodmock/internal/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// newTestRecordServer returns a record server with default settings and no upstream.
+func newTestRecordServer() *RecordServer {
+	return NewRecordServer("http://upstream.invalid", "", "/", false, []string{"application/json"}, nil, false, false, nil)
+}
+
+// jsonExchange builds a recorded exchange with a JSON response.
+func jsonExchange(method, url, body string) RecordedExchange {
+	return RecordedExchange{
+		Method:      method,
+		URL:         url,
+		Status:      200,
+		RespHeaders: map[string][]string{"Content-Type": {"application/json"}},
+		RespBody:    []byte(body),
+	}
+}
+
+// snapshot posts a snapshot request and returns the decoded mappings.
+func snapshot(t *testing.T, rs *RecordServer, body string) []types.Mapping {
+	t.Helper()
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/__admin/recordings/snapshot")
+	ctx.Request.SetBodyString(body)
+	handleRecordRequest(rs, ctx)
+	if status := ctx.Response.StatusCode(); status != 200 {
+		t.Fatalf("snapshot = %d %q", status, ctx.Response.Body())
+	}
+	var wm types.WiremockMappings
+	if err := json.Unmarshal(ctx.Response.Body(), &wm); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	return wm.Mappings
+}
+
+func TestSnapshotPersist(t *testing.T) {
+	rs := newTestRecordServer()
+	rs.recordingsDir = filepath.Join(t.TempDir(), "recordings")
+	rs.exchanges = []RecordedExchange{
+		jsonExchange("GET", "/api/items", `{"items":[]}`),
+		jsonExchange("DELETE", "/api/items", `{}`),
+		jsonExchange("GET", "/api/users", `{"users":[]}`),
+	}
+
+	mappings := snapshot(t, rs, `{"persist": true}`)
+	if len(mappings) != 3 {
+		t.Fatalf("snapshot returned %d mappings, want 3", len(mappings))
+	}
+
+	entries, err := os.ReadDir(rs.recordingsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"api_items-2.json", "api_items.json", "api_users.json"}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+
+	// Each file holds one mapping, in the order the snapshot returned them
+	for i, name := range []string{"api_items.json", "api_items-2.json", "api_users.json"} {
+		data, err := os.ReadFile(filepath.Join(rs.recordingsDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wm types.WiremockMappings
+		if err := json.Unmarshal(data, &wm); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(wm.Mappings) != 1 || !reflect.DeepEqual(wm.Mappings[0], mappings[i]) {
+			t.Errorf("%s = %+v, want %+v", name, wm.Mappings, mappings[i])
+		}
+	}
+}
+
+func TestSnapshotWithoutPersistWritesNothing(t *testing.T) {
+	rs := newTestRecordServer()
+	rs.recordingsDir = filepath.Join(t.TempDir(), "recordings")
+	rs.exchanges = []RecordedExchange{jsonExchange("GET", "/api/items", `{}`)}
+
+	if mappings := snapshot(t, rs, `{"persist": false}`); len(mappings) != 1 {
+		t.Fatalf("snapshot returned %d mappings, want 1", len(mappings))
+	}
+	if _, err := os.Stat(rs.recordingsDir); !os.IsNotExist(err) {
+		t.Errorf("recordings dir should not be created without persist, stat err = %v", err)
+	}
+}
+
+func TestCaptureHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"accept":%q}`, r.Header.Get("Accept"))
+	}))
+	defer upstream.Close()
+
+	rs := NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, []string{"accept", "X-Tenant"})
+	for _, accept := range []string{"application/json", "text/csv"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI("/api/report")
+		ctx.Request.Header.Set("Accept", accept)
+		ctx.Request.Header.Set("X-Other", "ignored")
+		handleRecordRequest(rs, ctx)
+		if status := ctx.Response.StatusCode(); status != 200 {
+			t.Fatalf("proxied request = %d %q", status, ctx.Response.Body())
+		}
+	}
+
+	mappings := snapshot(t, rs, `{}`)
+	if len(mappings) != 2 {
+		t.Fatalf("got %d mappings, want one per Accept value", len(mappings))
+	}
+	seen := make(map[string]bool)
+	for _, m := range mappings {
+		h := m.Request.Headers
+		if len(h) != 1 {
+			t.Errorf("headers = %+v, want only Accept (X-Tenant not sent, X-Other not captured)", h)
+		}
+		seen[h["Accept"].EqualTo] = true
+	}
+	if !seen["application/json"] || !seen["text/csv"] {
+		t.Errorf("Accept matchers = %v", seen)
+	}
+}
+
+func TestRecordDecompressesRequestBody(t *testing.T) {
+	var upstreamBody, upstreamEncoding string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		upstreamBody, upstreamEncoding = string(b), r.Header.Get("Content-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{}`)
+	}))
+	defer upstream.Close()
+
+	rs := NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, nil)
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/api/execute")
+	ctx.Request.Header.SetContentEncoding("gzip")
+	ctx.Request.SetBody(fasthttp.AppendGzipBytes(nil, []byte(`{"b": 2, "a": 1}`)))
+	handleRecordRequest(rs, ctx)
+
+	if upstreamBody != `{"b": 2, "a": 1}` || upstreamEncoding != "" {
+		t.Errorf("upstream got %q with Content-Encoding %q, want the plain body", upstreamBody, upstreamEncoding)
+	}
+	mappings := snapshot(t, rs, `{}`)
+	if len(mappings) != 1 || len(mappings[0].Request.BodyPatterns) != 1 {
+		t.Fatalf("mappings = %+v", mappings)
+	}
+	if got := string(mappings[0].Request.BodyPatterns[0].EqualToJSON); got != `"{\"a\":1,\"b\":2}"` {
+		t.Errorf("equalToJson = %s, want the readable body", got)
+	}
+}
+
+func TestRecordRejectsOversizedDecompressedBody(t *testing.T) {
+	upstreamCalled := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalled = true
+	}))
+	defer upstream.Close()
+
+	rs := NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, nil)
+	rs.server.MaxRequestBodySize = 64
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/api/upload")
+	ctx.Request.Header.SetContentEncoding("gzip")
+	ctx.Request.SetBody(fasthttp.AppendGzipBytes(nil, make([]byte, 1000)))
+	handleRecordRequest(rs, ctx)
+
+	if status := ctx.Response.StatusCode(); status != fasthttp.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", status)
+	}
+	if upstreamCalled || len(rs.exchanges) != 0 {
+		t.Errorf("an oversized request must be neither proxied nor recorded")
+	}
+}
+
+func TestCaptureHeadersDisabled(t *testing.T) {
+	ex := jsonExchange("GET", "/api/report", `{}`)
+	ex.ReqHeaders = map[string][]string{"Accept": {"application/json"}}
+	m := exchangeToMapping(ex, []string{"application/json"}, nil, false, false, nil)
+	if m.Request.Headers != nil {
+		t.Errorf("headers = %+v, want none without CAPTURE_HEADERS", m.Request.Headers)
+	}
+}
+
+func TestExtractLargeBodies(t *testing.T) {
+	rs := newTestRecordServer()
+	rs.filesDir = filepath.Join(t.TempDir(), "__files")
+	rs.extractBodiesOver = 32
+	large := `{"rows":[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16]}`
+	rs.exchanges = []RecordedExchange{
+		jsonExchange("GET", "/api/report", large),
+		jsonExchange("GET", "/api/small", `{"ok":true}`),
+	}
+
+	mappings := snapshot(t, rs, `{}`)
+	if len(mappings) != 2 {
+		t.Fatalf("got %d mappings, want 2", len(mappings))
+	}
+	report, small := mappings[0], mappings[1]
+
+	if small.Response.BodyFileName != "" || small.Response.JsonBody == nil {
+		t.Errorf("small body should stay inline, got %+v", small.Response)
+	}
+	name := report.Response.BodyFileName
+	if !strings.HasPrefix(name, "api_report-") || !strings.HasSuffix(name, ".json") {
+		t.Fatalf("bodyFileName = %q, want api_report-<hash>.json", name)
+	}
+	if report.Response.JsonBody != nil || report.Response.Body != "" {
+		t.Errorf("extracted body should not also be inlined: %+v", report.Response)
+	}
+	data, err := os.ReadFile(filepath.Join(rs.filesDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != large {
+		t.Errorf("file = %s, want %s", data, large)
+	}
+
+	// The extracted mapping replays the original body
+	s := server.NewServer("", "/", false, nil)
+	s.FilesDir = rs.filesDir
+	server.LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{report}})
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("GET")
+	ctx.Request.SetRequestURI("/api/report")
+	server.HandleRequest(s, ctx)
+	if got := string(ctx.Response.Body()); got != large {
+		t.Errorf("replayed body = %s, want %s", got, large)
+	}
+}
+
+func TestExtractBodiesDedupesIdenticalContent(t *testing.T) {
+	dir := t.TempDir()
+	body := strings.Repeat("x", 100)
+	mappings := []types.Mapping{
+		{Name: "api_export", Response: types.Response{Status: 200, Body: body, Headers: map[string]any{"Content-Type": "text/csv"}}},
+		{Name: "api_export", Response: types.Response{Status: 200, Body: body, Headers: map[string]any{"Content-Type": "text/csv"}}},
+	}
+	if err := extractBodies(mappings, dir, 10, nil); err != nil {
+		t.Fatal(err)
+	}
+	if mappings[0].Response.BodyFileName == "" || mappings[0].Response.BodyFileName != mappings[1].Response.BodyFileName {
+		t.Errorf("identical bodies got files %q and %q", mappings[0].Response.BodyFileName, mappings[1].Response.BodyFileName)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("wrote %d files, want 1", len(entries))
+	}
+}
+
+func TestStubsFirst(t *testing.T) {
+	var proxied []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"source":"upstream"}`)
+	}))
+	defer upstream.Close()
+
+	stub := types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/api/known"},
+		Response: types.Response{Status: 200, Body: "from stub"},
+	}}}
+	get := func(rs *RecordServer, uri string) string {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI(uri)
+		handleRecordRequest(rs, ctx)
+		return string(ctx.Response.Body())
+	}
+
+	rs := NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, nil)
+	rs.stubsFirst = true
+	server.LoadMappings(rs.server, stub)
+	if body := get(rs, "/api/known"); body != "from stub" {
+		t.Errorf("/api/known = %q, want the stub", body)
+	}
+	if body := get(rs, "/api/unknown"); body != `{"source":"upstream"}` {
+		t.Errorf("/api/unknown = %q, want the upstream response", body)
+	}
+	if len(proxied) != 1 || proxied[0] != "/api/unknown" {
+		t.Errorf("proxied %v, want only /api/unknown", proxied)
+	}
+	if len(rs.exchanges) != 1 || rs.exchanges[0].URL != "/api/unknown" {
+		t.Errorf("recorded %+v, want only /api/unknown", rs.exchanges)
+	}
+
+	// Without the flag every request is proxied, even with a matching stub loaded
+	proxied = nil
+	rs = NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, nil)
+	server.LoadMappings(rs.server, stub)
+	if body := get(rs, "/api/known"); body != `{"source":"upstream"}` {
+		t.Errorf("default mode /api/known = %q, want the upstream response", body)
+	}
+	if len(proxied) != 1 {
+		t.Errorf("default mode proxied %v, want /api/known", proxied)
+	}
+}
+
+func TestFollowRedirects(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/middle", http.StatusFound)
+		case "/middle":
+			http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"page":"final"}`)
+		}
+	}))
+	defer upstream.Close()
+
+	get := func(rs *RecordServer, uri string) (int, string) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI(uri)
+		handleRecordRequest(rs, ctx)
+		return ctx.Response.StatusCode(), string(ctx.Response.Body())
+	}
+
+	rs := NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, nil)
+	rs.client = proxy.NewClient(proxy.Config{FollowRedirects: 2})
+	if status, body := get(rs, "/start?x=1"); status != 200 || body != `{"page":"final"}` {
+		t.Errorf("/start = %d %q, want the final resource", status, body)
+	}
+	if len(rs.exchanges) != 1 {
+		t.Fatalf("recorded %d exchanges, want 1", len(rs.exchanges))
+	}
+	if ex := rs.exchanges[0]; ex.URL != "/start?x=1" || ex.Status != 200 || string(ex.RespBody) != `{"page":"final"}` {
+		t.Errorf("recorded %s %d %q, want the original URL with the final response", ex.URL, ex.Status, ex.RespBody)
+	}
+
+	if status, _ := get(rs, "/loop"); status != http.StatusBadGateway {
+		t.Errorf("/loop = %d, want 502 once the redirect cap is hit", status)
+	}
+
+	// Without the option the redirect itself is recorded
+	rs = NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, nil, false, false, nil)
+	if status, _ := get(rs, "/start"); status != http.StatusFound {
+		t.Errorf("default /start = %d, want 302", status)
+	}
+}
+
+func TestResponseRewrite(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/items":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"self":"https://staging.example.com/api/items","next":"https://staging.example.com/api/items?page=2"}`)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, `<a href="https://staging.example.com/home">home</a>`)
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+			fmt.Fprint(w, "https://staging.example.com")
+		}
+	}))
+	defer upstream.Close()
+
+	rs := NewRecordServer(upstream.URL, "", "/", false, []string{"application/json"}, []string{"application/octet-stream"}, false, false, nil)
+	rs.responseRewrites = []types.RewriteRule{{Find: "https://staging.example.com", Replace: "http://localhost:8080"}}
+	for _, uri := range []string{"/api/items", "/page", "/blob"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI(uri)
+		handleRecordRequest(rs, ctx)
+		if status := ctx.Response.StatusCode(); status != 200 {
+			t.Fatalf("proxied %s = %d %q", uri, status, ctx.Response.Body())
+		}
+		if uri == "/api/items" && strings.Contains(string(ctx.Response.Body()), "staging") {
+			t.Errorf("client got unrewritten body %s", ctx.Response.Body())
+		}
+	}
+
+	bodies := make(map[string]string)
+	for _, m := range snapshot(t, rs, `{}`) {
+		body := m.Response.Body
+		if m.Response.JsonBody != nil {
+			data, _ := json.Marshal(m.Response.JsonBody)
+			body = string(data)
+		}
+		bodies[m.Request.URL] = body
+	}
+	if want := `{"next":"http://localhost:8080/api/items?page=2","self":"http://localhost:8080/api/items"}`; bodies["/api/items"] != want {
+		t.Errorf("JSON body = %s, want %s", bodies["/api/items"], want)
+	}
+	if want := `<a href="http://localhost:8080/home">home</a>`; bodies["/page"] != want {
+		t.Errorf("HTML body = %s, want %s", bodies["/page"], want)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(bodies["/blob"]); string(decoded) != "https://staging.example.com" {
+		t.Errorf("binary body = %q, want it left unchanged", bodies["/blob"])
+	}
+}
+
+func TestHARRoundTrip(t *testing.T) {
+	post := RecordedExchange{
+		Method:      "POST",
+		URL:         "/api/execute?workspace=demo&workspace=prod",
+		ReqHeaders:  map[string][]string{"Content-Type": {"application/json"}},
+		ReqBody:     []byte(`{"measures":["m1"]}`),
+		Status:      201,
+		RespHeaders: map[string][]string{"Content-Type": {"application/json"}, "Location": {"/api/results/1"}},
+		RespBody:    []byte(`{"id":1}`),
+	}
+	image := RecordedExchange{
+		Method:      "GET",
+		URL:         "/logo.png",
+		ReqHeaders:  map[string][]string{"Accept": {"image/png", "*/*"}},
+		Status:      200,
+		RespHeaders: map[string][]string{"Content-Type": {"image/png"}},
+		RespBody:    []byte{0x89, 'P', 'N', 'G', 0x00, 0xff},
+	}
+	rs := newTestRecordServer()
+	rs.exchanges = []RecordedExchange{post, image}
+	want := snapshot(t, rs, `{}`)
+	rs.exchanges = []RecordedExchange{post, image}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("GET")
+	ctx.Request.SetRequestURI("/__admin/recordings/har")
+	handleRecordRequest(rs, ctx)
+	har := append([]byte(nil), ctx.Response.Body()...)
+
+	var doc harFile
+	if err := json.Unmarshal(har, &doc); err != nil {
+		t.Fatalf("export is not valid HAR: %v", err)
+	}
+	if len(doc.Log.Entries) != 2 || doc.Log.Version != "1.2" {
+		t.Fatalf("exported %d entries (version %q), want 2", len(doc.Log.Entries), doc.Log.Version)
+	}
+	first := doc.Log.Entries[0]
+	if first.Request.URL != "http://upstream.invalid/api/execute?workspace=demo&workspace=prod" || first.Response.StatusText != "Created" ||
+		!reflect.DeepEqual(first.Request.QueryString, []harNameValue{{"workspace", "demo"}, {"workspace", "prod"}}) {
+		t.Errorf("first entry = %+v", first)
+	}
+	if content := doc.Log.Entries[1].Response.Content; content.Encoding != "base64" || content.MimeType != "image/png" {
+		t.Errorf("binary content = %+v, want it base64-encoded", content)
+	}
+
+	imported := newTestRecordServer()
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/__admin/recordings/har")
+	ctx.Request.SetBody(har)
+	handleRecordRequest(imported, ctx)
+	if status, body := ctx.Response.StatusCode(), string(ctx.Response.Body()); status != 200 || body != `{"imported": 2}` {
+		t.Fatalf("import = %d %s", status, body)
+	}
+	if !reflect.DeepEqual(imported.exchanges, []RecordedExchange{post, image}) {
+		t.Errorf("imported exchanges =\n%+v\nwant\n%+v", imported.exchanges, []RecordedExchange{post, image})
+	}
+	if got := snapshot(t, imported, `{}`); !reflect.DeepEqual(got, want) {
+		t.Errorf("mappings from HAR =\n%+v\nwant\n%+v", got, want)
+	}
+
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/__admin/recordings/har")
+	ctx.Request.SetBodyString(`{"log": {"entries": [{"request": {"url": "http://x/"}, "response": {"content": {"text": "%", "encoding": "base64"}}}]}}`)
+	handleRecordRequest(imported, ctx)
+	if status := ctx.Response.StatusCode(); status != 400 {
+		t.Errorf("invalid HAR import = %d, want 400", status)
+	}
+}
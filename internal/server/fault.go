@@ -0,0 +1,62 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"crypto/rand"
+	"log"
+	"net"
+
+	"github.com/valyala/fasthttp"
+)
+
+// WireMock-compatible fault types for Response.Fault.
+const (
+	FaultEmptyResponse          = "EMPTY_RESPONSE"
+	FaultConnectionResetByPeer  = "CONNECTION_RESET_BY_PEER"
+	FaultMalformedResponseChunk = "MALFORMED_RESPONSE_CHUNK"
+	FaultRandomDataThenClose    = "RANDOM_DATA_THEN_CLOSE"
+)
+
+// applyFault hijacks the connection to simulate a broken upstream instead of
+// sending a regular response. fasthttp closes the connection once the hijack
+// handler returns. Returns false for unknown fault types.
+func applyFault(ctx *fasthttp.RequestCtx, fault string) bool {
+	var handler fasthttp.HijackHandler
+	switch fault {
+	case FaultEmptyResponse:
+		handler = func(c net.Conn) {}
+	case FaultConnectionResetByPeer:
+		handler = func(c net.Conn) {
+			// SO_LINGER=0 makes the following close send a TCP RST instead of a FIN
+			if tcp, ok := rawConn(c).(*net.TCPConn); ok {
+				tcp.SetLinger(0)
+			}
+		}
+	case FaultMalformedResponseChunk:
+		handler = func(c net.Conn) {
+			c.Write([]byte("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n"))
+			c.Write([]byte("lskdu018973t09sylgasjkfg1][]'./.sdlv"))
+		}
+	case FaultRandomDataThenClose:
+		handler = func(c net.Conn) {
+			garbage := make([]byte, 256)
+			rand.Read(garbage)
+			c.Write(garbage)
+		}
+	default:
+		log.Printf("Warning: unknown fault type %q", fault)
+		return false
+	}
+
+	ctx.HijackSetNoResponse(true)
+	ctx.Hijack(handler)
+	return true
+}
+
+// rawConn unwraps fasthttp's hijacked connection wrapper to reach the underlying net.Conn.
+func rawConn(c net.Conn) net.Conn {
+	if u, ok := c.(interface{ UnsafeConn() net.Conn }); ok {
+		return u.UnsafeConn()
+	}
+	return c
+}
@@ -0,0 +1,30 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// DefaultFilesDir is where bodyFileName paths are resolved when FILES_DIR is unset.
+const DefaultFilesDir = "./__files"
+
+// readBodyFile reads a response body file relative to the files root. Names that
+// are absolute or escape the root (e.g. via "..") are rejected.
+func readBodyFile(root, name string) ([]byte, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return nil, fmt.Errorf("bodyFileName %q must be a relative path inside the files directory", name)
+	}
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, fmt.Errorf("could not read bodyFileName %q: %w", name, err)
+	}
+	return data, nil
+}
+
+// contentTypeForFile infers a Content-Type from the file extension, or "" if unknown.
+func contentTypeForFile(name string) string {
+	return mime.TypeByExtension(filepath.Ext(name))
+}
@@ -0,0 +1,130 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"goodmock/internal/types"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/valyala/fasthttp"
+)
+
+// gzipResponse compresses the response body when the stub sets "gzip": true or
+// GZIP_RESPONSES_OVER enables it globally, and the client's original Accept-Encoding
+// allows gzip. Only bodies larger than GzipOver bytes are compressed, and bodies
+// that already carry a Content-Encoding are left alone.
+func gzipResponse(s *types.Server, ctx *fasthttp.RequestCtx, resp *types.Response, acceptEncoding string) {
+	if !resp.Gzip && s.GzipOver == 0 {
+		return
+	}
+	body := ctx.Response.Body()
+	if len(body) == 0 || len(body) <= s.GzipOver {
+		return
+	}
+	if len(ctx.Response.Header.ContentEncoding()) > 0 || !acceptsGzip(acceptEncoding) {
+		return
+	}
+	ctx.Response.SetBody(fasthttp.AppendGzipBytes(nil, body))
+	ctx.Response.Header.SetContentEncoding("gzip")
+	ctx.Response.Header.Add(fasthttp.HeaderVary, "Accept-Encoding")
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip, honouring
+// q-values so that "gzip;q=0" refuses it.
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		name, value, _ := strings.Cut(strings.TrimSpace(params), "=")
+		if strings.EqualFold(strings.TrimSpace(name), "q") {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ErrRequestBodyTooLarge is returned by DecompressRequestBody when the decoded body
+// would exceed the limit.
+var ErrRequestBodyTooLarge = errors.New("request body too large")
+
+// DecompressRequestBody replaces a compressed request body (Content-Encoding gzip,
+// deflate, br or zstd) with its plain bytes and drops the header, so matching,
+// the journal and recordings see readable content. A body that fails to decode is
+// left untouched. Decoding stops after limit bytes (0 means no limit) so a small
+// compression bomb can't exhaust memory; a body that expands past the limit yields
+// ErrRequestBodyTooLarge and is left untouched as well.
+func DecompressRequestBody(req *fasthttp.Request, limit int) error {
+	encoding := string(req.Header.ContentEncoding())
+	if encoding == "" || strings.EqualFold(encoding, "identity") {
+		return nil
+	}
+	body, err := decodeBody(strings.ToLower(encoding), req.Body(), limit)
+	if errors.Is(err, ErrRequestBodyTooLarge) {
+		return err
+	}
+	if err != nil {
+		log.Printf("Warning: could not decode %s request body: %v", encoding, err)
+		return nil
+	}
+	req.SetBody(body)
+	req.Header.Del(fasthttp.HeaderContentEncoding)
+	return nil
+}
+
+// decodeBody decodes data in the given content encoding, reading at most limit+1
+// decoded bytes so an oversized result is detected without materializing it.
+func decodeBody(encoding string, data []byte, limit int) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "br":
+		r = brotli.NewReader(bytes.NewReader(data))
+	case "zstd":
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, fasthttp.ErrContentEncodingUnsupported
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, int64(limit)+1)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(body) > limit {
+		return nil, ErrRequestBodyTooLarge
+	}
+	return body, nil
+}
@@ -0,0 +1,228 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/matching"
+	"goodmock/internal/types"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultJournalSize is how many requests a new server keeps in its request journal.
+const DefaultJournalSize = 1000
+
+// newUUID returns a random (version 4) UUID string.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newLoggedRequest captures the request as the client sent it. Call it before
+// TransformRequestHeaders so the journal shows the original headers.
+func newLoggedRequest(ctx *fasthttp.RequestCtx, method, rawURI string) types.LoggedRequest {
+	now := time.Now()
+	req := types.LoggedRequest{
+		URL:              rawURI,
+		Method:           method,
+		Headers:          make(map[string][]string),
+		Body:             string(ctx.PostBody()),
+		LoggedDate:       now.UnixMilli(),
+		LoggedDateString: now.UTC().Format(time.RFC3339Nano),
+	}
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		req.Headers[string(key)] = append(req.Headers[string(key)], string(value))
+	})
+	return req
+}
+
+// recordServeEvent appends a request and its match outcome to the journal, evicting
+// the oldest entry once it is full.
+func recordServeEvent(s *types.Server, req types.LoggedRequest, result *types.MatchResult) {
+	if s.JournalSize <= 0 {
+		return
+	}
+	event := types.ServeEvent{ID: newUUID(), Request: req, WasMatched: result.Matched}
+	if result.Mapping != nil {
+		// Copy so later mapping edits don't rewrite history
+		stub := *result.Mapping
+		if result.Matched {
+			event.StubMapping = &stub
+		} else {
+			event.NearMiss = &types.NearMiss{
+				Request:     req,
+				StubMapping: &stub,
+				MatchResult: matching.NewMatchDiff(result),
+			}
+		}
+	}
+
+	s.JournalMu.Lock()
+	defer s.JournalMu.Unlock()
+	if len(s.Journal) >= s.JournalSize {
+		s.Journal = append(s.Journal[len(s.Journal)-s.JournalSize+1:], event)
+	} else {
+		s.Journal = append(s.Journal, event)
+	}
+}
+
+// ClearJournal removes every entry from the request journal.
+func ClearJournal(s *types.Server) {
+	s.JournalMu.Lock()
+	s.Journal = nil
+	s.JournalMu.Unlock()
+}
+
+// journalSnapshot returns the journaled requests, newest first like WireMock.
+func journalSnapshot(s *types.Server) []types.ServeEvent {
+	s.JournalMu.Lock()
+	defer s.JournalMu.Unlock()
+	events := make([]types.ServeEvent, len(s.Journal))
+	for i, e := range s.Journal {
+		events[len(events)-1-i] = e
+	}
+	return events
+}
+
+// writeServeEvents responds with events in WireMock's request journal envelope.
+func writeServeEvents(s *types.Server, ctx *fasthttp.RequestCtx, events []types.ServeEvent) {
+	envelope := struct {
+		Requests               []types.ServeEvent `json:"requests"`
+		Meta                   map[string]int     `json:"meta"`
+		RequestJournalDisabled bool               `json:"requestJournalDisabled"`
+	}{events, map[string]int{"total": len(events)}, s.JournalSize <= 0}
+
+	data, _ := json.Marshal(envelope)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(data)
+}
+
+// handleRequests serves /__admin/requests.
+func handleRequests(s *types.Server, ctx *fasthttp.RequestCtx, method string) {
+	switch strings.ToUpper(method) {
+	case "GET":
+		writeServeEvents(s, ctx, journalSnapshot(s))
+	case "DELETE":
+		ClearJournal(s)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	default:
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+	}
+}
+
+// findServeEvents returns the journaled requests (newest first) that satisfy the criteria.
+func findServeEvents(s *types.Server, criteria *types.Request) []types.ServeEvent {
+	found := make([]types.ServeEvent, 0)
+	for _, e := range journalSnapshot(s) {
+		if matching.MatchesCriteria(s, criteria, &e.Request) {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+// parseCriteria decodes a WireMock request-criteria body, responding 400 if it is invalid.
+func parseCriteria(ctx *fasthttp.RequestCtx) (*types.Request, bool) {
+	var criteria types.Request
+	if err := json.Unmarshal(ctx.PostBody(), &criteria); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(err.Error())
+		return nil, false
+	}
+	return &criteria, true
+}
+
+// handleRequestsCount serves POST /__admin/requests/count.
+func handleRequestsCount(s *types.Server, ctx *fasthttp.RequestCtx) {
+	criteria, ok := parseCriteria(ctx)
+	if !ok {
+		return
+	}
+	data, _ := json.Marshal(map[string]int{"count": len(findServeEvents(s, criteria))})
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(data)
+}
+
+// handleMappingRequestsCount serves GET /__admin/mappings/{id}/requests/count: how many
+// journaled requests were served by that stub.
+func handleMappingRequestsCount(s *types.Server, ctx *fasthttp.RequestCtx, id string) {
+	if _, ok := GetMapping(s, id); !ok {
+		mappingNotFound(ctx, id)
+		return
+	}
+	count := 0
+	for _, e := range journalSnapshot(s) {
+		if !e.WasMatched || e.StubMapping == nil {
+			continue
+		}
+		if e.StubMapping.ID == id || e.StubMapping.UUID == id {
+			count++
+		}
+	}
+	data, _ := json.Marshal(map[string]int{"count": count})
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(data)
+}
+
+// writeLoggedRequests responds with the bare requests of events in WireMock's
+// {"requests": [...]} envelope, as used by find and unmatched.
+func writeLoggedRequests(s *types.Server, ctx *fasthttp.RequestCtx, events []types.ServeEvent) {
+	requests := make([]types.LoggedRequest, len(events))
+	for i, e := range events {
+		requests[i] = e.Request
+	}
+	envelope := struct {
+		Requests               []types.LoggedRequest `json:"requests"`
+		RequestJournalDisabled bool                  `json:"requestJournalDisabled"`
+	}{requests, s.JournalSize <= 0}
+
+	data, _ := json.Marshal(envelope)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(data)
+}
+
+// handleRequestsFind serves POST /__admin/requests/find.
+func handleRequestsFind(s *types.Server, ctx *fasthttp.RequestCtx) {
+	criteria, ok := parseCriteria(ctx)
+	if !ok {
+		return
+	}
+	writeLoggedRequests(s, ctx, findServeEvents(s, criteria))
+}
+
+// unmatchedServeEvents returns the journaled requests (newest first) that matched no stub.
+func unmatchedServeEvents(s *types.Server) []types.ServeEvent {
+	unmatched := make([]types.ServeEvent, 0)
+	for _, e := range journalSnapshot(s) {
+		if !e.WasMatched {
+			unmatched = append(unmatched, e)
+		}
+	}
+	return unmatched
+}
+
+// handleNearMisses serves GET /__admin/requests/unmatched/near-misses: the closest stub
+// for each unmatched request, with the same diffs LogMismatch prints.
+func handleNearMisses(s *types.Server, ctx *fasthttp.RequestCtx) {
+	nearMisses := make([]types.NearMiss, 0)
+	for _, e := range unmatchedServeEvents(s) {
+		if e.NearMiss != nil {
+			nearMisses = append(nearMisses, *e.NearMiss)
+		}
+	}
+	data, _ := json.Marshal(map[string][]types.NearMiss{"nearMisses": nearMisses})
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBody(data)
+}
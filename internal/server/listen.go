@@ -0,0 +1,56 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Tuning holds connection settings applied to every mode's fasthttp.Server. Zero
+// values keep fasthttp's defaults.
+type Tuning struct {
+	Concurrency      int           // maximum concurrent connections
+	ReadTimeout      time.Duration // time to read a full request, headers and body
+	WriteTimeout     time.Duration // time to write a full response
+	IdleTimeout      time.Duration // how long a keep-alive connection waits for the next request
+	DisableKeepalive bool          // close the connection after every response
+}
+
+// ApplyTuning copies the set fields of t onto srv.
+func ApplyTuning(srv *fasthttp.Server, t Tuning) {
+	if t.Concurrency > 0 {
+		srv.Concurrency = t.Concurrency
+	}
+	if t.ReadTimeout > 0 {
+		srv.ReadTimeout = t.ReadTimeout
+	}
+	if t.WriteTimeout > 0 {
+		srv.WriteTimeout = t.WriteTimeout
+	}
+	if t.IdleTimeout > 0 {
+		srv.IdleTimeout = t.IdleTimeout
+	}
+	srv.DisableKeepalive = t.DisableKeepalive
+}
+
+// ListenAndServe listens on addr and serves HTTPS when certFile and keyFile are
+// set, or plain HTTP otherwise. All modes start their servers through it.
+func ListenAndServe(srv *fasthttp.Server, addr, certFile, keyFile string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return Serve(srv, ln, certFile, keyFile)
+}
+
+// Serve is ListenAndServe for an existing listener.
+func Serve(srv *fasthttp.Server, ln net.Listener, certFile, keyFile string) error {
+	if certFile != "" {
+		log.Printf("Serving HTTPS on %s with certificate %s", ln.Addr(), certFile)
+		return srv.ServeTLS(ln, certFile, keyFile)
+	}
+	return srv.Serve(ln)
+}
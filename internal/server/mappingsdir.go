@@ -0,0 +1,338 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"goodmock/internal/matching"
+	"goodmock/internal/types"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultWatchInterval is how often WATCH_MAPPINGS polls the mappings directory.
+const DefaultWatchInterval = 500 * time.Millisecond
+
+// mappingFiles returns the .json and .yaml/.yml files under dir, including nested subdirectories,
+// in sorted order so mappings load deterministically.
+func mappingFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			log.Printf("Warning: Could not read %s: %v", path, err)
+			return nil
+		}
+		if !d.IsDir() && (strings.HasSuffix(d.Name(), ".json") || isYAMLFile(d.Name())) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// readMappingsDir parses every mapping file under dir. Unreadable or invalid files are
+// logged and skipped; with logEach, every loaded file is logged as well.
+func readMappingsDir(dir string, logEach bool) ([]types.Mapping, int, error) {
+	paths, err := mappingFiles(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var mappings []types.Mapping
+	files := 0
+	for _, filePath := range paths {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			log.Printf("Warning: Could not read mapping file %s: %v", filePath, err)
+			continue
+		}
+		parse := parseMappings
+		if isYAMLFile(filePath) {
+			parse = parseYAMLMappings
+		}
+		wm, err := parse(data)
+		if err != nil {
+			log.Printf("Warning: Could not parse mapping file %s: %v", filePath, err)
+			continue
+		}
+		if logEach {
+			log.Printf("Loaded %d mappings from %s", len(wm.Mappings), filePath)
+		}
+		for i := range wm.Mappings {
+			wm.Mappings[i].SourceFile = filePath
+		}
+		mappings = append(mappings, wm.Mappings...)
+		files++
+	}
+	return mappings, files, nil
+}
+
+// MappingProblem is an issue ValidateMappingsDir found in a mapping file. Mapping names
+// the offending mapping, or is empty when the problem concerns the whole file.
+type MappingProblem struct {
+	File    string
+	Mapping string
+	Message string
+}
+
+// ValidateMappingsDir checks every mapping file under dir without loading it: files
+// must parse, use only known fields, and hold mappings that pass matching.Validate
+// and aren't shadowed by another mapping in the directory. It returns the problems
+// found and the number of mappings checked.
+func ValidateMappingsDir(dir string) ([]MappingProblem, int, error) {
+	paths, err := mappingFiles(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	var problems []MappingProblem
+	var all []types.Mapping
+	var labels []string
+	checked := 0
+	for _, filePath := range paths {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			problems = append(problems, MappingProblem{File: filePath, Message: err.Error()})
+			continue
+		}
+		decode := decodeMappings
+		if isYAMLFile(filePath) {
+			decode = decodeYAMLMappings
+		}
+		wm, err := decode(data, false)
+		if err != nil {
+			problems = append(problems, MappingProblem{File: filePath, Message: "could not parse: " + err.Error()})
+			continue
+		}
+		if _, err := decode(data, true); err != nil {
+			problems = append(problems, MappingProblem{File: filePath, Message: err.Error()})
+		}
+		for i := range wm.Mappings {
+			checked++
+			label := mappingLabel(&wm.Mappings[i], i)
+			for _, message := range matching.Validate(&wm.Mappings[i]) {
+				problems = append(problems, MappingProblem{File: filePath, Mapping: label, Message: message})
+			}
+			wm.Mappings[i].SourceFile = filePath
+			all = append(all, wm.Mappings[i])
+			labels = append(labels, label)
+		}
+	}
+	for _, sh := range matching.FindShadowed(all) {
+		problems = append(problems, MappingProblem{
+			File:    all[sh.Shadowed].SourceFile,
+			Mapping: labels[sh.Shadowed],
+			Message: fmt.Sprintf("shadowed by %s in %s: both match the same requests", labels[sh.Winner], all[sh.Winner].SourceFile),
+		})
+	}
+	return problems, checked, nil
+}
+
+// mappingLabel identifies a mapping in a validation report by name, then id, then
+// its position in the file and request pattern.
+func mappingLabel(m *types.Mapping, index int) string {
+	if m.Name == "" && m.ID == "" && m.UUID == "" {
+		return fmt.Sprintf("#%d %s", index+1, stubLabel(m))
+	}
+	return stubLabel(m)
+}
+
+// LoadMappingsDir loads every mapping file under dir, adding to the current mappings.
+func LoadMappingsDir(s *types.Server, dir string) {
+	mappings, _, err := readMappingsDir(dir, true)
+	if err != nil {
+		log.Printf("Warning: Could not read mappings directory %s: %v", dir, err)
+		return
+	}
+	LoadMappings(s, types.WiremockMappings{Mappings: mappings})
+}
+
+// ReloadMappingsDir replaces the mappings loaded from files with the contents of dir.
+// Mappings added through the admin API are kept, unless a file now holds a mapping
+// with the same id (e.g. after POST /__admin/mappings/save). The new set is parsed and
+// compiled before being swapped in under the lock, so requests never see a partially
+// loaded directory. Scenario states are kept.
+func ReloadMappingsDir(s *types.Server, dir string) error {
+	return reloadMappingsDir(s, dir, true)
+}
+
+// reloadMappingsDir is ReloadMappingsDir, dropping the runtime mappings unless keepRuntime.
+func reloadMappingsDir(s *types.Server, dir string, keepRuntime bool) error {
+	mappings, files, err := readMappingsDir(dir, false)
+	if err != nil {
+		return err
+	}
+	fileIDs := make(map[string]bool)
+	for i := range mappings {
+		matching.Precompile(&mappings[i])
+		if mappings[i].ID != "" {
+			fileIDs[mappings[i].ID] = true
+		}
+	}
+	if mappings == nil {
+		mappings = make([]types.Mapping, 0)
+	}
+	fromFiles := len(mappings)
+
+	s.Mu.Lock()
+	if keepRuntime {
+		for _, m := range s.Mappings {
+			if m.SourceFile == "" && (m.ID == "" || !fileIDs[m.ID]) {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+	s.Mappings = mappings
+	pruneSequencePositions(s)
+	s.Mu.Unlock()
+	log.Printf("Reloaded %d mappings from %d files in %s", fromFiles, files, dir)
+	for _, warning := range ShadowedMappings(mappings, 0) {
+		log.Printf("Warning: %s", warning)
+	}
+	return nil
+}
+
+// ResetToDefault returns the server to the state it booted with: mappings are reloaded
+// from MappingsDir (or all removed without one), dropping runtime additions including
+// persistent ones, and scenarios, response sequences and the request journal are reset.
+func ResetToDefault(s *types.Server) error {
+	if s.MappingsDir == "" {
+		ResetMappings(s, true)
+	} else if err := reloadMappingsDir(s, s.MappingsDir, false); err != nil {
+		return err
+	}
+	ResetScenarios(s)
+	ClearJournal(s)
+	return nil
+}
+
+// mappingsDirState fingerprints the mapping files under dir by path, size and modification time.
+func mappingsDirState(dir string) (string, error) {
+	paths, err := mappingFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // removed since the walk
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, "|"), nil
+}
+
+// WatchMappingsDir polls dir every interval and reloads the mappings when a mapping file
+// anywhere under it is added, changed or removed. A reload waits until the directory
+// has been unchanged for a full interval, so an editor or script writing several
+// files triggers it once. It runs until stop is closed.
+func WatchMappingsDir(s *types.Server, dir string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	watchMappingsDir(s, dir, ticker.C, stop)
+}
+
+// watchMappingsDir polls dir on every tick; tests drive it with their own ticks.
+func watchMappingsDir(s *types.Server, dir string, ticks <-chan time.Time, stop <-chan struct{}) {
+	last, err := mappingsDirState(dir)
+	if err != nil {
+		log.Printf("Warning: Could not watch mappings directory %s: %v", dir, err)
+	}
+	pending := false
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticks:
+		}
+		current, err := mappingsDirState(dir)
+		if err != nil {
+			continue
+		}
+		if current != last {
+			last = current
+			pending = true
+			continue
+		}
+		if pending {
+			pending = false
+			if err := ReloadMappingsDir(s, dir); err != nil {
+				log.Printf("Warning: Could not reload mappings directory %s: %v", dir, err)
+			}
+		}
+	}
+}
+
+// GenerateMappingName creates a WireMock-style name from a URL path.
+func GenerateMappingName(rawURL string) string {
+	path := rawURL
+	if idx := strings.IndexByte(rawURL, '?'); idx != -1 {
+		path = rawURL[:idx]
+	}
+	name := strings.TrimPrefix(path, "/")
+	name = strings.ReplaceAll(name, "/", "_")
+	name = strings.ReplaceAll(name, "%3A", "")
+	name = strings.ReplaceAll(name, "%3a", "")
+	name = strings.ToLower(name)
+	return name
+}
+
+// unsafeFileNameChars matches characters kept out of saved mapping file names,
+// e.g. regex syntax from a urlPattern.
+var unsafeFileNameChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// SaveMappings writes every mapping not loaded from a file to dir, one file per
+// mapping named after its URL and id, and returns the written paths. Mappings
+// without an id get one, so saving again overwrites the same files. The ids are filled
+// in on a copy of the mappings that is then swapped in, since requests in flight may
+// still be reading the old one.
+func SaveMappings(s *types.Server, dir string) ([]string, error) {
+	s.Mu.Lock()
+	mappings := slices.Clone(s.Mappings)
+	var toSave []types.Mapping
+	for i := range mappings {
+		if mappings[i].SourceFile != "" {
+			continue
+		}
+		ensureMappingID(&mappings[i])
+		toSave = append(toSave, mappings[i])
+	}
+	s.Mappings = mappings
+	s.Mu.Unlock()
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(toSave))
+	for _, m := range toSave {
+		name := m.Name
+		if name == "" {
+			name = GenerateMappingName(getRequestPattern(&m))
+		}
+		name = strings.Trim(unsafeFileNameChars.ReplaceAllString(strings.ToLower(name), "_"), "_.")
+		if name == "" {
+			name = "root"
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return files, err
+		}
+		path := filepath.Join(dir, name+"-"+m.ID+".json")
+		if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+			return files, err
+		}
+		files = append(files, path)
+	}
+	return files, nil
+}
@@ -0,0 +1,75 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"encoding/json"
+	"goodmock/internal/matching"
+	"goodmock/internal/types"
+	"log"
+
+	"github.com/valyala/fasthttp"
+)
+
+// FindMappingsByMetadata returns the mappings whose metadata satisfies the pattern.
+func FindMappingsByMetadata(s *types.Server, pattern types.BodyPattern) []types.Mapping {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+	found := make([]types.Mapping, 0)
+	for _, m := range s.Mappings {
+		if matching.MatchesMetadata(pattern, m.Metadata) {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+// RemoveMappingsByMetadata deletes the mappings whose metadata satisfies the pattern
+// and returns how many were removed.
+func RemoveMappingsByMetadata(s *types.Server, pattern types.BodyPattern) int {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	kept := make([]types.Mapping, 0, len(s.Mappings))
+	for _, m := range s.Mappings {
+		if !matching.MatchesMetadata(pattern, m.Metadata) {
+			kept = append(kept, m)
+		}
+	}
+	removed := len(s.Mappings) - len(kept)
+	s.Mappings = kept
+	pruneSequencePositions(s)
+	return removed
+}
+
+// parseMetadataPattern decodes the matcher posted to the metadata endpoints, e.g.
+// {"matchesJsonPath": "$.team"} or {"equalToJson": {"team": "billing"}}.
+func parseMetadataPattern(ctx *fasthttp.RequestCtx) (types.BodyPattern, bool) {
+	var pattern types.BodyPattern
+	if err := json.Unmarshal(ctx.PostBody(), &pattern); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(err.Error())
+		return pattern, false
+	}
+	matching.Precompile(&types.Mapping{Request: types.Request{BodyPatterns: []types.BodyPattern{pattern}}})
+	return pattern, true
+}
+
+func handleFindByMetadata(s *types.Server, ctx *fasthttp.RequestCtx) {
+	pattern, ok := parseMetadataPattern(ctx)
+	if !ok {
+		return
+	}
+	found := FindMappingsByMetadata(s, pattern)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	data, _ := json.Marshal(map[string]any{"mappings": found, "meta": map[string]int{"total": len(found)}})
+	ctx.SetBody(data)
+}
+
+func handleRemoveByMetadata(s *types.Server, ctx *fasthttp.RequestCtx) {
+	pattern, ok := parseMetadataPattern(ctx)
+	if !ok {
+		return
+	}
+	removed := RemoveMappingsByMetadata(s, pattern)
+	log.Printf("Removed %d mappings by metadata", removed)
+	ctx.SetStatusCode(fasthttp.StatusOK)
+}
@@ -0,0 +1,56 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"fmt"
+	"goodmock/internal/proxy"
+	"goodmock/internal/types"
+	"log"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// proxyToBase forwards the request to baseURL and writes the upstream response back,
+// so individual stubs can pass through to a live backend.
+func proxyToBase(s *types.Server, ctx *fasthttp.RequestCtx, resp *types.Response) {
+	baseURL := resp.ProxyBaseUrl
+	rewriteProxyRequestHeaders(&ctx.Request.Header, resp.AdditionalProxyRequestHeaders, resp.RemoveProxyRequestHeaders)
+
+	status, respHeaders, body, err := proxy.ProxyRequest(s.ProxyClient, strings.TrimSuffix(baseURL, "/"), ctx)
+	if err != nil {
+		log.Printf("Proxy error (%s): %v", baseURL, err)
+		ctx.SetStatusCode(proxy.ErrorStatus(err))
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, "proxy error: "+err.Error()))
+		return
+	}
+
+	// ProxyRequest already decompressed the body, so encoding and length headers no longer apply
+	for key, values := range respHeaders {
+		upperKey := strings.ToUpper(key)
+		if strings.HasPrefix(upperKey, "X-GDC") || upperKey == "DATE" ||
+			upperKey == "CONTENT-ENCODING" || upperKey == "CONTENT-LENGTH" {
+			continue
+		}
+		for _, v := range values {
+			ctx.Response.Header.Add(key, v)
+		}
+	}
+	ctx.SetStatusCode(status)
+	ctx.SetBody(body)
+
+	if s.Verbose {
+		log.Printf("[verbose] << %d %s %s (proxied to %s, %d bytes)", status, string(ctx.Method()), string(ctx.RequestURI()), baseURL, len(body))
+	}
+}
+
+// rewriteProxyRequestHeaders strips and injects headers before a request is forwarded.
+// Removal is case-insensitive and happens first, so a header listed in both is replaced.
+func rewriteProxyRequestHeaders(h *fasthttp.RequestHeader, add map[string]string, remove []string) {
+	for _, name := range remove {
+		h.Del(name)
+	}
+	for name, value := range add {
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,108 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"goodmock/internal/types"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultRequestLogFlushInterval is how often REQUEST_LOG_FILE lines are written out.
+const DefaultRequestLogFlushInterval = time.Second
+
+// RequestLogEntry is one line of the request log file.
+type RequestLogEntry struct {
+	Timestamp        string  `json:"timestamp"`
+	Method           string  `json:"method"`
+	URL              string  `json:"url"`
+	Status           int     `json:"status"`
+	Matched          bool    `json:"matched"`
+	Stub             string  `json:"stub,omitempty"` // label of the stub that served the request
+	DurationMs       float64 `json:"durationMs"`
+	RequestBodySize  int     `json:"requestBodySize"`
+	ResponseBodySize int     `json:"responseBodySize"`
+}
+
+// RequestLogFile appends lines to a file through a buffer that is flushed on an
+// interval, so requests don't each pay for a write to disk. It is safe for
+// concurrent use.
+type RequestLogFile struct {
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+	stop chan struct{}
+}
+
+// OpenRequestLog opens path for appending, creating it if needed, and starts
+// flushing it every flushInterval until Close.
+func OpenRequestLog(path string, flushInterval time.Duration) (*RequestLogFile, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &RequestLogFile{file: file, buf: bufio.NewWriter(file), stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Flush(); err != nil {
+					log.Printf("Warning: could not write request log %s: %v", path, err)
+				}
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// Write buffers p; whole lines should be written in a single call.
+func (l *RequestLogFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+// Flush writes buffered lines to the file.
+func (l *RequestLogFile) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Flush()
+}
+
+// Close stops the periodic flush, writes what is buffered and closes the file.
+func (l *RequestLogFile) Close() error {
+	close(l.stop)
+	if err := l.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// logRequest appends the handled request to s.RequestLog as one JSON line.
+func logRequest(s *types.Server, ctx *fasthttp.RequestCtx, method, rawURI string, started time.Time, result *types.MatchResult) {
+	entry := RequestLogEntry{
+		Timestamp:        started.UTC().Format(time.RFC3339Nano),
+		Method:           method,
+		URL:              rawURI,
+		Status:           ctx.Response.StatusCode(),
+		Matched:          result.Matched,
+		DurationMs:       float64(time.Since(started).Microseconds()) / 1000,
+		RequestBodySize:  len(ctx.Request.Body()),
+		ResponseBodySize: len(ctx.Response.Body()),
+	}
+	if result.Matched && result.Mapping != nil {
+		entry.Stub = stubLabel(result.Mapping)
+	}
+	line, _ := json.Marshal(entry)
+	s.RequestLog.Write(append(line, '\n'))
+}
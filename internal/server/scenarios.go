@@ -0,0 +1,115 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"encoding/json"
+	"goodmock/internal/types"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// listScenarios returns every scenario referenced by the mappings with its current
+// state and the states its mappings mention, sorted by name.
+func listScenarios(s *types.Server) []types.Scenario {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+
+	states := make(map[string]map[string]bool)
+	for i := range s.Mappings {
+		m := &s.Mappings[i]
+		if m.ScenarioName == "" {
+			continue
+		}
+		if states[m.ScenarioName] == nil {
+			states[m.ScenarioName] = map[string]bool{types.ScenarioStarted: true}
+		}
+		for _, state := range []string{m.RequiredScenarioState, m.NewScenarioState} {
+			if state != "" {
+				states[m.ScenarioName][state] = true
+			}
+		}
+	}
+
+	scenarios := make([]types.Scenario, 0, len(states))
+	for name, possible := range states {
+		current, ok := s.Scenarios[name]
+		if !ok {
+			current = types.ScenarioStarted
+		}
+		sc := types.Scenario{ID: name, Name: name, State: current}
+		for state := range possible {
+			sc.PossibleStates = append(sc.PossibleStates, state)
+		}
+		sort.Strings(sc.PossibleStates)
+		scenarios = append(scenarios, sc)
+	}
+	sort.Slice(scenarios, func(i, j int) bool { return scenarios[i].Name < scenarios[j].Name })
+	return scenarios
+}
+
+// SetScenarioState forces a scenario into the given state. It fails if no mapping
+// uses the scenario or the state isn't one of its possible states.
+func SetScenarioState(s *types.Server, name, state string) (found, valid bool) {
+	for _, sc := range listScenarios(s) {
+		if sc.Name != name {
+			continue
+		}
+		for _, possible := range sc.PossibleStates {
+			if possible == state {
+				s.Mu.Lock()
+				s.Scenarios[name] = state
+				s.Mu.Unlock()
+				return true, true
+			}
+		}
+		return true, false
+	}
+	return false, false
+}
+
+func handleScenarios(s *types.Server, ctx *fasthttp.RequestCtx) {
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	data, _ := json.Marshal(map[string][]types.Scenario{"scenarios": listScenarios(s)})
+	ctx.SetBody(data)
+}
+
+// handleScenarioState serves PUT /__admin/scenarios/{name}/state. An empty body or
+// state resets the scenario to Started.
+func handleScenarioState(s *types.Server, ctx *fasthttp.RequestCtx, rawName string) {
+	name, err := url.PathUnescape(rawName)
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(err.Error())
+		return
+	}
+	var req struct {
+		State string `json:"state"`
+	}
+	if body := ctx.PostBody(); len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(err.Error())
+			return
+		}
+	}
+	if req.State == "" {
+		req.State = types.ScenarioStarted
+	}
+
+	found, valid := SetScenarioState(s, name, req.State)
+	switch {
+	case !found:
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBodyString(`{"error": "scenario not found"}`)
+	case !valid:
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error": "state is not a possible state of the scenario"}`)
+	default:
+		log.Printf("Scenario %q forced to state %q", name, req.State)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	}
+}
@@ -2,14 +2,26 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goodmock/internal/logging"
 	"goodmock/internal/matching"
+	"goodmock/internal/proxy"
+	"goodmock/internal/templating"
 	"goodmock/internal/types"
 	"log"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -18,31 +30,240 @@ import (
 func NewServer(proxyHost, refererPath string, verbose bool, binaryContentTypes []string) *types.Server {
 	return &types.Server{
 		Mappings:           make([]types.Mapping, 0),
+		Scenarios:          make(map[string]string),
+		SequencePositions:  make(map[*types.Response]int),
 		ProxyHost:          proxyHost,
 		RefererPath:        refererPath,
 		Verbose:            verbose,
 		BinaryContentTypes: binaryContentTypes,
+		Rand:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		JournalSize:        DefaultJournalSize,
+		FilesDir:           DefaultFilesDir,
+		ProxyClient:        proxy.NewClient(proxy.Config{}),
 	}
 }
 
+// SeedRandom reseeds the server's random source so random delays are reproducible.
+func SeedRandom(s *types.Server, seed int64) {
+	s.RandMu.Lock()
+	s.Rand = rand.New(rand.NewSource(seed))
+	s.RandMu.Unlock()
+}
+
 func LoadMappings(s *types.Server, wm types.WiremockMappings) {
+	for i := range wm.Mappings {
+		matching.Precompile(&wm.Mappings[i])
+	}
 	s.Mu.Lock()
+	loaded := len(s.Mappings)
 	s.Mappings = append(s.Mappings, wm.Mappings...)
+	shadowed := ShadowedMappings(s.Mappings, loaded)
 	s.Mu.Unlock()
+	for _, warning := range shadowed {
+		log.Printf("Warning: %s", warning)
+	}
+}
+
+// ShadowedMappings describes each mapping that can never be served because another
+// one matches exactly the same requests and always wins. Only pairs involving a
+// mapping at index from or later are reported, so a load warns about what it added.
+func ShadowedMappings(mappings []types.Mapping, from int) []string {
+	var warnings []string
+	for _, sh := range matching.FindShadowed(mappings) {
+		if sh.Winner < from && sh.Shadowed < from {
+			continue
+		}
+		winner, shadowed := &mappings[sh.Winner], &mappings[sh.Shadowed]
+		warnings = append(warnings, fmt.Sprintf("mapping %s shadows %s: both match %s %s with the same matchers",
+			shadowLabel(winner, sh.Winner), shadowLabel(shadowed, sh.Shadowed), shadowed.Request.Method, getRequestPattern(shadowed)))
+	}
+	return warnings
+}
+
+// shadowLabel names a mapping in a shadowing warning, with the file it came from.
+func shadowLabel(m *types.Mapping, index int) string {
+	label := fmt.Sprintf("%q", mappingLabel(m, index))
+	if m.SourceFile != "" {
+		label += " (" + m.SourceFile + ")"
+	}
+	return label
+}
+
+// parseMappings decodes either the {"mappings": [...]} envelope or a single top-level
+// mapping, WireMock's usual one-stub-per-file format.
+func parseMappings(data []byte) (types.WiremockMappings, error) {
+	return decodeMappings(data, false)
+}
+
+// decodeMappings is parseMappings, optionally rejecting fields GoodMock doesn't know.
+func decodeMappings(data []byte, strict bool) (types.WiremockMappings, error) {
+	unmarshal := func(v any) error {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		return dec.Decode(v)
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return types.WiremockMappings{}, err
+	}
+	if _, ok := probe["mappings"]; !ok {
+		if _, single := probe["request"]; single {
+			var m types.Mapping
+			if err := unmarshal(&m); err != nil {
+				return types.WiremockMappings{}, err
+			}
+			return types.WiremockMappings{Mappings: []types.Mapping{m}}, nil
+		}
+	}
+	var wm types.WiremockMappings
+	err := unmarshal(&wm)
+	return wm, err
 }
 
 func addMapping(s *types.Server, m types.Mapping) {
+	matching.Precompile(&m)
 	s.Mu.Lock()
 	s.Mappings = append(s.Mappings, m)
 	s.Mu.Unlock()
 }
 
+// ClearMappings removes every mapping, persistent ones included, and resets scenarios.
 func ClearMappings(s *types.Server) {
+	ResetMappings(s, true)
+}
+
+// ResetMappings removes all mappings except those marked persistent, unless
+// includePersistent is set, and resets scenarios.
+func ResetMappings(s *types.Server, includePersistent bool) {
+	s.Mu.Lock()
+	kept := make([]types.Mapping, 0)
+	if !includePersistent {
+		for _, m := range s.Mappings {
+			if m.Persistent {
+				kept = append(kept, m)
+			}
+		}
+	}
+	s.Mappings = kept
+	s.Scenarios = make(map[string]string)
+	s.SequencePositions = make(map[*types.Response]int)
+	s.Mu.Unlock()
+}
+
+// IncludePersistent reports whether a bulk reset should also remove persistent
+// mappings, requested with ?includePersistent=true.
+func IncludePersistent(ctx *fasthttp.RequestCtx) bool {
+	return string(ctx.QueryArgs().Peek("includePersistent")) == "true"
+}
+
+// ResetScenarios returns every scenario to the Started state and restarts response sequences.
+func ResetScenarios(s *types.Server) {
 	s.Mu.Lock()
-	s.Mappings = make([]types.Mapping, 0)
+	s.Scenarios = make(map[string]string)
+	s.SequencePositions = make(map[*types.Response]int)
 	s.Mu.Unlock()
 }
 
+// advanceScenario moves the mapping's scenario to its NewScenarioState after a match.
+// Matching only holds the read lock, so the required state is checked again under the
+// write lock. It reports false if another request moved the scenario on in between;
+// the match is then stale and the request has to be matched again.
+func advanceScenario(s *types.Server, m *types.Mapping) bool {
+	if m.ScenarioName == "" || m.NewScenarioState == "" {
+		return true
+	}
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	current, ok := s.Scenarios[m.ScenarioName]
+	if !ok {
+		current = types.ScenarioStarted
+	}
+	if m.RequiredScenarioState != "" && m.RequiredScenarioState != current {
+		return false
+	}
+	s.Scenarios[m.ScenarioName] = m.NewScenarioState
+	if s.Verbose {
+		log.Printf("[verbose] Scenario %q: %s -> %s", m.ScenarioName, current, m.NewScenarioState)
+	}
+	return true
+}
+
+// nextResponse returns the response to serve for a match: the mapping's own response,
+// or the next entry of its Response.Sequence. The last entry repeats once the sequence
+// is used up. Positions are keyed by the sequence's first entry, which stays put when
+// the mappings slice grows, and start over when the mappings or scenarios are reset.
+func nextResponse(s *types.Server, m *types.Mapping) *types.Response {
+	sequence := m.Response.Sequence
+	if len(sequence) == 0 {
+		return weightedResponse(s, &m.Response)
+	}
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+
+	if s.SequencePositions == nil {
+		s.SequencePositions = make(map[*types.Response]int)
+	}
+	i := s.SequencePositions[&sequence[0]]
+	if i < len(sequence)-1 {
+		s.SequencePositions[&sequence[0]] = i + 1
+	}
+	return weightedResponse(s, &sequence[i])
+}
+
+// pruneSequencePositions forgets the positions of sequences that are no longer in
+// s.Mappings, so replaced and removed stubs aren't kept alive by their keys. The caller
+// holds s.Mu for writing.
+func pruneSequencePositions(s *types.Server) {
+	if len(s.SequencePositions) == 0 {
+		return
+	}
+	live := make(map[*types.Response]bool)
+	for i := range s.Mappings {
+		if sequence := s.Mappings[i].Response.Sequence; len(sequence) > 0 {
+			live[&sequence[0]] = true
+		}
+	}
+	maps.DeleteFunc(s.SequencePositions, func(first *types.Response, _ int) bool {
+		return !live[first]
+	})
+}
+
+// weightedResponse picks one of resp.WeightedResponses at random, in proportion to the
+// entries' weights, using the server's seedable random source. A response without
+// weighted entries is returned as is; if every weight is zero the first entry is served.
+func weightedResponse(s *types.Server, resp *types.Response) *types.Response {
+	choices := resp.WeightedResponses
+	if len(choices) == 0 {
+		return resp
+	}
+	weights := make([]float64, len(choices))
+	total := 0.0
+	for i := range choices {
+		weights[i] = 1
+		if w := choices[i].Weight; w != nil {
+			weights[i] = max(*w, 0)
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return &choices[0]
+	}
+
+	s.RandMu.Lock()
+	r := s.Rand.Float64() * total
+	s.RandMu.Unlock()
+	for i, w := range weights {
+		if r < w {
+			return &choices[i]
+		}
+		r -= w
+	}
+	return &choices[len(choices)-1]
+}
+
 // TransformRequestHeaders rewrites incoming request headers to match recorded stubs.
 func TransformRequestHeaders(h *fasthttp.RequestHeader, proxyHost, refererPath string) {
 	if proxyHost != "" {
@@ -52,13 +273,17 @@ func TransformRequestHeaders(h *fasthttp.RequestHeader, proxyHost, refererPath s
 	h.Set("Accept-Encoding", "gzip")
 }
 
-// applyResponseHeaders writes response headers to the context, filtering internal ones.
-func applyResponseHeaders(ctx *fasthttp.RequestCtx, headers map[string]any) {
+// applyResponseHeaders writes response headers to the context, filtering internal ones
+// and those listed in remove (compared case-insensitively).
+func applyResponseHeaders(ctx *fasthttp.RequestCtx, headers map[string]any, remove []string) {
 	for key, value := range headers {
 		upperKey := strings.ToUpper(key)
 		if strings.HasPrefix(upperKey, "X-GDC") || upperKey == "DATE" {
 			continue
 		}
+		if slices.ContainsFunc(remove, func(name string) bool { return strings.EqualFold(name, key) }) {
+			continue
+		}
 
 		switch v := value.(type) {
 		case []interface{}:
@@ -73,6 +298,143 @@ func applyResponseHeaders(ctx *fasthttp.RequestCtx, headers map[string]any) {
 	}
 }
 
+// responseDelay returns how long to wait before serving a response: the fixed
+// delay plus a sample from the delay distribution, if any, for both the stub and
+// the global settings.
+func responseDelay(s *types.Server, resp *types.Response) time.Duration {
+	s.Mu.RLock()
+	global := s.Settings
+	s.Mu.RUnlock()
+
+	delay := time.Duration(resp.FixedDelayMilliseconds+global.FixedDelay) * time.Millisecond
+	if resp.DelayDistribution != nil {
+		delay += sampleDelay(s, resp.DelayDistribution)
+	}
+	if global.DelayDistribution != nil {
+		delay += sampleDelay(s, global.DelayDistribution)
+	}
+	return delay
+}
+
+// dribbleBody replaces the response body with a stream that writes it in
+// d.NumberOfChunks pieces, flushing each and pausing between them so the whole body
+// takes d.TotalDurationMs to arrive. The response is sent with chunked encoding.
+func dribbleBody(ctx *fasthttp.RequestCtx, d *types.ChunkedDribbleDelay) {
+	body := append([]byte(nil), ctx.Response.Body()...)
+	chunks := min(max(d.NumberOfChunks, 1), max(len(body), 1))
+	interval := time.Duration(d.TotalDurationMs) * time.Millisecond / time.Duration(chunks)
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		for i := range chunks {
+			if i > 0 {
+				time.Sleep(interval)
+			}
+			w.Write(body[i*len(body)/chunks : (i+1)*len(body)/chunks])
+			if err := w.Flush(); err != nil {
+				return // client went away
+			}
+		}
+	})
+}
+
+// sampleDelay draws a delay from a WireMock-style distribution.
+func sampleDelay(s *types.Server, d *types.DelayDistribution) time.Duration {
+	s.RandMu.Lock()
+	defer s.RandMu.Unlock()
+
+	var ms float64
+	switch d.Type {
+	case "uniform":
+		if d.Upper < d.Lower {
+			return 0
+		}
+		ms = float64(d.Lower + s.Rand.Intn(d.Upper-d.Lower+1))
+	case "lognormal":
+		ms = d.Median * math.Exp(s.Rand.NormFloat64()*d.Sigma)
+		if d.MaxValue > 0 && ms > d.MaxValue {
+			ms = d.MaxValue
+		}
+	default:
+		log.Printf("Warning: unknown delayDistribution type %q", d.Type)
+		return 0
+	}
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// newTemplateData collects the request values exposed to response templates.
+func newTemplateData(s *types.Server, ctx *fasthttp.RequestCtx, method, path, rawURI string, result *types.MatchResult) *templating.RequestData {
+	data := &templating.RequestData{
+		Now: time.Now(),
+		Random: func(n int) int {
+			s.RandMu.Lock()
+			defer s.RandMu.Unlock()
+			return s.Rand.Intn(n)
+		},
+		Method:        method,
+		URL:           rawURI,
+		Path:          path,
+		PathVariables: result.PathVariables,
+		URLGroups:     result.URLGroups,
+		Query:         make(map[string][]string),
+		Headers:       make(map[string][]string),
+		Body:          string(ctx.PostBody()),
+	}
+	if trimmed := strings.Trim(path, "/"); trimmed != "" {
+		data.PathSegments = strings.Split(trimmed, "/")
+	}
+	ctx.QueryArgs().VisitAll(func(key, value []byte) {
+		data.Query[string(key)] = append(data.Query[string(key)], string(value))
+	})
+	ctx.Request.Header.VisitAll(func(key, value []byte) {
+		name := strings.ToLower(string(key))
+		data.Headers[name] = append(data.Headers[name], string(value))
+	})
+	return data
+}
+
+// responseTemplateTransformer is the transformer name that enables response templating.
+const responseTemplateTransformer = "response-template"
+
+// responseTemplating reports whether a response opts into templating.
+func responseTemplating(resp *types.Response) bool {
+	return slices.Contains(resp.Transformers, responseTemplateTransformer)
+}
+
+// renderHeaders returns a copy of the response headers with templates in their values rendered.
+func renderHeaders(headers map[string]any, data *templating.RequestData) map[string]any {
+	if len(headers) == 0 {
+		return headers
+	}
+	rendered := make(map[string]any, len(headers))
+	for key, value := range headers {
+		rendered[key] = templating.RenderJSON(value, data)
+	}
+	return rendered
+}
+
+// HandleServerError answers requests fasthttp rejects before they reach a handler.
+// A body over the limit the caller set as fasthttp.Server.MaxRequestBodySize (from
+// MAX_REQUEST_BODY_BYTES) gets a 413 with a JSON error, like HandleRequest gives a
+// body that exceeds the limit once decompressed.
+func HandleServerError(ctx *fasthttp.RequestCtx, err error) {
+	if errors.Is(err, fasthttp.ErrBodyTooLarge) {
+		RequestTooLarge(ctx, 0)
+		return
+	}
+	ctx.SetStatusCode(fasthttp.StatusBadRequest)
+	ctx.SetBodyString(err.Error())
+}
+
+// RequestTooLarge writes a 413 with a JSON error, naming the limit when it is known.
+func RequestTooLarge(ctx *fasthttp.RequestCtx, limit int) {
+	message := "request body too large"
+	if limit > 0 {
+		message = fmt.Sprintf("request body exceeds %d bytes", limit)
+	}
+	ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, message))
+}
+
 // HandleRequest handles incoming HTTP requests
 func HandleRequest(s *types.Server, ctx *fasthttp.RequestCtx) {
 	rawURI := string(ctx.RequestURI())
@@ -87,52 +449,241 @@ func HandleRequest(s *types.Server, ctx *fasthttp.RequestCtx) {
 		return
 	}
 
+	var result types.MatchResult
+	if s.RequestLog != nil {
+		started := time.Now()
+		defer func() { logRequest(s, ctx, method, rawURI, started, &result) }()
+	}
+
+	// Check the body as sent before decoding it, then decode through a reader capped at
+	// the same limit so a small compression bomb is rejected without being expanded
+	tooLarge := s.MaxRequestBodySize > 0 && len(ctx.Request.Body()) > s.MaxRequestBodySize
+	if !tooLarge {
+		tooLarge = DecompressRequestBody(&ctx.Request, s.MaxRequestBodySize) != nil
+	}
+	if tooLarge {
+		log.Printf("Rejected %s %s: request body over %d bytes", method, rawURI, s.MaxRequestBodySize)
+		RequestTooLarge(ctx, s.MaxRequestBodySize)
+		return
+	}
 	if s.Verbose {
-		LogVerboseRequest(ctx, method, rawURI)
+		LogVerboseRequest(s, ctx, method, rawURI)
 	}
 
+	// ServeStub pins Accept-Encoding for matching; keep the client's for a default response
+	acceptEncoding := string(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding))
+	result = ServeStub(s, ctx)
+	if s.Diagnostics {
+		defer setDiagnosticHeaders(ctx, &result)
+	}
+	if !result.Matched {
+		body := logging.DescribeBody(string(ctx.Request.Header.ContentType()), ctx.Request.Body())
+		if s.JSONLogs {
+			logging.LogMismatchJSON(method, rawURI, body, result)
+		} else {
+			logging.LogMismatch(method, rawURI, body, result)
+		}
+		if s.DefaultResponse != nil {
+			serveDefault(s, ctx, method, rawURI, acceptEncoding)
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.SetBodyString(`{"error": "No matching stub found"}`)
+	}
+}
+
+// setDiagnosticHeaders names the stub that served the request and its match score,
+// or the closest stub when none matched, so test clients can assert on routing
+// without querying the request journal.
+func setDiagnosticHeaders(ctx *fasthttp.RequestCtx, result *types.MatchResult) {
+	if result.Mapping == nil {
+		return
+	}
+	if result.Matched {
+		ctx.Response.Header.Set("X-GoodMock-Matched-Stub", stubLabel(result.Mapping))
+		ctx.Response.Header.Set("X-GoodMock-Match-Score", strconv.Itoa(result.Score))
+	} else {
+		ctx.Response.Header.Set("X-GoodMock-Closest-Stub", stubLabel(result.Mapping))
+	}
+}
+
+// stubLabel identifies a mapping by name, then id or uuid, then method and URL.
+func stubLabel(m *types.Mapping) string {
+	switch {
+	case m.Name != "":
+		return m.Name
+	case m.ID != "":
+		return m.ID
+	case m.UUID != "":
+		return m.UUID
+	}
+	return m.Request.Method + " " + getRequestPattern(m)
+}
+
+// serveDefault answers an unmatched request with the configured default response,
+// through the same path as a stub so delays, templating and files apply.
+func serveDefault(s *types.Server, ctx *fasthttp.RequestCtx, method, rawURI, acceptEncoding string) {
+	path := rawURI
+	if idx := strings.IndexByte(rawURI, '?'); idx != -1 {
+		path = rawURI[:idx]
+	}
+	result := types.MatchResult{Matched: true, Mapping: &types.Mapping{Name: "default response", Response: *s.DefaultResponse}}
+	serveMatch(s, ctx, &result, method, path, rawURI, acceptEncoding)
+}
+
+// ServeStub rewrites the request headers, matches the request against the loaded
+// stubs and serves the best match. If nothing matches, ctx is left untouched apart
+// from the header rewriting, and the result describes the closest stub.
+func ServeStub(s *types.Server, ctx *fasthttp.RequestCtx) types.MatchResult {
+	rawURI := string(ctx.RequestURI())
+	path := rawURI
+	if idx := strings.IndexByte(rawURI, '?'); idx != -1 {
+		path = rawURI[:idx]
+	}
+	method := string(ctx.Method())
+
+	var logged types.LoggedRequest
+	if s.JournalSize > 0 {
+		logged = newLoggedRequest(ctx, method, rawURI)
+	}
+
+	// The transform pins Accept-Encoding for matching; response compression follows the client's own
+	acceptEncoding := string(ctx.Request.Header.Peek(fasthttp.HeaderAcceptEncoding))
 	TransformRequestHeaders(&ctx.Request.Header, s.ProxyHost, s.RefererPath)
 
 	body := ctx.PostBody()
 	fullURI := rawURI
 
 	result := matching.MatchRequest(s, method, path, fullURI, ctx.QueryArgs(), body, &ctx.Request.Header)
+	// Two requests can match the same scenario step; only the first one to advance it keeps its match
+	for result.Matched && !advanceScenario(s, result.Mapping) {
+		result = matching.MatchRequest(s, method, path, fullURI, ctx.QueryArgs(), body, &ctx.Request.Header)
+	}
+	recordServeEvent(s, logged, &result)
+	if result.Matched {
+		serveMatch(s, ctx, &result, method, path, rawURI, acceptEncoding)
+	}
+	return result
+}
 
-	if !result.Matched {
-		logging.LogMismatch(method, fullURI, result)
-		ctx.SetStatusCode(fasthttp.StatusNotFound)
-		ctx.SetBodyString(`{"error": "No matching stub found"}`)
+// serveMatch writes the response of a matched stub.
+func serveMatch(s *types.Server, ctx *fasthttp.RequestCtx, result *types.MatchResult, method, path, rawURI, acceptEncoding string) {
+	m := result.Mapping
+	resp := nextResponse(s, m)
+
+	// Simulate a slow backend. Matching has released the server lock, so concurrent requests delay independently.
+	if delay := responseDelay(s, resp); delay > 0 {
+		time.Sleep(delay)
+	}
+
+	// A fault replaces the whole response, status and body included
+	if resp.Fault != "" && applyFault(ctx, resp.Fault) {
+		if s.Verbose {
+			log.Printf("[verbose] << fault %s %s", resp.Fault, method+" "+rawURI)
+		}
 		return
 	}
 
-	m := result.Mapping
-	applyResponseHeaders(ctx, m.Response.Headers)
+	if resp.ProxyBaseUrl != "" {
+		proxyToBase(s, ctx, resp)
+		return
+	}
 
-	ctx.SetStatusCode(m.Response.Status)
-	if m.Response.JsonBody != nil {
-		data, err := json.Marshal(m.Response.JsonBody)
-		if err == nil {
-			ctx.SetBody(data)
+	// Without the response-template transformer, {{...}} in the stub is served as-is
+	var tmplData *templating.RequestData
+	headers := resp.Headers
+	fileName := resp.BodyFileName
+	if responseTemplating(resp) {
+		tmplData = newTemplateData(s, ctx, method, path, rawURI, result)
+		tmplData.Parameters = resp.TransformerParameters
+		headers = renderHeaders(resp.Headers, tmplData)
+		fileName = templating.Render(fileName, tmplData)
+	}
+
+	// Raw bodies are resolved up front so a bad file or encoding fails cleanly with a 500
+	raw, isRaw, err := rawBody(s, resp, fileName)
+	if err != nil {
+		log.Printf("Error serving %s %s: %v", method, rawURI, err)
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+	applyResponseHeaders(ctx, headers, resp.RemoveHeaders)
+
+	ctx.SetStatusCode(resp.Status)
+	if resp.StatusMessage != "" {
+		ctx.Response.Header.SetStatusMessage([]byte(resp.StatusMessage))
+	}
+	if isRaw {
+		if resp.Base64Body == "" && headerValue(resp.Headers, "Content-Type") == "" {
+			if ct := contentTypeForFile(fileName); ct != "" {
+				ctx.Response.Header.SetContentType(ct)
+			}
 		}
-	} else if m.Response.Body != "" {
-		if isBinaryResponse(m.Response.Headers, s.BinaryContentTypes) {
-			decoded, err := base64.StdEncoding.DecodeString(m.Response.Body)
+		ctx.SetBody(raw)
+	} else if resp.Body != "" {
+		if isBinaryResponse(resp.Headers, s.BinaryContentTypes) {
+			decoded, err := base64.StdEncoding.DecodeString(resp.Body)
 			if err == nil {
 				ctx.SetBody(decoded)
 			} else {
-				ctx.SetBodyString(m.Response.Body)
+				ctx.SetBodyString(resp.Body)
 			}
+		} else if tmplData != nil {
+			ctx.SetBodyString(templating.Render(resp.Body, tmplData))
 		} else {
-			ctx.SetBodyString(m.Response.Body)
+			ctx.SetBodyString(resp.Body)
+		}
+	} else if resp.JsonBody != nil {
+		body := resp.JsonBody
+		if tmplData != nil {
+			body = templating.RenderJSON(body, tmplData)
+		}
+		data, err := json.Marshal(body)
+		if err == nil {
+			ctx.SetBody(data)
 		}
 	}
+	gzipResponse(s, ctx, resp, acceptEncoding)
+	if resp.ChunkedDribbleDelay != nil {
+		dribbleBody(ctx, resp.ChunkedDribbleDelay)
+	}
 
 	if s.Verbose {
-		log.Printf("[verbose] << %d %s", m.Response.Status, method+" "+rawURI)
+		log.Printf("[verbose] << %d %s", resp.Status, method+" "+rawURI)
+	}
+}
+
+// AdminAuthorized reports whether an admin request may proceed and responds with 401
+// when it may not. With an AdminToken set, the request must carry it in X-Admin-Token
+// or the Authorization header, bare or as a Bearer token. Health checks stay open so
+// probes don't need the token.
+func AdminAuthorized(s *types.Server, ctx *fasthttp.RequestCtx, path string) bool {
+	if s.AdminToken == "" || path == "/__admin" || path == "/__admin/health" {
+		return true
+	}
+	token := string(ctx.Request.Header.Peek("X-Admin-Token"))
+	if token == "" {
+		token = string(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+		if scheme, rest, ok := strings.Cut(token, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			token = strings.TrimSpace(rest)
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) == 1 {
+		return true
 	}
+	ctx.SetStatusCode(fasthttp.StatusUnauthorized)
+	ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, "Bearer")
+	ctx.SetContentType("application/json")
+	ctx.SetBodyString(`{"error": "admin token required"}`)
+	return false
 }
 
 func HandleAdmin(s *types.Server, ctx *fasthttp.RequestCtx, path, method string) {
+	if !AdminAuthorized(s, ctx, path) {
+		return
+	}
+
 	if path == "/__admin" && method == "GET" {
 		ctx.SetStatusCode(fasthttp.StatusOK)
 		ctx.SetBodyString(`{"status":"ok"}`)
@@ -146,18 +697,44 @@ func HandleAdmin(s *types.Server, ctx *fasthttp.RequestCtx, path, method string)
 	}
 
 	if path == "/__admin/reset" && method == "POST" {
-		ClearMappings(s)
+		ResetMappings(s, IncludePersistent(ctx))
+		ClearJournal(s)
 		log.Println("All mappings reset")
 		ctx.SetStatusCode(fasthttp.StatusOK)
 		return
 	}
 
-	if path == "/__admin/settings" && method == "POST" {
+	if path == "/__admin/reset-to-default" && method == "POST" {
+		if err := ResetToDefault(s); err != nil {
+			log.Printf("Error reloading mappings from %s: %v", s.MappingsDir, err)
+			ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+			ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+			return
+		}
+		log.Println("Mappings reset to startup state")
 		ctx.SetStatusCode(fasthttp.StatusOK)
 		return
 	}
 
+	if path == "/__admin/settings" {
+		handleSettings(s, ctx, method)
+		return
+	}
+
+	if path == "/__admin/scenarios" && method == "GET" {
+		handleScenarios(s, ctx)
+		return
+	}
+
+	if rest, ok := strings.CutPrefix(path, "/__admin/scenarios/"); ok && method == "PUT" {
+		if name, ok := strings.CutSuffix(rest, "/state"); ok && name != "" && !strings.Contains(name, "/") {
+			handleScenarioState(s, ctx, name)
+			return
+		}
+	}
+
 	if path == "/__admin/scenarios/reset" && method == "POST" {
+		ResetScenarios(s)
 		ctx.SetStatusCode(fasthttp.StatusOK)
 		ctx.SetBodyString(`{}`)
 		return
@@ -169,8 +746,12 @@ func HandleAdmin(s *types.Server, ctx *fasthttp.RequestCtx, path, method string)
 	}
 
 	if path == "/__admin/mappings/import" && method == "POST" {
-		var wm types.WiremockMappings
-		if err := json.Unmarshal(ctx.PostBody(), &wm); err != nil {
+		parse := parseMappings
+		if isYAMLContentType(string(ctx.Request.Header.ContentType())) {
+			parse = parseYAMLMappings
+		}
+		wm, err := parse(ctx.PostBody())
+		if err != nil {
 			ctx.SetStatusCode(fasthttp.StatusBadRequest)
 			ctx.SetBodyString(err.Error())
 			return
@@ -182,14 +763,63 @@ func HandleAdmin(s *types.Server, ctx *fasthttp.RequestCtx, path, method string)
 		return
 	}
 
+	if path == "/__admin/mappings/find-by-metadata" && method == "POST" {
+		handleFindByMetadata(s, ctx)
+		return
+	}
+
+	// WireMock removes by metadata with POST; DELETE is accepted as well
+	if (path == "/__admin/mappings/remove-by-metadata" && method == "POST") ||
+		(path == "/__admin/mappings/find-by-metadata" && method == "DELETE") {
+		handleRemoveByMetadata(s, ctx)
+		return
+	}
+
+	if path == "/__admin/mappings/save" && method == "POST" {
+		handleSaveMappings(s, ctx)
+		return
+	}
+
 	if path == "/__admin/mappings/reset" && method == "POST" {
-		ClearMappings(s)
+		ResetMappings(s, IncludePersistent(ctx))
 		ctx.SetStatusCode(fasthttp.StatusOK)
 		return
 	}
 
-	if path == "/__admin/requests" && method == "DELETE" {
-		ctx.SetStatusCode(fasthttp.StatusOK)
+	if rest, ok := strings.CutPrefix(path, "/__admin/mappings/"); ok && method == "GET" {
+		if id, ok := strings.CutSuffix(rest, "/requests/count"); ok && id != "" && !strings.Contains(id, "/") {
+			handleMappingRequestsCount(s, ctx, id)
+			return
+		}
+	}
+
+	if id, ok := strings.CutPrefix(path, "/__admin/mappings/"); ok && id != "" && !strings.Contains(id, "/") {
+		handleMapping(s, ctx, method, id)
+		return
+	}
+
+	if path == "/__admin/requests" {
+		handleRequests(s, ctx, method)
+		return
+	}
+
+	if path == "/__admin/requests/count" && method == "POST" {
+		handleRequestsCount(s, ctx)
+		return
+	}
+
+	if path == "/__admin/requests/find" && method == "POST" {
+		handleRequestsFind(s, ctx)
+		return
+	}
+
+	if path == "/__admin/requests/unmatched" && method == "GET" {
+		writeLoggedRequests(s, ctx, unmatchedServeEvents(s))
+		return
+	}
+
+	if path == "/__admin/requests/unmatched/near-misses" && method == "GET" {
+		handleNearMisses(s, ctx)
 		return
 	}
 
@@ -204,6 +834,181 @@ func HandleAdmin(s *types.Server, ctx *fasthttp.RequestCtx, path, method string)
 	ctx.SetStatusCode(fasthttp.StatusNotFound)
 }
 
+// ensureMappingID gives a mapping an id so it can be addressed via /__admin/mappings/{id}.
+// WireMock treats id and uuid as the same value; a missing one is filled from the other.
+func ensureMappingID(m *types.Mapping) {
+	if m.ID == "" {
+		m.ID = m.UUID
+	}
+	if m.ID == "" {
+		m.ID = newUUID()
+	}
+	if m.UUID == "" {
+		m.UUID = m.ID
+	}
+}
+
+// findMapping returns the index of the mapping with the given id or uuid, or -1.
+// The caller must hold s.Mu.
+func findMapping(s *types.Server, id string) int {
+	for i := range s.Mappings {
+		if s.Mappings[i].ID == id || s.Mappings[i].UUID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// GetMapping returns a copy of the mapping with the given id or uuid.
+func GetMapping(s *types.Server, id string) (types.Mapping, bool) {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+	if i := findMapping(s, id); i != -1 {
+		return s.Mappings[i], true
+	}
+	return types.Mapping{}, false
+}
+
+// ReplaceMapping swaps the mapping with the given id or uuid for m, keeping its position.
+// The mappings slice is copied rather than written in place, because requests in flight
+// still read their matched stub through a pointer into the old one.
+func ReplaceMapping(s *types.Server, id string, m types.Mapping) bool {
+	matching.Precompile(&m)
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	i := findMapping(s, id)
+	if i == -1 {
+		return false
+	}
+	// Keep the file origin so an edited file-backed mapping isn't saved a second time
+	m.SourceFile = s.Mappings[i].SourceFile
+	mappings := slices.Clone(s.Mappings)
+	mappings[i] = m
+	s.Mappings = mappings
+	pruneSequencePositions(s)
+	return true
+}
+
+// RemoveMapping deletes the mapping with the given id or uuid. Like ReplaceMapping, it
+// builds a new slice so in-flight requests keep a stable view of their stub.
+func RemoveMapping(s *types.Server, id string) bool {
+	s.Mu.Lock()
+	defer s.Mu.Unlock()
+	i := findMapping(s, id)
+	if i == -1 {
+		return false
+	}
+	s.Mappings = slices.Concat(s.Mappings[:i], s.Mappings[i+1:])
+	pruneSequencePositions(s)
+	return true
+}
+
+func writeMapping(ctx *fasthttp.RequestCtx, status int, m *types.Mapping) {
+	data, _ := json.Marshal(m)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(status)
+	ctx.SetBody(data)
+}
+
+// mappingNotFound answers a request for an unknown mapping id with a 404 and a JSON error,
+// which clients reconciling stub state rely on, e.g. when a mapping is deleted twice.
+func mappingNotFound(ctx *fasthttp.RequestCtx, id string) {
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	ctx.SetStatusCode(fasthttp.StatusNotFound)
+	ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, "mapping "+id+" not found"))
+}
+
+// handleMapping serves /__admin/mappings/{id}. An unknown id is a 404 for every method.
+func handleMapping(s *types.Server, ctx *fasthttp.RequestCtx, method, id string) {
+	switch method {
+	case "GET":
+		m, ok := GetMapping(s, id)
+		if !ok {
+			mappingNotFound(ctx, id)
+			return
+		}
+		writeMapping(ctx, fasthttp.StatusOK, &m)
+
+	case "PUT":
+		var m types.Mapping
+		if err := json.Unmarshal(ctx.PostBody(), &m); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(err.Error())
+			return
+		}
+		// The path decides which mapping is replaced; the stored copy keeps that id
+		m.ID, m.UUID = id, id
+		if !ReplaceMapping(s, id, m) {
+			mappingNotFound(ctx, id)
+			return
+		}
+		log.Printf("Updated mapping %s: %s %s", id, m.Request.Method, getRequestPattern(&m))
+		writeMapping(ctx, fasthttp.StatusOK, &m)
+
+	case "DELETE":
+		if !RemoveMapping(s, id) {
+			mappingNotFound(ctx, id)
+			return
+		}
+		log.Printf("Removed mapping %s", id)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+
+	default:
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+	}
+}
+
+// handleSaveMappings serves POST /__admin/mappings/save, persisting mappings added
+// at runtime to MAPPINGS_DIR.
+func handleSaveMappings(s *types.Server, ctx *fasthttp.RequestCtx) {
+	if s.MappingsDir == "" {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.SetBodyString(`{"error": "MAPPINGS_DIR is not set"}`)
+		return
+	}
+	files, err := SaveMappings(s, s.MappingsDir)
+	if err != nil {
+		log.Printf("Error saving mappings to %s: %v", s.MappingsDir, err)
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.SetBodyString(fmt.Sprintf(`{"error": %q}`, err.Error()))
+		return
+	}
+	log.Printf("Saved %d mappings to %s", len(files), s.MappingsDir)
+	ctx.Response.Header.Set("Content-Type", "application/json")
+	data, _ := json.Marshal(map[string][]string{"files": files})
+	ctx.SetBody(data)
+}
+
+// handleSettings serves /__admin/settings. POST replaces the global settings, so
+// posting {"fixedDelay": 0} or {} clears a previously set delay.
+func handleSettings(s *types.Server, ctx *fasthttp.RequestCtx, method string) {
+	switch method {
+	case "POST", "PUT":
+		var settings types.GlobalSettings
+		if err := json.Unmarshal(ctx.PostBody(), &settings); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.SetBodyString(err.Error())
+			return
+		}
+		s.Mu.Lock()
+		s.Settings = settings
+		s.Mu.Unlock()
+		log.Printf("Global settings updated: fixedDelay=%dms, delayDistribution=%v", settings.FixedDelay, settings.DelayDistribution != nil)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+
+	case "GET":
+		s.Mu.RLock()
+		settings := s.Settings
+		s.Mu.RUnlock()
+		ctx.Response.Header.Set("Content-Type", "application/json")
+		data, _ := json.Marshal(map[string]types.GlobalSettings{"settings": settings})
+		ctx.SetBody(data)
+
+	default:
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+	}
+}
+
 func handleMappings(s *types.Server, ctx *fasthttp.RequestCtx, method string) {
 	switch method {
 	case "POST":
@@ -214,12 +1019,13 @@ func handleMappings(s *types.Server, ctx *fasthttp.RequestCtx, method string) {
 			return
 		}
 
+		ensureMappingID(&m)
 		addMapping(s, m)
 		log.Printf("Added mapping: %s %s", m.Request.Method, getRequestPattern(&m))
-		ctx.SetStatusCode(fasthttp.StatusCreated)
+		writeMapping(ctx, fasthttp.StatusCreated, &m)
 
 	case "DELETE":
-		ClearMappings(s)
+		ResetMappings(s, IncludePersistent(ctx))
 		ctx.SetStatusCode(fasthttp.StatusOK)
 
 	case "GET":
@@ -236,47 +1042,82 @@ func handleMappings(s *types.Server, ctx *fasthttp.RequestCtx, method string) {
 	}
 }
 
-// LogVerboseRequest logs incoming request details when verbose mode is enabled.
-func LogVerboseRequest(ctx *fasthttp.RequestCtx, method, rawURI string) {
+// LogVerboseRequest logs incoming request details when verbose mode is enabled, as
+// a JSON "request" event when the server logs JSON.
+func LogVerboseRequest(s *types.Server, ctx *fasthttp.RequestCtx, method, rawURI string) {
+	bodyStr := string(ctx.PostBody())
+	if len(bodyStr) > 1000 {
+		bodyStr = bodyStr[:1000] + fmt.Sprintf("... (%d bytes total)", len(ctx.PostBody()))
+	}
+	if s.JSONLogs {
+		headers := make(map[string][]string)
+		ctx.Request.Header.VisitAll(func(key, value []byte) {
+			headers[string(key)] = append(headers[string(key)], string(value))
+		})
+		logging.LogRequest(method, rawURI, headers, bodyStr)
+		return
+	}
+
 	log.Printf("[verbose] >> %s %s", method, rawURI)
 	ctx.Request.Header.VisitAll(func(key, value []byte) {
 		log.Printf("[verbose]    %s: %s", string(key), string(value))
 	})
-	if body := ctx.PostBody(); len(body) > 0 {
-		bodyStr := string(body)
-		if len(bodyStr) > 1000 {
-			bodyStr = bodyStr[:1000] + fmt.Sprintf("... (%d bytes total)", len(body))
-		}
+	if bodyStr != "" {
 		log.Printf("[verbose]    Body: %s", bodyStr)
 	}
 }
 
-// isBinaryResponse checks if the response Content-Type matches any of the given binary types.
-func isBinaryResponse(headers map[string]any, binaryTypes []string) bool {
-	if len(binaryTypes) == 0 {
-		return false
+// rawBody returns the bytes of a stub that serves a raw body, and false if it has none.
+// Body sources are used in WireMock's order body, jsonBody, base64Body, bodyFileName,
+// so a base64Body or file is only read when neither body nor jsonBody is set. fileName
+// is the stub's bodyFileName, already rendered when the stub uses response templating.
+func rawBody(s *types.Server, resp *types.Response, fileName string) ([]byte, bool, error) {
+	switch {
+	case resp.Body != "" || resp.JsonBody != nil:
+		return nil, false, nil
+	case resp.Base64Body != "":
+		data, err := base64.StdEncoding.DecodeString(resp.Base64Body)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid base64Body: %w", err)
+		}
+		return data, true, nil
+	case fileName != "":
+		data, err := readBodyFile(s.FilesDir, fileName)
+		return data, true, err
 	}
+	return nil, false, nil
+}
+
+// headerValue returns the first value of a stub response header (case-insensitive), or "".
+func headerValue(headers map[string]any, name string) string {
 	for key, value := range headers {
-		if !strings.EqualFold(key, "Content-Type") {
+		if !strings.EqualFold(key, name) {
 			continue
 		}
-		var ct string
 		switch v := value.(type) {
 		case string:
-			ct = v
+			return v
 		case []interface{}:
 			if len(v) > 0 {
 				if s, ok := v[0].(string); ok {
-					ct = s
+					return s
 				}
 			}
 		}
-		if ct != "" {
-			mediaType := strings.TrimSpace(strings.SplitN(ct, ";", 2)[0])
-			for _, bt := range binaryTypes {
-				if strings.EqualFold(mediaType, bt) {
-					return true
-				}
+	}
+	return ""
+}
+
+// isBinaryResponse checks if the response Content-Type matches any of the given binary types.
+func isBinaryResponse(headers map[string]any, binaryTypes []string) bool {
+	if len(binaryTypes) == 0 {
+		return false
+	}
+	if ct := headerValue(headers, "Content-Type"); ct != "" {
+		mediaType := strings.TrimSpace(strings.SplitN(ct, ";", 2)[0])
+		for _, bt := range binaryTypes {
+			if strings.EqualFold(mediaType, bt) {
+				return true
 			}
 		}
 	}
@@ -290,5 +1131,14 @@ func getRequestPattern(m *types.Mapping) string {
 	if m.Request.URLPath != "" {
 		return m.Request.URLPath
 	}
-	return m.Request.URLPattern
+	if m.Request.URLPattern != "" {
+		return m.Request.URLPattern
+	}
+	if m.Request.URLPathPattern != "" {
+		return m.Request.URLPathPattern
+	}
+	if m.Request.URLPathTemplate != "" {
+		return m.Request.URLPathTemplate
+	}
+	return m.Request.URLPathGlob
 }
@@ -0,0 +1,2653 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goodmock/internal/proxy"
+	"goodmock/internal/types"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// newRequestCtx builds a request context for calling HandleRequest directly.
+func newRequestCtx(method, uri, body string) *fasthttp.RequestCtx {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(uri)
+	if body != "" {
+		ctx.Request.SetBodyString(body)
+	}
+	return ctx
+}
+
+// serve runs a request through HandleRequest and returns the status code and body.
+func serve(s *types.Server, method, uri, body string) (int, string) {
+	ctx := newRequestCtx(method, uri, body)
+	HandleRequest(s, ctx)
+	return ctx.Response.StatusCode(), string(ctx.Response.Body())
+}
+
+// twoStepScenario mirrors what record mode emits for a URL requested twice.
+func twoStepScenario() types.WiremockMappings {
+	return types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Name:                  "api_status",
+			ScenarioName:          "api_status",
+			RequiredScenarioState: types.ScenarioStarted,
+			NewScenarioState:      "state_1",
+			Request:               types.Request{Method: "GET", URL: "/api/status"},
+			Response:              types.Response{Status: 200, Body: "pending"},
+		},
+		{
+			Name:                  "api_status",
+			ScenarioName:          "api_status",
+			RequiredScenarioState: "state_1",
+			Request:               types.Request{Method: "GET", URL: "/api/status"},
+			Response:              types.Response{Status: 200, Body: "done"},
+		},
+	}}
+}
+
+func TestScenarioReplaysStepsInOrder(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, twoStepScenario())
+
+	for i, want := range []string{"pending", "done", "done"} {
+		status, body := serve(s, "GET", "/api/status", "")
+		if status != fasthttp.StatusOK || body != want {
+			t.Fatalf("call %d: got %d %q, want 200 %q", i+1, status, body, want)
+		}
+	}
+}
+
+func TestScenarioStepServedOnceUnderConcurrency(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, twoStepScenario())
+
+	// A match made before another request advanced the scenario is stale
+	first := &s.Mappings[0]
+	s.Scenarios["api_status"] = "state_1"
+	if advanceScenario(s, first) || s.Scenarios["api_status"] != "state_1" {
+		t.Fatalf("stale step advanced the scenario to %q", s.Scenarios["api_status"])
+	}
+	delete(s.Scenarios, "api_status")
+
+	const requests = 50
+	var pending atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, body := serve(s, "GET", "/api/status", ""); body == "pending" {
+				pending.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if n := pending.Load(); n != 1 {
+		t.Errorf("%d of %d concurrent requests got the first step, want exactly 1", n, requests)
+	}
+}
+
+func TestScenarioSecondStepUnavailableBeforeFirst(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	wm := twoStepScenario()
+	// Only load the second step — it must not match while the scenario is still Started
+	LoadMappings(s, types.WiremockMappings{Mappings: wm.Mappings[1:]})
+
+	if status, _ := serve(s, "GET", "/api/status", ""); status != fasthttp.StatusNotFound {
+		t.Fatalf("got status %d, want 404 while scenario is in Started state", status)
+	}
+}
+
+func TestScenarioReset(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, twoStepScenario())
+
+	serve(s, "GET", "/api/status", "")
+	if _, body := serve(s, "GET", "/api/status", ""); body != "done" {
+		t.Fatalf("got %q before reset, want %q", body, "done")
+	}
+
+	ctx := newRequestCtx("POST", "/__admin/scenarios/reset", "")
+	HandleRequest(s, ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("scenario reset returned %d", ctx.Response.StatusCode())
+	}
+
+	if _, body := serve(s, "GET", "/api/status", ""); body != "pending" {
+		t.Fatalf("got %q after reset, want %q", body, "pending")
+	}
+}
+
+func TestResponseSequence(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	wm, err := parseMappings([]byte(`{"request": {"method": "GET", "url": "/api/export/1"}, "response": {"sequence": [
+		{"status": 202, "body": "queued"},
+		{"status": 202, "body": "running"},
+		{"status": 200, "jsonBody": {"state": "done"}}
+	]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadMappings(s, wm)
+
+	type reply struct {
+		status int
+		body   string
+	}
+	want := []reply{{202, "queued"}, {202, "running"}, {200, `{"state":"done"}`}, {200, `{"state":"done"}`}}
+	for i, w := range want {
+		if i == 2 {
+			// Growing the mappings slice must not lose the stub's position
+			LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{Request: types.Request{Method: "GET", URL: "/other"}}}})
+		}
+		if status, body := serve(s, "GET", "/api/export/1", ""); status != w.status || body != w.body {
+			t.Fatalf("call %d: got %d %q, want %d %q", i+1, status, body, w.status, w.body)
+		}
+	}
+
+	ResetScenarios(s)
+	if _, body := serve(s, "GET", "/api/export/1", ""); body != "queued" {
+		t.Errorf("got %q after reset, want the sequence to start over", body)
+	}
+}
+
+func TestSequencePositionsPrunedWithTheirStubs(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	wm, err := parseMappings([]byte(`{"mappings": [
+		{"id": "a", "request": {"method": "GET", "url": "/a"}, "response": {"sequence": [{"body": "a1"}, {"body": "a2"}]}},
+		{"id": "b", "request": {"method": "GET", "url": "/b"}, "response": {"sequence": [{"body": "b1"}, {"body": "b2"}]}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadMappings(s, wm)
+	serve(s, "GET", "/a", "")
+	serve(s, "GET", "/b", "")
+
+	positions := func() int {
+		s.Mu.RLock()
+		defer s.Mu.RUnlock()
+		return len(s.SequencePositions)
+	}
+	replacement := s.Mappings[0]
+	replacement.Response.Sequence = []types.Response{{Body: "new"}}
+	ReplaceMapping(s, "a", replacement)
+	if n := positions(); n != 1 {
+		t.Errorf("%d positions after replacing a stub, want 1", n)
+	}
+	if _, body := serve(s, "GET", "/b", ""); body != "b2" {
+		t.Errorf("got %q, want the untouched stub to keep its position", body)
+	}
+
+	RemoveMapping(s, "b")
+	if n := positions(); n != 0 {
+		t.Errorf("%d positions after removing the stub, want 0", n)
+	}
+}
+
+func TestWeightedResponses(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	SeedRandom(s, 42)
+	wm, err := parseMappings([]byte(`{"request": {"method": "GET", "url": "/api/flaky"}, "response": {"weightedResponses": [
+		{"weight": 90, "status": 200, "body": "ok"},
+		{"weight": 10, "status": 500, "body": "boom"},
+		{"weight": 0, "status": 418, "body": "never"}
+	]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadMappings(s, wm)
+
+	const calls = 10000
+	counts := make(map[int]int)
+	for i := 0; i < calls; i++ {
+		status, body := serve(s, "GET", "/api/flaky", "")
+		if (status == 200 && body != "ok") || (status == 500 && body != "boom") {
+			t.Fatalf("call %d: got %d %q, mixing fields of different entries", i+1, status, body)
+		}
+		counts[status]++
+	}
+	if counts[418] != 0 {
+		t.Errorf("zero-weight entry served %d times", counts[418])
+	}
+	if share := float64(counts[500]) / calls; share < 0.08 || share > 0.12 {
+		t.Errorf("500 share = %.3f over %d calls, want 0.10 ± 0.02", share, calls)
+	}
+	if counts[200]+counts[500] != calls {
+		t.Errorf("unexpected statuses: %v", counts)
+	}
+}
+
+func TestWeightedResponsesReproducibleWithSeed(t *testing.T) {
+	mappings := types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "GET", URL: "/coin"},
+		Response: types.Response{WeightedResponses: []types.Response{
+			{Status: 200, Body: "heads"},
+			{Status: 200, Body: "tails"},
+		}},
+	}}}
+	a := NewServer("", "/", false, nil)
+	b := NewServer("", "/", false, nil)
+	LoadMappings(a, mappings)
+	LoadMappings(b, mappings)
+	SeedRandom(a, 7)
+	SeedRandom(b, 7)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		_, bodyA := serve(a, "GET", "/coin", "")
+		_, bodyB := serve(b, "GET", "/coin", "")
+		if bodyA != bodyB {
+			t.Fatalf("call %d differs with the same seed: %q vs %q", i+1, bodyA, bodyB)
+		}
+		seen[bodyA] = true
+	}
+	if !seen["heads"] || !seen["tails"] {
+		t.Errorf("unweighted entries should default to equal weight, saw %v", seen)
+	}
+}
+
+func TestResponseTemplating(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request: types.Request{Method: "GET", URLPathTemplate: "/workspaces/{workspaceId}/objects/{objectId}"},
+			Response: types.Response{
+				Status:       200,
+				Body:         `{"workspace":"{{request.path.workspaceId}}","object":"{{request.path.[3]}}","id":"{{request.query.id}}","requestId":"{{request.headers.X-Request-Id}}"}`,
+				Headers:      map[string]any{"X-Echo-Id": "{{request.query.id}}"},
+				Transformers: []string{"response-template"},
+			},
+		},
+		{
+			Request: types.Request{Method: "GET", URLPath: "/json"},
+			Response: types.Response{
+				Status:       200,
+				JsonBody:     map[string]any{"id": "{{request.query.id}}"},
+				Transformers: []string{"response-template"},
+			},
+		},
+		{
+			Request: types.Request{Method: "GET", URLPath: "/literal"},
+			Response: types.Response{
+				Status:  200,
+				Body:    `<p>{{request.query.id}} is Handlebars</p>`,
+				Headers: map[string]any{"X-Echo-Id": "{{request.query.id}}"},
+			},
+		},
+	}})
+
+	ctx := newRequestCtx("GET", "/workspaces/demo/objects/42?id=abc", "")
+	ctx.Request.Header.Set("X-Request-Id", "req-1")
+	HandleRequest(s, ctx)
+
+	expected := `{"workspace":"demo","object":"42","id":"abc","requestId":"req-1"}`
+	if got := string(ctx.Response.Body()); got != expected {
+		t.Errorf("body = %s, want %s", got, expected)
+	}
+	if got := string(ctx.Response.Header.Peek("X-Echo-Id")); got != "abc" {
+		t.Errorf("X-Echo-Id = %q, want %q", got, "abc")
+	}
+
+	if _, body := serve(s, "GET", "/json?id=xyz", ""); body != `{"id":"xyz"}` {
+		t.Errorf("jsonBody = %s, want %s", body, `{"id":"xyz"}`)
+	}
+
+	// Without the response-template transformer, placeholders are served verbatim
+	ctx = newRequestCtx("GET", "/literal?id=abc", "")
+	HandleRequest(s, ctx)
+	if got := string(ctx.Response.Body()); got != `<p>{{request.query.id}} is Handlebars</p>` {
+		t.Errorf("untemplated body = %s", got)
+	}
+	if got := string(ctx.Response.Header.Peek("X-Echo-Id")); got != "{{request.query.id}}" {
+		t.Errorf("untemplated X-Echo-Id = %q", got)
+	}
+}
+
+func TestURLPatternGroupsTemplating(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request: types.Request{Method: "GET", URLPathPattern: `/api/objects/(\w+)/(\d+)`},
+			Response: types.Response{
+				Status:       200,
+				JsonBody:     map[string]any{"type": "{{request.groups.[1]}}", "id": "{{request.groups.[2]}}"},
+				Transformers: []string{"response-template"},
+			},
+		},
+		{
+			Request: types.Request{Method: "GET", URLPattern: `/api/users/(?P<userId>[a-z0-9-]+)\?.*`},
+			Response: types.Response{
+				Status:       200,
+				Body:         "user {{request.groups.userId}} at {{request.path.userId}}",
+				Transformers: []string{"response-template"},
+			},
+		},
+	}})
+
+	if status, body := serve(s, "GET", "/api/objects/metric/42?x=1", ""); status != 200 || body != `{"id":"42","type":"metric"}` {
+		t.Errorf("urlPathPattern groups = %d %s", status, body)
+	}
+	if status, body := serve(s, "GET", "/api/users/ada-1?expand=true", ""); status != 200 || body != "user ada-1 at ada-1" {
+		t.Errorf("named urlPattern group = %d %q", status, body)
+	}
+}
+
+func TestTransformerParameters(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "GET", URLPath: "/greet"},
+		Response: types.Response{
+			Status:                200,
+			JsonBody:              map[string]any{"message": "{{parameters.greeting}}, {{request.query.name}}", "limit": "{{parameters.limits.max}}"},
+			TransformerParameters: map[string]any{"greeting": "Hello", "limits": map[string]any{"max": 10.0}, "unused": true},
+			Transformers:          []string{"response-template"},
+		},
+	}}})
+
+	if _, body := serve(s, "GET", "/greet?name=Ada", ""); body != `{"limit":"10","message":"Hello, Ada"}` {
+		t.Errorf("body = %s", body)
+	}
+}
+
+func TestFixedDelay(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request:  types.Request{Method: "GET", URL: "/slow"},
+			Response: types.Response{Status: 200, Body: "ok", FixedDelayMilliseconds: 100},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/fast"},
+			Response: types.Response{Status: 200, Body: "ok"},
+		},
+	}})
+
+	start := time.Now()
+	if status, _ := serve(s, "GET", "/slow", ""); status != fasthttp.StatusOK {
+		t.Fatalf("got status %d", status)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("response took %v, want at least 100ms", elapsed)
+	}
+
+	// Concurrent delayed requests must not serialize on the server lock
+	start = time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(s, "GET", "/slow", "")
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed >= 400*time.Millisecond {
+		t.Errorf("5 concurrent delayed requests took %v, expected them to delay independently", elapsed)
+	}
+
+	start = time.Now()
+	serve(s, "GET", "/fast", "")
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("undelayed response took %v", elapsed)
+	}
+}
+
+func TestSampleDelayUniform(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	SeedRandom(s, 42)
+	d := &types.DelayDistribution{Type: "uniform", Lower: 20, Upper: 40}
+
+	seenLow, seenHigh := false, false
+	for i := 0; i < 1000; i++ {
+		delay := sampleDelay(s, d)
+		if delay < 20*time.Millisecond || delay > 40*time.Millisecond {
+			t.Fatalf("sample %v outside [20ms, 40ms]", delay)
+		}
+		seenLow = seenLow || delay < 25*time.Millisecond
+		seenHigh = seenHigh || delay > 35*time.Millisecond
+	}
+	if !seenLow || !seenHigh {
+		t.Errorf("samples did not spread across the range (low: %v, high: %v)", seenLow, seenHigh)
+	}
+}
+
+func TestSampleDelayReproducibleWithSeed(t *testing.T) {
+	d := &types.DelayDistribution{Type: "lognormal", Median: 50, Sigma: 0.5}
+	a := NewServer("", "/", false, nil)
+	b := NewServer("", "/", false, nil)
+	SeedRandom(a, 7)
+	SeedRandom(b, 7)
+
+	for i := 0; i < 10; i++ {
+		if da, db := sampleDelay(a, d), sampleDelay(b, d); da != db {
+			t.Fatalf("sample %d differs with the same seed: %v vs %v", i, da, db)
+		}
+	}
+}
+
+func TestSampleDelayLognormalCap(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	SeedRandom(s, 1)
+	d := &types.DelayDistribution{Type: "lognormal", Median: 100, Sigma: 3, MaxValue: 150}
+
+	for i := 0; i < 1000; i++ {
+		if delay := sampleDelay(s, d); delay < 0 || delay > 150*time.Millisecond {
+			t.Fatalf("sample %v outside [0, 150ms]", delay)
+		}
+	}
+}
+
+func TestDelayDistributionApplied(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "GET", URL: "/jittery"},
+		Response: types.Response{
+			Status:                 200,
+			FixedDelayMilliseconds: 20,
+			DelayDistribution:      &types.DelayDistribution{Type: "uniform", Lower: 30, Upper: 40},
+		},
+	}}})
+
+	start := time.Now()
+	serve(s, "GET", "/jittery", "")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("response took %v, want at least fixed 20ms + uniform 30ms", elapsed)
+	}
+}
+
+func TestGlobalFixedDelay(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/instant"},
+		Response: types.Response{Status: 200, FixedDelayMilliseconds: 10},
+	}}})
+
+	timed := func() time.Duration {
+		start := time.Now()
+		serve(s, "GET", "/instant", "")
+		return time.Since(start)
+	}
+
+	if status, _ := serve(s, "POST", "/__admin/settings", `{"fixedDelay": 60}`); status != 200 {
+		t.Fatalf("POST /__admin/settings returned %d", status)
+	}
+	if elapsed := timed(); elapsed < 70*time.Millisecond {
+		t.Errorf("response took %v, want at least global 60ms + stub 10ms", elapsed)
+	}
+	if _, body := serve(s, "GET", "/__admin/settings", ""); body != `{"settings":{"fixedDelay":60}}` {
+		t.Errorf("GET /__admin/settings = %s", body)
+	}
+
+	serve(s, "POST", "/__admin/settings", `{"fixedDelay": 0}`)
+	if elapsed := timed(); elapsed >= 60*time.Millisecond {
+		t.Errorf("response took %v after clearing the global delay", elapsed)
+	}
+
+	if status, _ := serve(s, "POST", "/__admin/settings", `{"fixedDelay": "slow"}`); status != 400 {
+		t.Errorf("invalid settings returned %d, want 400", status)
+	}
+}
+
+// startTestServer serves s on a random local port and returns its address.
+func startTestServer(t *testing.T, s *types.Server) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) { HandleRequest(s, ctx) }}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown() })
+	return ln.Addr().String()
+}
+
+func TestChunkedDribbleDelay(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "GET", URL: "/stream"},
+		Response: types.Response{
+			Status:                 200,
+			Body:                   "0123456789abcdef",
+			FixedDelayMilliseconds: 50,
+			ChunkedDribbleDelay:    &types.ChunkedDribbleDelay{NumberOfChunks: 4, TotalDurationMs: 300},
+		},
+	}}})
+	addr := startTestServer(t, s)
+
+	start := time.Now()
+	resp, err := http.Get("http://" + addr + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if first := time.Since(start); first < 50*time.Millisecond {
+		t.Errorf("headers arrived after %v, want the fixed delay first", first)
+	}
+
+	var body []byte
+	var arrivals []time.Duration
+	buf := make([]byte, 64)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			body = append(body, buf[:n]...)
+			arrivals = append(arrivals, time.Since(start))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if string(body) != "0123456789abcdef" {
+		t.Errorf("body = %q", body)
+	}
+	if len(arrivals) < 3 {
+		t.Fatalf("body arrived in %d reads, want it dribbled in several", len(arrivals))
+	}
+	if spread := arrivals[len(arrivals)-1] - arrivals[0]; spread < 150*time.Millisecond {
+		t.Errorf("chunks arrived within %v, want them spread over the duration", spread)
+	}
+}
+
+// rawGet sends a bare HTTP/1.1 GET over TCP and returns everything read until the server closes.
+func rawGet(t *testing.T, addr, path string) ([]byte, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\n\r\n", path, addr)
+	return io.ReadAll(conn)
+}
+
+func TestFaults(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	var mappings []types.Mapping
+	for _, fault := range []string{FaultEmptyResponse, FaultConnectionResetByPeer, FaultMalformedResponseChunk, FaultRandomDataThenClose} {
+		mappings = append(mappings, types.Mapping{
+			Request:  types.Request{Method: "GET", URL: "/" + fault},
+			Response: types.Response{Status: 200, Body: "should not be sent", Fault: fault},
+		})
+	}
+	LoadMappings(s, types.WiremockMappings{Mappings: mappings})
+	addr := startTestServer(t, s)
+
+	t.Run("empty response", func(t *testing.T) {
+		data, err := rawGet(t, addr, "/"+FaultEmptyResponse)
+		if err != nil {
+			t.Fatalf("expected clean close, got %v", err)
+		}
+		if len(data) != 0 {
+			t.Errorf("expected no data before close, got %q", data)
+		}
+	})
+
+	t.Run("connection reset", func(t *testing.T) {
+		data, err := rawGet(t, addr, "/"+FaultConnectionResetByPeer)
+		if len(data) != 0 {
+			t.Errorf("expected no data, got %q", data)
+		}
+		if err == nil || !strings.Contains(err.Error(), "reset") {
+			t.Errorf("expected connection reset error, got %v", err)
+		}
+	})
+
+	t.Run("malformed chunk", func(t *testing.T) {
+		data, _ := rawGet(t, addr, "/"+FaultMalformedResponseChunk)
+		if !strings.HasPrefix(string(data), "HTTP/1.1 200 OK") {
+			t.Errorf("expected a status line before the garbage, got %q", data)
+		}
+		if strings.Contains(string(data), "should not be sent") {
+			t.Error("stub body must not be sent with a fault")
+		}
+		client := &fasthttp.Client{}
+		req := fasthttp.AcquireRequest()
+		resp := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI("http://" + addr + "/" + FaultMalformedResponseChunk)
+		if err := client.Do(req, resp); err == nil {
+			t.Error("expected an HTTP client error for a malformed chunk")
+		}
+	})
+
+	t.Run("random data then close", func(t *testing.T) {
+		data, _ := rawGet(t, addr, "/"+FaultRandomDataThenClose)
+		if len(data) == 0 {
+			t.Error("expected random data before close")
+		}
+		if strings.Contains(string(data), "should not be sent") {
+			t.Error("stub body must not be sent with a fault")
+		}
+	})
+}
+
+func TestBodyFileName(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "reports"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "reports", "summary.json"), []byte(`{"total":3}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pdf := []byte{'%', 'P', 'D', 'F', 0x00, 0xff}
+	if err := os.WriteFile(filepath.Join(dir, "export.pdf"), pdf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("", "/", false, nil)
+	s.FilesDir = dir
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request:  types.Request{Method: "GET", URL: "/summary"},
+			Response: types.Response{Status: 200, BodyFileName: "reports/summary.json"},
+		},
+		{
+			Request: types.Request{Method: "GET", URL: "/export"},
+			Response: types.Response{Status: 200, BodyFileName: "export.pdf",
+				Headers: map[string]any{"Content-Type": "application/octet-stream"}},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/inline"},
+			Response: types.Response{Status: 200, Body: "inline", BodyFileName: "export.pdf"},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/missing"},
+			Response: types.Response{Status: 200, BodyFileName: "nope.json"},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/escape"},
+			Response: types.Response{Status: 200, BodyFileName: "../secret.txt"},
+		},
+	}})
+
+	ctx := newRequestCtx("GET", "/summary", "")
+	HandleRequest(s, ctx)
+	if got := string(ctx.Response.Body()); got != `{"total":3}` {
+		t.Errorf("summary body = %q", got)
+	}
+	if ct := string(ctx.Response.Header.ContentType()); ct != "application/json" {
+		t.Errorf("summary Content-Type = %q, want inferred application/json", ct)
+	}
+
+	ctx = newRequestCtx("GET", "/export", "")
+	HandleRequest(s, ctx)
+	if got := ctx.Response.Body(); string(got) != string(pdf) {
+		t.Errorf("export body = %v, want %v", got, pdf)
+	}
+	if ct := string(ctx.Response.Header.ContentType()); ct != "application/octet-stream" {
+		t.Errorf("export Content-Type = %q, stub header should win", ct)
+	}
+
+	if status, body := serve(s, "GET", "/inline", ""); status != 200 || body != "inline" {
+		t.Errorf("inline = %d %q, body should take precedence over bodyFileName", status, body)
+	}
+	for _, uri := range []string{"/missing", "/escape"} {
+		status, body := serve(s, "GET", uri, "")
+		if status != 500 || !strings.Contains(body, "bodyFileName") {
+			t.Errorf("%s = %d %q, want 500 with bodyFileName error", uri, status, body)
+		}
+	}
+}
+
+func TestTemplatedBodyFileName(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "files")
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Join(dir, "objects", "response-1.json"): `{"id":1}`,
+		filepath.Join(dir, "objects", "response-2.json"): `{"id":2}`,
+		filepath.Join(root, "secret.json"):               `{"secret":true}`,
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewServer("", "/", false, nil)
+	s.FilesDir = dir
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request: types.Request{Method: "GET", URLPathPattern: "/api/objects/[^/]+"},
+			Response: types.Response{
+				Status:       200,
+				BodyFileName: "objects/response-{{request.path.[2]}}.json",
+				Transformers: []string{"response-template"},
+			},
+		},
+		{
+			Request: types.Request{Method: "GET", URLPath: "/api/lookup"},
+			Response: types.Response{
+				Status:       200,
+				BodyFileName: "objects/{{request.query.name}}.json",
+				Transformers: []string{"response-template"},
+			},
+		},
+		{
+			Request:  types.Request{Method: "GET", URLPath: "/api/literal"},
+			Response: types.Response{Status: 200, BodyFileName: "objects/response-{{request.path.[2]}}.json"},
+		},
+	}})
+
+	for uri, want := range map[string]string{"/api/objects/1": `{"id":1}`, "/api/objects/2": `{"id":2}`} {
+		ctx := newRequestCtx("GET", uri, "")
+		HandleRequest(s, ctx)
+		if status, body := ctx.Response.StatusCode(), string(ctx.Response.Body()); status != 200 || body != want {
+			t.Errorf("%s = %d %q, want 200 %q", uri, status, body, want)
+		}
+		if ct := string(ctx.Response.Header.ContentType()); ct != "application/json" {
+			t.Errorf("%s Content-Type = %q, want inferred from the rendered name", uri, ct)
+		}
+	}
+
+	for _, uri := range []string{
+		"/api/objects/3",                   // no such fixture
+		"/api/lookup?name=../../secret",    // rendered name escapes FILES_DIR
+		"/api/lookup?name=%2Fetc%2Fpasswd", // stays under FILES_DIR
+		"/api/literal",                     // not rendered without the transformer
+	} {
+		status, body := serve(s, "GET", uri, "")
+		if status != 500 || !strings.Contains(body, "bodyFileName") || strings.Contains(body, "secret\":true") {
+			t.Errorf("%s = %d %q, want 500 with bodyFileName error", uri, status, body)
+		}
+	}
+}
+
+func TestBase64Body(t *testing.T) {
+	// 1x1 transparent PNG
+	const pngB64 = "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNkYPhfDwAChwGA60e6kgAAAABJRU5ErkJggg=="
+	png, _ := base64.StdEncoding.DecodeString(pngB64)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("from file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", "/", false, nil)
+	s.FilesDir = dir
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request: types.Request{Method: "GET", URL: "/pixel.png"},
+			Response: types.Response{Status: 200, Base64Body: pngB64,
+				Headers: map[string]any{"Content-Type": "image/png"}},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/both"},
+			Response: types.Response{Status: 200, Base64Body: pngB64, BodyFileName: "file.txt"},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/broken"},
+			Response: types.Response{Status: 200, Base64Body: "not base64!"},
+		},
+	}})
+
+	ctx := newRequestCtx("GET", "/pixel.png", "")
+	HandleRequest(s, ctx)
+	if got := ctx.Response.Body(); !bytes.Equal(got, png) {
+		t.Errorf("pixel body = %v, want decoded PNG", got)
+	}
+	if !bytes.HasPrefix(ctx.Response.Body(), []byte("\x89PNG")) {
+		t.Errorf("pixel body is not a PNG")
+	}
+	if status, body := serve(s, "GET", "/both", ""); status != 200 || body != string(png) {
+		t.Errorf("both = %d %q, base64Body should take precedence over bodyFileName", status, body)
+	}
+	if status, body := serve(s, "GET", "/broken", ""); status != 500 || !strings.Contains(body, "base64Body") {
+		t.Errorf("broken = %d %q, want 500 with base64Body error", status, body)
+	}
+}
+
+func TestBodyFieldPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", "/", false, nil)
+	s.FilesDir = dir
+
+	// WireMock's order: body, jsonBody, base64Body, bodyFileName
+	tests := []struct {
+		name     string
+		response types.Response
+		want     string
+	}{
+		{"body over jsonBody", types.Response{Body: "body", JsonBody: json.RawMessage(`"json"`)}, "body"},
+		{"body over base64Body", types.Response{Body: "body", Base64Body: "YmFzZTY0"}, "body"},
+		{"jsonBody over base64Body", types.Response{JsonBody: json.RawMessage(`"json"`), Base64Body: "YmFzZTY0"}, `"json"`},
+		{"jsonBody over bodyFileName", types.Response{JsonBody: json.RawMessage(`"json"`), BodyFileName: "file.txt"}, `"json"`},
+		{"base64Body over bodyFileName", types.Response{Base64Body: "YmFzZTY0", BodyFileName: "file.txt"}, "base64"},
+		{"all four", types.Response{Body: "body", JsonBody: json.RawMessage(`"json"`), Base64Body: "YmFzZTY0", BodyFileName: "file.txt"}, "body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.response.Status = 200
+			ResetMappings(s, true)
+			LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+				{Request: types.Request{Method: "GET", URL: "/body"}, Response: tt.response},
+			}})
+			if status, body := serve(s, "GET", "/body", ""); status != 200 || body != tt.want {
+				t.Errorf("got %d %q, want 200 %q", status, body, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminMappingWithJsonBody(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	mapping := `{
+		"request": {"method": "GET", "url": "/api/items"},
+		"response": {"status": 200, "jsonBody": {"items": [1, 2], "meta": {"total": 2}}}
+	}`
+	if status, body := serve(s, "POST", "/__admin/mappings", mapping); status != 201 {
+		t.Fatalf("POST mapping = %d %q", status, body)
+	}
+
+	status, body := serve(s, "GET", "/api/items", "")
+	if status != 200 {
+		t.Fatalf("status = %d", status)
+	}
+	if want := `{"items":[1,2],"meta":{"total":2}}`; body != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+}
+
+func TestProxyBaseUrl(t *testing.T) {
+	var gotPath, gotBody string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.RequestURI()
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.Header().Set("X-Upstream", "live")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(w, `{"source":"upstream"}`)
+	}))
+	defer upstream.Close()
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Request:  types.Request{Method: "POST", URLPathPattern: "/live/.*"},
+			Response: types.Response{ProxyBaseUrl: upstream.URL + "/"},
+		},
+		{
+			Request:  types.Request{Method: "GET", URL: "/mocked"},
+			Response: types.Response{Status: 200, Body: "mocked"},
+		},
+	}})
+
+	ctx := newRequestCtx("POST", "/live/items?page=2", `{"name":"x"}`)
+	HandleRequest(s, ctx)
+	if status := ctx.Response.StatusCode(); status != http.StatusAccepted {
+		t.Errorf("status = %d, want 202 from upstream", status)
+	}
+	if body := string(ctx.Response.Body()); body != `{"source":"upstream"}` {
+		t.Errorf("body = %q", body)
+	}
+	if h := string(ctx.Response.Header.Peek("X-Upstream")); h != "live" {
+		t.Errorf("X-Upstream = %q, want upstream headers passed through", h)
+	}
+	if gotPath != "/live/items?page=2" || gotBody != `{"name":"x"}` {
+		t.Errorf("upstream saw %q %q", gotPath, gotBody)
+	}
+
+	if status, body := serve(s, "GET", "/mocked", ""); status != 200 || body != "mocked" {
+		t.Errorf("mocked = %d %q", status, body)
+	}
+}
+
+func TestProxyBaseUrlErrorBody(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/live"},
+		Response: types.Response{ProxyBaseUrl: `http://bad"host\name.invalid`},
+	}}})
+
+	status, body := serve(s, "GET", "/live", "")
+	var errBody struct{ Error string }
+	if err := json.Unmarshal([]byte(body), &errBody); err != nil || !strings.HasPrefix(errBody.Error, "proxy error: ") {
+		t.Errorf("unreachable upstream = %d %q, want a valid JSON proxy error", status, body)
+	}
+}
+
+func TestProxyRequestHeaderRewriting(t *testing.T) {
+	var seen http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+	defer upstream.Close()
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "GET", URL: "/live"},
+		Response: types.Response{
+			ProxyBaseUrl:                  upstream.URL,
+			AdditionalProxyRequestHeaders: map[string]string{"Authorization": "Bearer secret", "X-Client": "goodmock"},
+			RemoveProxyRequestHeaders:     []string{"x-trace-id"},
+		},
+	}}})
+
+	ctx := newRequestCtx("GET", "/live", "")
+	ctx.Request.Header.Set("X-Trace-Id", "abc")
+	ctx.Request.Header.Set("X-Client", "browser")
+	ctx.Request.Header.Set("X-Kept", "yes")
+	HandleRequest(s, ctx)
+
+	if got := seen.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization = %q, want injected value", got)
+	}
+	if got := seen.Values("X-Client"); len(got) != 1 || got[0] != "goodmock" {
+		t.Errorf("X-Client = %q, want injected value to overwrite the original", got)
+	}
+	if got := seen.Get("X-Trace-Id"); got != "" {
+		t.Errorf("X-Trace-Id = %q, want it removed", got)
+	}
+	if got := seen.Get("X-Kept"); got != "yes" {
+		t.Errorf("X-Kept = %q, want untouched headers forwarded", got)
+	}
+}
+
+func TestProxyTimeout(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		// Hold the response until the test ends, or until the proxy gives up and hangs up
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	s := NewServer("", "/", false, nil)
+	s.ProxyClient = proxy.NewClient(proxy.Config{Timeout: 50 * time.Millisecond, Retries: 1})
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "ANY", URLPathPattern: "/slow.*"}, Response: types.Response{ProxyBaseUrl: upstream.URL}},
+	}})
+
+	if status, body := serve(s, "GET", "/slow", ""); status != http.StatusGatewayTimeout || !strings.Contains(body, "timeout") {
+		t.Errorf("GET = %d %q, want 504 timeout", status, body)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream saw %d GET attempts, want 2 with one retry", n)
+	}
+
+	calls.Store(0)
+	if status, _ := serve(s, "POST", "/slow", "{}"); status != http.StatusGatewayTimeout {
+		t.Errorf("POST = %d, want 504", status)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream saw %d POST attempts, want 1 since POST is not retried", n)
+	}
+}
+
+func TestProxyRetriesAreTheOnlyRetries(t *testing.T) {
+	// An upstream that drops every connection, which fasthttp would retry on its own
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "GET", URL: "/live"}, Response: types.Response{ProxyBaseUrl: "http://" + ln.Addr().String()}},
+	}})
+	for _, retries := range []int{0, 2} {
+		accepted.Store(0)
+		s.ProxyClient = proxy.NewClient(proxy.Config{Retries: retries})
+		if status, _ := serve(s, "GET", "/live", ""); status != http.StatusBadGateway {
+			t.Errorf("retries=%d: status = %d, want 502", retries, status)
+		}
+		if n := accepted.Load(); n != int32(retries+1) {
+			t.Errorf("retries=%d: upstream saw %d connections, want %d", retries, n, retries+1)
+		}
+	}
+}
+
+func TestProxyUpstreamTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure upstream")
+	}))
+	defer upstream.Close()
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "GET", URL: "/live"}, Response: types.Response{ProxyBaseUrl: upstream.URL}},
+	}})
+
+	s.ProxyClient = proxy.NewClient(proxy.Config{})
+	if status, _ := serve(s, "GET", "/live", ""); status != http.StatusBadGateway {
+		t.Errorf("strict = %d, want 502 for a self-signed upstream", status)
+	}
+
+	s.ProxyClient = proxy.NewClient(proxy.Config{InsecureSkipVerify: true})
+	if status, body := serve(s, "GET", "/live", ""); status != 200 || body != "secure upstream" {
+		t.Errorf("insecure = %d %q", status, body)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(upstream.Certificate())
+	s.ProxyClient = proxy.NewClient(proxy.Config{RootCAs: roots})
+	if status, body := serve(s, "GET", "/live", ""); status != 200 || body != "secure upstream" {
+		t.Errorf("custom CA = %d %q", status, body)
+	}
+}
+
+func TestProxyHostHeader(t *testing.T) {
+	var gotHost string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost = r.Host
+	}))
+	defer upstream.Close()
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "GET", URL: "/live"}, Response: types.Response{ProxyBaseUrl: upstream.URL}},
+	}})
+	proxied := func(config proxy.Config) string {
+		s.ProxyClient = proxy.NewClient(config)
+		ctx := newRequestCtx("GET", "/live", "")
+		ctx.Request.Header.SetHost("app.example.com")
+		HandleRequest(s, ctx)
+		return gotHost
+	}
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	if host := proxied(proxy.Config{}); host != upstreamHost {
+		t.Errorf("default Host = %q, want %q", host, upstreamHost)
+	}
+	if host := proxied(proxy.Config{PreserveHost: true}); host != "app.example.com" {
+		t.Errorf("preserved Host = %q, want the client's", host)
+	}
+	if host := proxied(proxy.Config{PreserveHost: true, HostOverride: "tenant.internal"}); host != "tenant.internal" {
+		t.Errorf("overridden Host = %q, want tenant.internal", host)
+	}
+}
+
+// journal fetches GET /__admin/requests and decodes the envelope.
+func journal(t *testing.T, s *types.Server) []types.ServeEvent {
+	t.Helper()
+	status, body := serve(s, "GET", "/__admin/requests", "")
+	if status != 200 {
+		t.Fatalf("GET /__admin/requests = %d %q", status, body)
+	}
+	var envelope struct {
+		Requests []types.ServeEvent `json:"requests"`
+		Meta     struct {
+			Total int `json:"total"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		t.Fatalf("decode journal: %v", err)
+	}
+	if envelope.Meta.Total != len(envelope.Requests) {
+		t.Errorf("meta.total = %d, want %d", envelope.Meta.Total, len(envelope.Requests))
+	}
+	return envelope.Requests
+}
+
+func TestRequestJournal(t *testing.T) {
+	s := NewServer("http://localhost", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		ID:       "login-stub",
+		Request:  types.Request{Method: "POST", URL: "/login"},
+		Response: types.Response{Status: 200},
+	}}})
+
+	ctx := newRequestCtx("POST", "/login", `{"user":"a"}`)
+	ctx.Request.Header.Set("X-Test", "one")
+	HandleRequest(s, ctx)
+	serve(s, "GET", "/missing?x=1", "")
+	serve(s, "GET", "/__admin/mappings", "")
+
+	events := journal(t, s)
+	if len(events) != 2 {
+		t.Fatalf("journal has %d entries, want 2 (admin requests are not journaled)", len(events))
+	}
+	// Newest first
+	if got := events[0]; got.Request.URL != "/missing?x=1" || got.WasMatched || got.StubMapping != nil {
+		t.Errorf("events[0] = %+v, want unmatched /missing?x=1", got)
+	}
+	got := events[1]
+	if got.Request.Method != "POST" || got.Request.URL != "/login" || got.Request.Body != `{"user":"a"}` {
+		t.Errorf("events[1].Request = %+v", got.Request)
+	}
+	if !got.WasMatched || got.StubMapping == nil || got.StubMapping.ID != "login-stub" {
+		t.Errorf("events[1] matched stub = %+v, want login-stub", got.StubMapping)
+	}
+	if h := got.Request.Headers["X-Test"]; len(h) != 1 || h[0] != "one" {
+		t.Errorf("X-Test = %q", h)
+	}
+	if _, rewritten := got.Request.Headers["Origin"]; rewritten {
+		t.Errorf("journal should hold headers as sent, before rewriting")
+	}
+	if got.ID == "" || got.Request.LoggedDate == 0 {
+		t.Errorf("missing id or loggedDate: %+v", got)
+	}
+
+	if status, _ := serve(s, "DELETE", "/__admin/requests", ""); status != 200 {
+		t.Errorf("DELETE /__admin/requests = %d", status)
+	}
+	if events := journal(t, s); len(events) != 0 {
+		t.Errorf("journal has %d entries after clear, want 0", len(events))
+	}
+}
+
+func TestRequestJournalBounded(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	s.JournalSize = 3
+	for i := 0; i < 5; i++ {
+		serve(s, "GET", fmt.Sprintf("/r%d", i), "")
+	}
+	events := journal(t, s)
+	if len(events) != 3 {
+		t.Fatalf("journal has %d entries, want 3", len(events))
+	}
+	for i, want := range []string{"/r4", "/r3", "/r2"} {
+		if events[i].Request.URL != want {
+			t.Errorf("events[%d] = %s, want %s", i, events[i].Request.URL, want)
+		}
+	}
+
+	s.JournalSize = 0
+	ClearJournal(s)
+	serve(s, "GET", "/r5", "")
+	if events := journal(t, s); len(events) != 0 {
+		t.Errorf("disabled journal recorded %d entries", len(events))
+	}
+}
+
+// countRequests posts criteria to /__admin/requests/count and returns the count.
+func countRequests(t *testing.T, s *types.Server, criteria string) int {
+	t.Helper()
+	status, body := serve(s, "POST", "/__admin/requests/count", criteria)
+	if status != 200 {
+		t.Fatalf("count %s = %d %q", criteria, status, body)
+	}
+	var resp struct {
+		Count int `json:"count"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decode count: %v", err)
+	}
+	return resp.Count
+}
+
+func TestRequestCount(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	serve(s, "POST", "/login", `{"user":"alice"}`)
+	serve(s, "POST", "/login?retry=1", `{"user":"bob"}`)
+	serve(s, "GET", "/login", "")
+	serve(s, "GET", "/logout", "")
+
+	tests := []struct {
+		criteria string
+		want     int
+	}{
+		{`{"method": "POST", "urlPath": "/login"}`, 2},
+		{`{"method": "GET", "urlPath": "/login"}`, 1},
+		{`{"url": "/login"}`, 2},
+		{`{"method": "ANY", "urlPattern": "/log.*"}`, 4},
+		{`{}`, 4},
+		{`{"method": "POST", "bodyPatterns": [{"matchesJsonPath": "$.user"}, {"contains": "bob"}]}`, 1},
+		{`{"urlPath": "/login", "queryParameters": {"retry": {"equalTo": "1"}}}`, 1},
+	}
+	for _, tt := range tests {
+		if got := countRequests(t, s, tt.criteria); got != tt.want {
+			t.Errorf("count %s = %d, want %d", tt.criteria, got, tt.want)
+		}
+	}
+
+	if status, _ := serve(s, "POST", "/__admin/requests/count", "not json"); status != 400 {
+		t.Errorf("invalid criteria status = %d, want 400", status)
+	}
+}
+
+func TestMappingRequestCount(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{ID: "create-object", Request: types.Request{Method: "POST", URLPath: "/objects"}, Response: types.Response{Status: 201}},
+		{UUID: "list-objects", Request: types.Request{Method: "GET", URLPath: "/objects"}, Response: types.Response{Status: 200}},
+		{Request: types.Request{Method: "DELETE", URLPath: "/objects"}, Response: types.Response{Status: 204}},
+	}})
+
+	count := func(id string) (int, int) {
+		t.Helper()
+		status, body := serve(s, "GET", "/__admin/mappings/"+id+"/requests/count", "")
+		var resp struct {
+			Count int `json:"count"`
+		}
+		if status == 200 {
+			if err := json.Unmarshal([]byte(body), &resp); err != nil {
+				t.Fatalf("decode count: %v", err)
+			}
+		}
+		return status, resp.Count
+	}
+
+	if status, n := count("create-object"); status != 200 || n != 0 {
+		t.Errorf("count before any request = %d %d, want 200 0", status, n)
+	}
+
+	for range 3 {
+		serve(s, "POST", "/objects", `{"name":"a"}`)
+	}
+	serve(s, "GET", "/objects?page=2", "")
+	serve(s, "DELETE", "/objects", "")
+	serve(s, "POST", "/other", "")
+
+	for id, want := range map[string]int{"create-object": 3, "list-objects": 1} {
+		if status, n := count(id); status != 200 || n != want {
+			t.Errorf("count %s = %d %d, want 200 %d", id, status, n, want)
+		}
+	}
+	if status, _ := count("missing"); status != 404 {
+		t.Errorf("unknown id status = %d, want 404", status)
+	}
+
+	ClearJournal(s)
+	if _, n := count("create-object"); n != 0 {
+		t.Errorf("count after clearing journal = %d, want 0", n)
+	}
+}
+
+func TestRequestFind(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	first := newRequestCtx("POST", "/items?kind=draft", `{"name":"a"}`)
+	HandleRequest(s, first)
+	second := newRequestCtx("POST", "/items?kind=final", `{"name":"b"}`)
+	second.Request.Header.Add("X-Tag", "one")
+	second.Request.Header.Add("X-Tag", "two")
+	HandleRequest(s, second)
+
+	status, body := serve(s, "POST", "/__admin/requests/find",
+		`{"method": "POST", "urlPath": "/items", "queryParameters": {"kind": {"equalTo": "final"}}}`)
+	if status != 200 {
+		t.Fatalf("find = %d %q", status, body)
+	}
+	var envelope struct {
+		Requests []types.LoggedRequest `json:"requests"`
+	}
+	if err := json.Unmarshal([]byte(body), &envelope); err != nil {
+		t.Fatalf("decode find: %v", err)
+	}
+	if len(envelope.Requests) != 1 {
+		t.Fatalf("found %d requests, want 1", len(envelope.Requests))
+	}
+	got := envelope.Requests[0]
+	if got.URL != "/items?kind=final" || got.Body != `{"name":"b"}` {
+		t.Errorf("found %s %q, want the final item", got.URL, got.Body)
+	}
+	if tags := got.Headers["X-Tag"]; len(tags) != 2 || tags[0] != "one" || tags[1] != "two" {
+		t.Errorf("X-Tag = %q, want both values", tags)
+	}
+	// Bodies are serialized as plain strings, not base64 bytes
+	if !strings.Contains(body, `"body":"{\"name\":\"b\"}"`) {
+		t.Errorf("body not serialized as a string: %s", body)
+	}
+}
+
+func TestUnmatchedRequestsAndNearMisses(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Name:     "workspaces",
+		Request:  types.Request{Method: "GET", URLPath: "/api/workspaces"},
+		Response: types.Response{Status: 200},
+	}}})
+	serve(s, "GET", "/api/workspaces", "")
+	serve(s, "GET", "/api/workspace", "")
+
+	status, body := serve(s, "GET", "/__admin/requests/unmatched", "")
+	if status != 200 {
+		t.Fatalf("unmatched = %d %q", status, body)
+	}
+	var unmatched struct {
+		Requests []types.LoggedRequest `json:"requests"`
+	}
+	if err := json.Unmarshal([]byte(body), &unmatched); err != nil {
+		t.Fatalf("decode unmatched: %v", err)
+	}
+	if len(unmatched.Requests) != 1 || unmatched.Requests[0].URL != "/api/workspace" {
+		t.Fatalf("unmatched = %+v, want only the mistyped path", unmatched.Requests)
+	}
+
+	status, body = serve(s, "GET", "/__admin/requests/unmatched/near-misses", "")
+	if status != 200 {
+		t.Fatalf("near-misses = %d %q", status, body)
+	}
+	var nm struct {
+		NearMisses []types.NearMiss `json:"nearMisses"`
+	}
+	if err := json.Unmarshal([]byte(body), &nm); err != nil {
+		t.Fatalf("decode near-misses: %v", err)
+	}
+	if len(nm.NearMisses) != 1 {
+		t.Fatalf("got %d near misses, want 1", len(nm.NearMisses))
+	}
+	miss := nm.NearMisses[0]
+	if miss.Request.URL != "/api/workspace" || miss.StubMapping == nil || miss.StubMapping.Name != "workspaces" {
+		t.Errorf("near miss = %+v, want /api/workspace against the workspaces stub", miss)
+	}
+	if miss.MatchResult.URLMatch || !miss.MatchResult.MethodMatch {
+		t.Errorf("matchResult = %+v, want only the URL to differ", miss.MatchResult)
+	}
+}
+
+func TestMappingByID(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		ID:       "first",
+		Request:  types.Request{Method: "GET", URL: "/first"},
+		Response: types.Response{Status: 200, Body: "first"},
+	}}})
+
+	status, body := serve(s, "POST", "/__admin/mappings",
+		`{"request": {"method": "GET", "url": "/item"}, "response": {"status": 200, "body": "v1"}}`)
+	if status != 201 {
+		t.Fatalf("POST mapping = %d %q", status, body)
+	}
+	var created types.Mapping
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		t.Fatalf("decode created mapping: %v", err)
+	}
+	if created.ID == "" || created.UUID != created.ID {
+		t.Fatalf("created mapping id = %q, uuid = %q; want a generated id", created.ID, created.UUID)
+	}
+	id := created.ID
+
+	if status, body := serve(s, "GET", "/__admin/mappings/"+id, ""); status != 200 || !strings.Contains(body, `"body":"v1"`) {
+		t.Errorf("GET mapping = %d %q", status, body)
+	}
+
+	status, _ = serve(s, "PUT", "/__admin/mappings/"+id,
+		`{"request": {"method": "GET", "url": "/item"}, "response": {"status": 200, "body": "v2"}}`)
+	if status != 200 {
+		t.Fatalf("PUT mapping = %d", status)
+	}
+	if _, body := serve(s, "GET", "/item", ""); body != "v2" {
+		t.Errorf("after PUT, /item = %q, want v2", body)
+	}
+	if len(s.Mappings) != 2 || s.Mappings[1].ID != id {
+		t.Errorf("PUT should replace the mapping in place, got %+v", s.Mappings)
+	}
+
+	if status, _ := serve(s, "DELETE", "/__admin/mappings/"+id, ""); status != 200 {
+		t.Errorf("DELETE mapping = %d", status)
+	}
+	if status, _ := serve(s, "GET", "/item", ""); status != 404 {
+		t.Errorf("after DELETE, /item = %d, want 404", status)
+	}
+	if _, body := serve(s, "GET", "/first", ""); body != "first" {
+		t.Errorf("DELETE removed the wrong mapping, /first = %q", body)
+	}
+
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		status, body := serve(s, method, "/__admin/mappings/"+id, `{"request": {}, "response": {}}`)
+		if status != 404 {
+			t.Errorf("%s unknown mapping = %d, want 404", method, status)
+		}
+		var errBody struct{ Error string }
+		if err := json.Unmarshal([]byte(body), &errBody); err != nil || !strings.Contains(errBody.Error, id) {
+			t.Errorf("%s unknown mapping body = %q, want a JSON error naming the id", method, body)
+		}
+	}
+}
+
+// TestMappingEditsWhileServing is meant for -race: PUT and DELETE of a mapping must not
+// write to the mappings a request in flight is still reading.
+func TestMappingEditsWhileServing(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{ID: "edited", Request: types.Request{Method: "GET", URL: "/edited"}, Response: types.Response{Status: 200, Body: "v0"}},
+		{ID: "stable", Request: types.Request{Method: "GET", URL: "/stable"}, Response: types.Response{Status: 200, Body: "stable"}},
+	}})
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if status, body := serve(s, "GET", "/stable", ""); status != 200 || body != "stable" {
+					t.Errorf("/stable = %d %q while mappings were edited", status, body)
+					return
+				}
+				serve(s, "GET", "/edited", "")
+			}
+		}()
+	}
+
+	for i := range 200 {
+		body := fmt.Sprintf(`{"id": "edited", "request": {"method": "GET", "url": "/edited"}, "response": {"status": 200, "body": "v%d"}}`, i+1)
+		if status, _ := serve(s, "PUT", "/__admin/mappings/edited", body); status != 200 {
+			t.Fatalf("PUT %d = %d", i, status)
+		}
+		if i%10 == 9 {
+			if status, _ := serve(s, "DELETE", "/__admin/mappings/edited", ""); status != 200 {
+				t.Fatalf("DELETE %d = %d", i, status)
+			}
+			if status, _ := serve(s, "POST", "/__admin/mappings", body); status != 201 {
+				t.Fatalf("re-add %d = %d", i, status)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestDeleteMappingTwice(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		ID:       "stub-1",
+		Request:  types.Request{Method: "GET", URL: "/item"},
+		Response: types.Response{Status: 200},
+	}}})
+
+	if status, _ := serve(s, "DELETE", "/__admin/mappings/stub-1", ""); status != 200 {
+		t.Errorf("first DELETE = %d, want 200", status)
+	}
+	if status, body := serve(s, "DELETE", "/__admin/mappings/stub-1", ""); status != 404 || body != `{"error": "mapping stub-1 not found"}` {
+		t.Errorf("second DELETE = %d %q, want 404 with a JSON error", status, body)
+	}
+	if status, _ := serve(s, "DELETE", "/__admin/mappings/never-existed", ""); status != 404 {
+		t.Errorf("DELETE unknown id = %d, want 404", status)
+	}
+}
+
+func TestPostMappingEchoesID(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	tests := []struct {
+		mapping string
+		wantID  string // "" means any generated id
+	}{
+		{`{"request": {"method": "GET", "url": "/a"}, "response": {"status": 200}}`, ""},
+		{`{"id": "given-id", "request": {"method": "GET", "url": "/b"}, "response": {"status": 200}}`, "given-id"},
+		{`{"uuid": "given-uuid", "request": {"method": "GET", "url": "/c"}, "response": {"status": 200}}`, "given-uuid"},
+	}
+	for _, tt := range tests {
+		status, body := serve(s, "POST", "/__admin/mappings", tt.mapping)
+		if status != 201 {
+			t.Fatalf("POST %s = %d %q", tt.mapping, status, body)
+		}
+		var created types.Mapping
+		if err := json.Unmarshal([]byte(body), &created); err != nil {
+			t.Fatalf("decode %q: %v", body, err)
+		}
+		if created.ID == "" || created.UUID != created.ID || (tt.wantID != "" && created.ID != tt.wantID) {
+			t.Errorf("POST %s echoed id %q uuid %q, want %q", tt.mapping, created.ID, created.UUID, tt.wantID)
+		}
+		if created.Request.URL == "" {
+			t.Errorf("POST %s did not echo the full mapping: %s", tt.mapping, body)
+		}
+	}
+}
+
+func TestDefaultResponse(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/api/health"},
+		Response: types.Response{Status: 200, Body: "ok"},
+	}}})
+
+	if status, _ := serve(s, "GET", "/api/anything", ""); status != 404 {
+		t.Errorf("without a default: %d, want 404", status)
+	}
+
+	s.DefaultResponse = &types.Response{Status: 503, Body: `{"error": "maintenance"}`}
+	if status, body := serve(s, "GET", "/api/anything", ""); status != 503 || body != `{"error": "maintenance"}` {
+		t.Errorf("unmatched = %d %q, want the default response", status, body)
+	}
+	if status, body := serve(s, "GET", "/api/health", ""); status != 200 || body != "ok" {
+		t.Errorf("matched = %d %q, want the stub, not the default", status, body)
+	}
+	if n := len(unmatchedServeEvents(s)); n != 2 {
+		t.Errorf("journal has %d unmatched requests, want 2 (defaulted requests still count as unmatched)", n)
+	}
+}
+
+func TestDiagnosticHeaders(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{
+			Name:     "list items",
+			Request:  types.Request{Method: "GET", URLPath: "/api/items"},
+			Response: types.Response{Status: 200},
+		},
+		{
+			Name: "items page 2",
+			Request: types.Request{Method: "GET", URLPath: "/api/items", QueryParameters: map[string]types.QueryParamMatcher{
+				"page": {EqualTo: "2"},
+			}},
+			Response: types.Response{Status: 200},
+		},
+		{
+			Request:  types.Request{Method: "POST", URL: "/api/items"},
+			Response: types.Response{Status: 201},
+		},
+	}})
+	headers := func(method, uri string) (string, string, string) {
+		ctx := newRequestCtx(method, uri, "")
+		HandleRequest(s, ctx)
+		h := &ctx.Response.Header
+		return string(h.Peek("X-GoodMock-Matched-Stub")), string(h.Peek("X-GoodMock-Match-Score")), string(h.Peek("X-GoodMock-Closest-Stub"))
+	}
+
+	if matched, _, _ := headers("GET", "/api/items"); matched != "" {
+		t.Errorf("diagnostics are off by default, got X-GoodMock-Matched-Stub %q", matched)
+	}
+
+	s.Diagnostics = true
+	if matched, score, closest := headers("GET", "/api/items?page=2"); matched != "items page 2" || score != "1" || closest != "" {
+		t.Errorf("page 2 got matched=%q score=%q closest=%q", matched, score, closest)
+	}
+	if matched, score, _ := headers("GET", "/api/items"); matched != "list items" || score != "0" {
+		t.Errorf("page 1 got matched=%q score=%q", matched, score)
+	}
+	if matched, score, _ := headers("POST", "/api/items"); matched != "POST /api/items" || score != "100" {
+		t.Errorf("unnamed stub got matched=%q score=%q", matched, score)
+	}
+	if matched, score, closest := headers("DELETE", "/api/items"); matched != "" || score != "" || closest != "list items" {
+		t.Errorf("miss got matched=%q score=%q closest=%q", matched, score, closest)
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	s.AdminToken = "s3cret"
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/api/items"},
+		Response: types.Response{Status: 200, Body: "items"},
+	}}})
+	admin := func(method, uri string, headers map[string]string) int {
+		ctx := newRequestCtx(method, uri, "")
+		for k, v := range headers {
+			ctx.Request.Header.Set(k, v)
+		}
+		HandleRequest(s, ctx)
+		return ctx.Response.StatusCode()
+	}
+
+	if status := admin("POST", "/__admin/reset", nil); status != 401 {
+		t.Errorf("reset without a token = %d, want 401", status)
+	}
+	if status := admin("GET", "/__admin/mappings", map[string]string{"Authorization": "Bearer wrong"}); status != 401 {
+		t.Errorf("wrong token = %d, want 401", status)
+	}
+	if len(s.Mappings) != 1 {
+		t.Fatalf("rejected reset removed mappings: %d left", len(s.Mappings))
+	}
+	if status, body := serve(s, "GET", "/api/items", ""); status != 200 || body != "items" {
+		t.Errorf("stub traffic = %d %q, want it unaffected by the token", status, body)
+	}
+	if status := admin("GET", "/__admin/health", nil); status != 200 {
+		t.Errorf("health without a token = %d, want 200", status)
+	}
+
+	for _, headers := range []map[string]string{
+		{"Authorization": "Bearer s3cret"},
+		{"Authorization": "s3cret"},
+		{"X-Admin-Token": "s3cret"},
+	} {
+		if status := admin("GET", "/__admin/mappings", headers); status != 200 {
+			t.Errorf("mappings with %v = %d, want 200", headers, status)
+		}
+	}
+	if status := admin("POST", "/__admin/reset", map[string]string{"X-Admin-Token": "s3cret"}); status != 200 || len(s.Mappings) != 0 {
+		t.Errorf("reset with the token = %d with %d mappings left", status, len(s.Mappings))
+	}
+}
+
+func TestRequestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.jsonl")
+	requestLog, err := OpenRequestLog(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", "/", false, nil)
+	s.RequestLog = requestLog
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Name:     "create item",
+		Request:  types.Request{Method: "POST", URL: "/api/items"},
+		Response: types.Response{Status: 201, Body: "created"},
+	}}})
+
+	serve(s, "POST", "/api/items", `{"name":"a"}`)
+	serve(s, "GET", "/missing", "")
+	serve(s, "GET", "/__admin/mappings", "")
+
+	if data, _ := os.ReadFile(path); len(data) != 0 {
+		t.Errorf("lines reached the file before a flush: %q", data)
+	}
+	if err := requestLog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want one per stub request:\n%s", len(lines), data)
+	}
+	var entries []RequestLogEntry
+	for _, line := range lines {
+		var entry RequestLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if e := entries[0]; e.Method != "POST" || e.URL != "/api/items" || e.Status != 201 || !e.Matched || e.Stub != "create item" ||
+		e.RequestBodySize != 12 || e.ResponseBodySize != 7 || e.Timestamp == "" {
+		t.Errorf("matched entry = %+v", e)
+	}
+	if e := entries[1]; e.URL != "/missing" || e.Status != 404 || e.Matched || e.Stub != "" {
+		t.Errorf("unmatched entry = %+v", e)
+	}
+}
+
+func TestCompressedRequestBodies(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{
+			Method:       "POST",
+			URL:          "/api/execute",
+			BodyPatterns: []types.BodyPattern{{EqualToJSON: json.RawMessage(`{"measures": ["m1"]}`)}},
+		},
+		Response: types.Response{Status: 200, Body: "matched"},
+	}}})
+
+	plain := []byte(`{"measures":["m1"]}`)
+	for encoding, body := range map[string][]byte{
+		"gzip":    fasthttp.AppendGzipBytes(nil, plain),
+		"deflate": fasthttp.AppendDeflateBytes(nil, plain),
+		"br":      fasthttp.AppendBrotliBytes(nil, plain),
+		"zstd":    fasthttp.AppendZstdBytes(nil, plain),
+	} {
+		ctx := newRequestCtx("POST", "/api/execute", "")
+		ctx.Request.SetBody(body)
+		ctx.Request.Header.SetContentEncoding(encoding)
+		HandleRequest(s, ctx)
+		if status, got := ctx.Response.StatusCode(), string(ctx.Response.Body()); status != 200 || got != "matched" {
+			t.Errorf("%s body = %d %q, want the equalToJson stub", encoding, status, got)
+		}
+	}
+
+	if events := journalSnapshot(s); len(events) == 0 || events[len(events)-1].Request.Body != string(plain) {
+		t.Errorf("journal should hold the decompressed body")
+	}
+}
+
+func TestDecompressRequestBodyLimit(t *testing.T) {
+	// About 10 MiB of zeros compresses to a few KiB in every encoding
+	plain := make([]byte, 10<<20)
+	for encoding, body := range map[string][]byte{
+		"gzip":    fasthttp.AppendGzipBytes(nil, plain),
+		"deflate": fasthttp.AppendDeflateBytes(nil, plain),
+		"br":      fasthttp.AppendBrotliBytes(nil, plain),
+		"zstd":    fasthttp.AppendZstdBytes(nil, plain),
+	} {
+		var req fasthttp.Request
+		req.SetBody(body)
+		req.Header.SetContentEncoding(encoding)
+		if err := DecompressRequestBody(&req, 1024); !errors.Is(err, ErrRequestBodyTooLarge) {
+			t.Errorf("%s: err = %v, want ErrRequestBodyTooLarge", encoding, err)
+		}
+		if len(req.Body()) != len(body) || string(req.Header.ContentEncoding()) != encoding {
+			t.Errorf("%s: an oversized body should be left untouched", encoding)
+		}
+
+		if err := DecompressRequestBody(&req, len(plain)); err != nil || len(req.Body()) != len(plain) {
+			t.Errorf("%s at the limit: err = %v, %d bytes", encoding, err, len(req.Body()))
+		}
+	}
+
+	var req fasthttp.Request
+	req.SetBody([]byte("not gzip"))
+	req.Header.SetContentEncoding("gzip")
+	if err := DecompressRequestBody(&req, 1024); err != nil || string(req.Body()) != "not gzip" {
+		t.Errorf("undecodable body: err = %v, body %q, want it left untouched", err, req.Body())
+	}
+}
+
+func TestMaxRequestBodySize(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	s.MaxRequestBodySize = 64
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "POST", URL: "/api/upload"},
+		Response: types.Response{Status: 200, Body: "stored"},
+	}}})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{
+		Handler:            func(ctx *fasthttp.RequestCtx) { HandleRequest(s, ctx) },
+		MaxRequestBodySize: s.MaxRequestBodySize,
+		ErrorHandler:       HandleServerError,
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown() })
+
+	post := func(body string) (int, string, string) {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/api/upload", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(data)
+	}
+	if status, _, body := post(strings.Repeat("a", 64)); status != 200 || body != "stored" {
+		t.Errorf("body at the limit = %d %q, want the stub", status, body)
+	}
+	status, contentType, body := post(strings.Repeat("a", 65))
+	if status != fasthttp.StatusRequestEntityTooLarge || contentType != "application/json" || body != `{"error": "request body too large"}` {
+		t.Errorf("oversize body = %d %s %q, want a 413 JSON error", status, contentType, body)
+	}
+
+	// A small compressed body can still expand past the limit
+	ctx := newRequestCtx("POST", "/api/upload", "")
+	ctx.Request.SetBody(fasthttp.AppendGzipBytes(nil, []byte(strings.Repeat("a", 1000))))
+	ctx.Request.Header.SetContentEncoding("gzip")
+	HandleRequest(s, ctx)
+	if status, body := ctx.Response.StatusCode(), string(ctx.Response.Body()); status != fasthttp.StatusRequestEntityTooLarge || body != `{"error": "request body exceeds 64 bytes"}` {
+		t.Errorf("decompressed oversize body = %d %q, want a 413 JSON error", status, body)
+	}
+
+	// The body as sent is checked before any decoding
+	ctx = newRequestCtx("POST", "/api/upload", strings.Repeat("a", 65))
+	ctx.Request.Header.SetContentEncoding("gzip")
+	HandleRequest(s, ctx)
+	if status := ctx.Response.StatusCode(); status != fasthttp.StatusRequestEntityTooLarge {
+		t.Errorf("oversize compressed body = %d, want 413 before decoding", status)
+	}
+}
+
+func TestGzipResponses(t *testing.T) {
+	payload := strings.Repeat(`{"id": "report1", "title": "Revenue"},`, 50)
+	stubs := types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "GET", URL: "/gzip"}, Response: types.Response{Status: 200, Body: payload, Gzip: true}},
+		{Request: types.Request{Method: "GET", URL: "/plain"}, Response: types.Response{Status: 200, Body: payload}},
+		{Request: types.Request{Method: "GET", URL: "/small"}, Response: types.Response{Status: 200, Body: "ok", Gzip: true}},
+		{Request: types.Request{Method: "GET", URL: "/encoded"}, Response: types.Response{
+			Status: 200, Body: payload, Gzip: true, Headers: map[string]any{"Content-Encoding": "br"}}},
+	}}
+
+	get := func(s *types.Server, uri, acceptEncoding string) *fasthttp.RequestCtx {
+		ctx := newRequestCtx("GET", uri, "")
+		if acceptEncoding != "" {
+			ctx.Request.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		HandleRequest(s, ctx)
+		return ctx
+	}
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, stubs)
+
+	ctx := get(s, "/gzip", "deflate, gzip;q=0.8")
+	if enc := string(ctx.Response.Header.ContentEncoding()); enc != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", enc)
+	}
+	decoded, err := fasthttp.AppendGunzipBytes(nil, ctx.Response.Body())
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+	if string(decoded) != payload {
+		t.Errorf("round-trip body differs from the stub body")
+	}
+
+	for _, tt := range []struct {
+		name, uri, acceptEncoding, wantEncoding string
+	}{
+		{"client without Accept-Encoding", "/gzip", "", ""},
+		{"client refusing gzip", "/gzip", "gzip;q=0, br", ""},
+		{"already encoded body", "/encoded", "gzip", "br"},
+		{"stub without gzip flag", "/plain", "gzip", ""},
+	} {
+		ctx := get(s, tt.uri, tt.acceptEncoding)
+		if enc := string(ctx.Response.Header.ContentEncoding()); enc != tt.wantEncoding || string(ctx.Response.Body()) != payload {
+			t.Errorf("%s: Content-Encoding = %q, want %q with the uncompressed body", tt.name, enc, tt.wantEncoding)
+		}
+	}
+
+	// GZIP_RESPONSES_OVER applies to every stub, but only above the threshold
+	s.GzipOver = 16
+	if ctx := get(s, "/plain", "gzip"); string(ctx.Response.Header.ContentEncoding()) != "gzip" {
+		t.Errorf("global threshold did not compress a stub without the gzip flag")
+	}
+	if ctx := get(s, "/small", "gzip"); len(ctx.Response.Header.ContentEncoding()) != 0 {
+		t.Errorf("body below the threshold was compressed")
+	}
+}
+
+func TestStatusMessage(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "GET", URL: "/custom"}, Response: types.Response{Status: 419, StatusMessage: "Session Expired"}},
+		{Request: types.Request{Method: "GET", URL: "/default"}, Response: types.Response{Status: 404}},
+	}})
+	addr := startTestServer(t, s)
+
+	for path, want := range map[string]string{
+		"/custom":  "419 Session Expired",
+		"/default": "404 Not Found",
+	} {
+		resp, err := http.Get("http://" + addr + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.Status != want {
+			t.Errorf("GET %s: status = %q, want %q", path, resp.Status, want)
+		}
+	}
+}
+
+func TestRemoveResponseHeaders(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request: types.Request{Method: "GET", URL: "/recorded"},
+		Response: types.Response{
+			Status: 200,
+			Body:   "ok",
+			Headers: map[string]any{
+				"Set-Cookie":              []interface{}{"session=stale", "tracking=1"},
+				"Content-Security-Policy": "default-src 'none'",
+				"X-Kept":                  "yes",
+			},
+			RemoveHeaders: []string{"set-cookie", "CONTENT-SECURITY-POLICY"},
+		},
+	}}})
+
+	ctx := newRequestCtx("GET", "/recorded", "")
+	HandleRequest(s, ctx)
+
+	for _, name := range []string{"Set-Cookie", "Content-Security-Policy"} {
+		if got := ctx.Response.Header.Peek(name); got != nil {
+			t.Errorf("%s = %q, want it removed", name, got)
+		}
+	}
+	if got := string(ctx.Response.Header.Peek("X-Kept")); got != "yes" {
+		t.Errorf("X-Kept = %q, want %q", got, "yes")
+	}
+	if got := string(ctx.Response.Body()); got != "ok" {
+		t.Errorf("body = %q, want %q", got, "ok")
+	}
+}
+
+func TestWatchMappingsDir(t *testing.T) {
+	dir := t.TempDir()
+	writeMapping := func(name, url, body string) {
+		t.Helper()
+		data := fmt.Sprintf(`{"mappings": [{"request": {"method": "GET", "url": %q}, "response": {"status": 200, "body": %q}}]}`, url, body)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewServer("", "/", false, nil)
+	writeMapping("a.json", "/a", "first")
+	LoadMappingsDir(s, dir)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	ticks := make(chan time.Time)
+	go watchMappingsDir(s, dir, ticks, stop)
+	// The watcher takes its initial snapshot before it takes the first tick
+	ticks <- time.Now()
+
+	waitFor := func(uri string, wantStatus int, wantBody string) {
+		t.Helper()
+		// One tick sees the change, the next reloads, and the third is only taken
+		// once that reload is done
+		for range 3 {
+			ticks <- time.Now()
+		}
+		if status, body := serve(s, "GET", uri, ""); status != wantStatus || (wantBody != "" && body != wantBody) {
+			t.Fatalf("GET %s: got %d %q, want %d %q", uri, status, body, wantStatus, wantBody)
+		}
+	}
+
+	writeMapping("b.json", "/b", "added")
+	waitFor("/b", 200, "added")
+
+	writeMapping("a.json", "/a", "edited-and-longer")
+	waitFor("/a", 200, "edited-and-longer")
+
+	if err := os.Remove(filepath.Join(dir, "b.json")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("/b", 404, "")
+
+	s.Mu.RLock()
+	n := len(s.Mappings)
+	s.Mu.RUnlock()
+	if n != 1 {
+		t.Errorf("got %d mappings after reloads, want 1 (reload must replace, not append)", n)
+	}
+}
+
+func TestReloadMappingsDirKeepsRuntimeMappings(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.json")
+	writeFile := func(body string) {
+		t.Helper()
+		data := fmt.Sprintf(`{"mappings": [{"request": {"method": "GET", "url": "/a"}, "response": {"status": 200, "body": %q}}]}`, body)
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("first")
+
+	s := NewServer("", "/", false, nil)
+	LoadMappingsDir(s, dir)
+	for _, body := range []string{
+		`{"request": {"method": "GET", "url": "/runtime"}, "response": {"status": 200, "body": "runtime"}}`,
+		`{"persistent": true, "request": {"method": "GET", "url": "/pinned"}, "response": {"status": 200, "body": "pinned"}}`,
+	} {
+		if status, _ := serve(s, "POST", "/__admin/mappings", body); status != 201 {
+			t.Fatalf("POST mapping = %d", status)
+		}
+	}
+
+	writeFile("edited")
+	if err := ReloadMappingsDir(s, dir); err != nil {
+		t.Fatal(err)
+	}
+	for uri, want := range map[string]string{"/a": "edited", "/runtime": "runtime", "/pinned": "pinned"} {
+		if status, body := serve(s, "GET", uri, ""); status != 200 || body != want {
+			t.Errorf("after reload, %s = %d %q, want 200 %q", uri, status, body, want)
+		}
+	}
+
+	// Saved mappings come back from their files, so the runtime copies give way
+	if _, err := SaveMappings(s, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReloadMappingsDir(s, dir); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Mappings); n != 3 {
+		t.Errorf("got %d mappings after saving and reloading, want 3", n)
+	}
+}
+
+func TestLoadMappingsDirNested(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"root.json":                 "/root",
+		"auth/login.json":           "/auth/login",
+		"workspaces/list.json":      "/workspaces",
+		"workspaces/demo/item.json": "/workspaces/demo",
+	}
+	for name, url := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		data := fmt.Sprintf(`{"mappings": [{"request": {"method": "GET", "url": %q}, "response": {"status": 200, "body": %q}}]}`, url, name)
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "auth", "README.md"), []byte("not a mapping"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("", "/", false, nil)
+	LoadMappingsDir(s, dir)
+
+	var loaded []string
+	for _, m := range s.Mappings {
+		loaded = append(loaded, m.Request.URL)
+	}
+	want := []string{"/auth/login", "/root", "/workspaces/demo", "/workspaces"}
+	if strings.Join(loaded, " ") != strings.Join(want, " ") {
+		t.Errorf("loaded %v, want %v in sorted path order", loaded, want)
+	}
+	for name, url := range files {
+		if status, body := serve(s, "GET", url, ""); status != 200 || body != name {
+			t.Errorf("GET %s: got %d %q, want 200 %q", url, status, body, name)
+		}
+	}
+}
+
+func TestLoadMappingsDirSingleMappingFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// WireMock's one-stub-per-file layout
+		"single.json": `{"id": "8c5db8b0-2db4-4ad7-a99f-38c9b00da3f7", "request": {"method": "GET", "url": "/single"}, "response": {"status": 200, "body": "single"}}`,
+		"envelope.json": `{"mappings": [
+			{"request": {"method": "GET", "url": "/one"}, "response": {"status": 200, "body": "one"}},
+			{"request": {"method": "GET", "url": "/two"}, "response": {"status": 200, "body": "two"}}
+		]}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewServer("", "/", false, nil)
+	LoadMappingsDir(s, dir)
+	if len(s.Mappings) != 3 {
+		t.Fatalf("loaded %d mappings, want 3", len(s.Mappings))
+	}
+	for _, url := range []string{"/single", "/one", "/two"} {
+		if status, body := serve(s, "GET", url, ""); status != 200 || body != url[1:] {
+			t.Errorf("GET %s: got %d %q", url, status, body)
+		}
+	}
+	if m, ok := GetMapping(s, "8c5db8b0-2db4-4ad7-a99f-38c9b00da3f7"); !ok || m.Request.URL != "/single" {
+		t.Errorf("single-file mapping id not preserved")
+	}
+
+	// The import endpoint accepts a single mapping as well
+	if status, _ := serve(s, "POST", "/__admin/mappings/import", `{"request": {"method": "GET", "url": "/imported"}, "response": {"status": 204}}`); status != 200 {
+		t.Fatalf("import returned %d", status)
+	}
+	if status, _ := serve(s, "GET", "/imported", ""); status != 204 {
+		t.Errorf("GET /imported: got %d, want 204", status)
+	}
+}
+
+func TestParseYAMLMappings(t *testing.T) {
+	jsonStub := `{
+		"name": "create_export",
+		"priority": 1,
+		"request": {
+			"method": "POST",
+			"urlPath": "/api/exports",
+			"headers": {"Content-Type": {"contains": "json"}},
+			"bodyPatterns": [{"equalToJson": {"format": "csv"}, "ignoreExtraElements": true}]
+		},
+		"response": {
+			"status": 201,
+			"headers": {"Content-Type": "application/json"},
+			"jsonBody": {"id": "export1", "created": "2026-03-10", "sizes": [1, 2.5], "done": false, "error": null}
+		}
+	}`
+	yamlStub := `
+name: create_export
+priority: 1
+request:
+  method: POST
+  urlPath: /api/exports
+  headers:
+    Content-Type:
+      contains: json
+  bodyPatterns:
+    - equalToJson:
+        format: csv
+      ignoreExtraElements: true
+response:
+  status: 201
+  headers:
+    Content-Type: application/json
+  jsonBody:
+    id: export1
+    created: 2026-03-10
+    sizes: [1, 2.5]
+    done: false
+    error: null
+`
+	fromJSON, err := parseMappings([]byte(jsonStub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := parseYAMLMappings([]byte(yamlStub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(fromJSON)
+	got, _ := json.Marshal(fromYAML)
+	if !bytes.Equal(got, want) {
+		t.Errorf("YAML stub differs from its JSON equivalent:\n got %s\nwant %s", got, want)
+	}
+
+	multi := `
+request: {method: GET, url: /one}
+response: {status: 200, body: one}
+---
+mappings:
+  - request: {method: GET, url: /two}
+    response: {status: 200, body: two}
+  - request: {method: GET, url: /three}
+    response: {status: 200, body: three}
+---
+`
+	wm, err := parseYAMLMappings([]byte(multi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wm.Mappings) != 3 || wm.Mappings[0].Request.URL != "/one" || wm.Mappings[2].Request.URL != "/three" {
+		t.Errorf("multi-document YAML loaded %+v", wm.Mappings)
+	}
+
+	if _, err := parseYAMLMappings([]byte("request: [unclosed")); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+}
+
+func TestLoadMappingsDirYAML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.yaml": "request: {method: GET, url: /yaml}\nresponse: {status: 200, body: yaml}\n",
+		"b.yml":  "request: {method: GET, url: /yml}\nresponse: {status: 200, body: yml}\n",
+		"c.json": `{"request": {"method": "GET", "url": "/json"}, "response": {"status": 200, "body": "json"}}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewServer("", "/", false, nil)
+	LoadMappingsDir(s, dir)
+	for _, url := range []string{"/yaml", "/yml", "/json"} {
+		if status, body := serve(s, "GET", url, ""); status != 200 || body != url[1:] {
+			t.Errorf("GET %s: got %d %q", url, status, body)
+		}
+	}
+
+	ctx := newRequestCtx("POST", "/__admin/mappings/import", "request: {method: GET, url: /imported}\nresponse: {status: 204}\n")
+	ctx.Request.Header.SetContentType("application/yaml")
+	HandleRequest(s, ctx)
+	if ctx.Response.StatusCode() != 200 {
+		t.Fatalf("YAML import returned %d: %s", ctx.Response.StatusCode(), ctx.Response.Body())
+	}
+	if status, _ := serve(s, "GET", "/imported", ""); status != 204 {
+		t.Errorf("GET /imported: got %d, want 204", status)
+	}
+}
+
+func TestValidateMappingsDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"valid.json": `{"name": "ok", "request": {"method": "GET", "urlPattern": "/api/.*"}, "response": {"status": 200, "body": "ok"}}`,
+		"nested/broken.yaml": `mappings:
+  - name: broken export
+    request:
+      method: POST
+      url: /export
+      bodyPatterns: [{matches: "(unclosed"}]
+    response: {status: 200, bodyFile: export.json}
+`,
+		"unparsable.json": `{"request": `,
+		"users.json": `{"mappings": [
+			{"name": "users copy", "request": {"method": "GET", "url": "/users"}, "response": {"status": 200}},
+			{"name": "users", "request": {"method": "GET", "url": "/users"}, "response": {"status": 200}}
+		]}`,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, checked, err := ValidateMappingsDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 4 {
+		t.Errorf("checked %d mappings, want 4", checked)
+	}
+	want := []MappingProblem{
+		{File: filepath.Join(dir, "nested/broken.yaml"), Message: `document 1: json: unknown field "bodyFile"`},
+		{File: filepath.Join(dir, "nested/broken.yaml"), Mapping: "broken export", Message: "bodyPatterns[0].matches: invalid regex \"(unclosed\": error parsing regexp: missing closing ): `(unclosed`"},
+		{File: filepath.Join(dir, "unparsable.json"), Message: "could not parse: unexpected end of JSON input"},
+		{File: filepath.Join(dir, "users.json"), Mapping: "users copy", Message: "shadowed by users in " + filepath.Join(dir, "users.json") + ": both match the same requests"},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("problems =\n%+v\nwant\n%+v", problems, want)
+	}
+
+	if _, _, err := ValidateMappingsDir(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestShadowedMappingsWarning(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewServer("", "/", false, nil)
+	stub := func(name string) types.Mapping {
+		return types.Mapping{
+			Name:     name,
+			Request:  types.Request{Method: "GET", URLPath: "/api/users"},
+			Response: types.Response{Status: 200, Body: name},
+		}
+	}
+	other := stub("other user")
+	other.Request.QueryParameters = map[string]types.QueryParamMatcher{"id": {EqualTo: "2"}}
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{stub("users b"), other}})
+	if strings.Contains(logged.String(), "shadows") {
+		t.Fatalf("unexpected warning for distinct stubs: %s", logged.String())
+	}
+
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{stub("users a")}})
+	want := `Warning: mapping "users a" shadows "users b": both match GET /api/users with the same matchers`
+	if !strings.Contains(logged.String(), want) {
+		t.Errorf("log = %q, want it to contain %q", logged.String(), want)
+	}
+	if _, body := serve(s, "GET", "/api/users", ""); body != "users a" {
+		t.Errorf("served %q, want the winning stub", body)
+	}
+
+	logged.Reset()
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{other}})
+	if strings.Count(logged.String(), "shadows") != 1 {
+		t.Errorf("a later load should only warn about its own mappings, got %q", logged.String())
+	}
+}
+
+func TestScenariosAdmin(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	wm := twoStepScenario()
+	wm.Mappings = append(wm.Mappings, types.Mapping{
+		ScenarioName:          "export flow",
+		RequiredScenarioState: "exporting",
+		Request:               types.Request{Method: "GET", URL: "/export"},
+		Response:              types.Response{Status: 200, Body: "ready"},
+	})
+	LoadMappings(s, wm)
+
+	scenarios := func() []types.Scenario {
+		t.Helper()
+		_, body := serve(s, "GET", "/__admin/scenarios", "")
+		var resp struct {
+			Scenarios []types.Scenario `json:"scenarios"`
+		}
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatalf("decode %s: %v", body, err)
+		}
+		return resp.Scenarios
+	}
+
+	got := scenarios()
+	if len(got) != 2 || got[0].Name != "api_status" || got[1].Name != "export flow" {
+		t.Fatalf("scenarios = %+v", got)
+	}
+	if got[0].State != types.ScenarioStarted || strings.Join(got[0].PossibleStates, ",") != "Started,state_1" {
+		t.Errorf("api_status = %+v, want state Started with possible states Started,state_1", got[0])
+	}
+
+	serve(s, "GET", "/api/status", "")
+	if got := scenarios(); got[0].State != "state_1" {
+		t.Errorf("after one call, api_status state = %q, want state_1", got[0].State)
+	}
+
+	// Force a mid-scenario starting point
+	if status, _ := serve(s, "PUT", "/__admin/scenarios/export%20flow/state", `{"state": "exporting"}`); status != 200 {
+		t.Fatalf("PUT state returned %d", status)
+	}
+	if status, body := serve(s, "GET", "/export", ""); status != 200 || body != "ready" {
+		t.Errorf("GET /export after forcing state: got %d %q", status, body)
+	}
+
+	if status, _ := serve(s, "PUT", "/__admin/scenarios/api_status/state", `{"state": "bogus"}`); status != 400 {
+		t.Errorf("unknown state returned %d, want 400", status)
+	}
+	if status, _ := serve(s, "PUT", "/__admin/scenarios/missing/state", `{"state": "Started"}`); status != 404 {
+		t.Errorf("unknown scenario returned %d, want 404", status)
+	}
+	if status, _ := serve(s, "PUT", "/__admin/scenarios/api_status/state", ""); status != 200 {
+		t.Errorf("empty body returned %d, want 200", status)
+	}
+	if got := scenarios(); got[0].State != types.ScenarioStarted {
+		t.Errorf("empty body should reset to Started, got %q", got[0].State)
+	}
+}
+
+func TestSaveMappings(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	if status, _ := serve(s, "POST", "/__admin/mappings/save", ""); status != 400 {
+		t.Errorf("save without MAPPINGS_DIR returned %d, want 400", status)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "existing.json"), []byte(`{"request": {"method": "GET", "url": "/existing"}, "response": {"status": 200}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.MappingsDir = dir
+	LoadMappingsDir(s, dir)
+
+	for _, stub := range []string{
+		`{"request": {"method": "GET", "urlPath": "/api/workspaces"}, "response": {"status": 200, "jsonBody": {"data": []}}}`,
+		`{"name": "Create Export", "request": {"method": "POST", "urlPattern": "/api/exports/.*"}, "response": {"status": 201}}`,
+	} {
+		if status, _ := serve(s, "POST", "/__admin/mappings", stub); status != 201 {
+			t.Fatalf("POST /__admin/mappings returned %d", status)
+		}
+	}
+
+	status, body := serve(s, "POST", "/__admin/mappings/save", "")
+	if status != 200 {
+		t.Fatalf("save returned %d: %s", status, body)
+	}
+	var saved struct {
+		Files []string `json:"files"`
+	}
+	if err := json.Unmarshal([]byte(body), &saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Files) != 2 {
+		t.Fatalf("saved %v, want the 2 runtime mappings only", saved.Files)
+	}
+	for _, f := range saved.Files {
+		if base := filepath.Base(f); !strings.HasPrefix(base, "api_workspaces-") && !strings.HasPrefix(base, "create_export-") {
+			t.Errorf("unexpected file name %s", base)
+		}
+	}
+
+	// Saving again overwrites the same files
+	serve(s, "POST", "/__admin/mappings/save", "")
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 3 {
+		t.Errorf("directory has %d files after saving twice, want 3", len(entries))
+	}
+
+	before, _ := json.Marshal(s.Mappings)
+	ClearMappings(s)
+	LoadMappingsDir(s, dir)
+	after, _ := json.Marshal(s.Mappings)
+	if len(s.Mappings) != 3 {
+		t.Fatalf("reloaded %d mappings, want 3", len(s.Mappings))
+	}
+	var beforeList, afterList []json.RawMessage
+	json.Unmarshal(before, &beforeList)
+	json.Unmarshal(after, &afterList)
+	for _, b := range beforeList {
+		found := false
+		for _, a := range afterList {
+			if bytes.Equal(a, b) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("mapping %s did not survive save and reload", b)
+		}
+	}
+}
+
+func TestResetKeepsPersistentMappings(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	load := func() {
+		LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+			{Persistent: true, Request: types.Request{Method: "GET", URL: "/baseline"}, Response: types.Response{Status: 200, Body: "always"}},
+			{Request: types.Request{Method: "GET", URL: "/per-test"}, Response: types.Response{Status: 200, Body: "dynamic"}},
+		}})
+	}
+
+	for _, reset := range [][2]string{
+		{"DELETE", "/__admin/mappings"},
+		{"POST", "/__admin/reset"},
+		{"POST", "/__admin/mappings/reset"},
+	} {
+		ClearMappings(s)
+		load()
+		if status, _ := serve(s, reset[0], reset[1], ""); status != 200 {
+			t.Fatalf("%s %s = %d", reset[0], reset[1], status)
+		}
+		if status, body := serve(s, "GET", "/baseline", ""); status != 200 || body != "always" {
+			t.Errorf("after %s %s: /baseline = %d %q, want the persistent stub", reset[0], reset[1], status, body)
+		}
+		if status, _ := serve(s, "GET", "/per-test", ""); status != 404 {
+			t.Errorf("after %s %s: /per-test = %d, want 404", reset[0], reset[1], status)
+		}
+
+		serve(s, reset[0], reset[1]+"?includePersistent=true", "")
+		if len(s.Mappings) != 0 {
+			t.Errorf("after forced %s %s: %d mappings left, want 0", reset[0], reset[1], len(s.Mappings))
+		}
+	}
+}
+
+func TestResetToDefault(t *testing.T) {
+	dir := t.TempDir()
+	data := `{"mappings": [{"request": {"method": "GET", "url": "/baseline"}, "response": {"status": 200, "body": "fixture"}}]}`
+	if err := os.WriteFile(filepath.Join(dir, "baseline.json"), []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", "/", false, nil)
+	s.MappingsDir = dir
+	LoadMappingsDir(s, dir)
+
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{Request: types.Request{Method: "GET", URL: "/per-test"}, Response: types.Response{Status: 200}},
+		{Persistent: true, Request: types.Request{Method: "GET", URL: "/pinned"}, Response: types.Response{Status: 200}},
+	}})
+	serve(s, "GET", "/per-test", "")
+
+	if status, _ := serve(s, "POST", "/__admin/reset-to-default", ""); status != 200 {
+		t.Fatalf("reset-to-default = %d", status)
+	}
+	if len(s.Mappings) != 1 || s.Mappings[0].Request.URL != "/baseline" {
+		t.Fatalf("mappings after reset = %+v, want only the startup stub", s.Mappings)
+	}
+	if status, body := serve(s, "GET", "/baseline", ""); status != 200 || body != "fixture" {
+		t.Errorf("/baseline = %d %q, want the startup stub", status, body)
+	}
+	if events := journalSnapshot(s); len(events) != 1 {
+		t.Errorf("journal has %d events after reset, want only the request since", len(events))
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := serve(s, "POST", "/__admin/reset-to-default", ""); status != 500 || len(s.Mappings) != 1 {
+		t.Errorf("reset with a missing MAPPINGS_DIR = %d with %d mappings, want 500 and no change", status, len(s.Mappings))
+	}
+
+	empty := NewServer("", "/", false, nil)
+	LoadMappings(empty, types.WiremockMappings{Mappings: []types.Mapping{{Request: types.Request{Method: "GET", URL: "/x"}}}})
+	if status, _ := serve(empty, "POST", "/__admin/reset-to-default", ""); status != 200 || len(empty.Mappings) != 0 {
+		t.Errorf("without MAPPINGS_DIR: %d with %d mappings, want 200 and none", status, len(empty.Mappings))
+	}
+}
+
+func TestMappingsByMetadata(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{
+		{ID: "billing", Metadata: json.RawMessage(`{"team": "billing", "tags": ["invoices"]}`),
+			Request: types.Request{Method: "GET", URL: "/invoices"}, Response: types.Response{Status: 200}},
+		{ID: "search", Metadata: json.RawMessage(`{"owner": "search"}`),
+			Request: types.Request{Method: "GET", URL: "/search"}, Response: types.Response{Status: 200}},
+		{ID: "untagged", Request: types.Request{Method: "GET", URL: "/plain"}, Response: types.Response{Status: 200}},
+	}})
+
+	find := func(pattern string) []string {
+		t.Helper()
+		status, body := serve(s, "POST", "/__admin/mappings/find-by-metadata", pattern)
+		if status != 200 {
+			t.Fatalf("find-by-metadata returned %d: %s", status, body)
+		}
+		var resp struct {
+			Mappings []types.Mapping `json:"mappings"`
+			Meta     struct {
+				Total int `json:"total"`
+			} `json:"meta"`
+		}
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, m := range resp.Mappings {
+			ids = append(ids, m.ID)
+		}
+		if resp.Meta.Total != len(ids) {
+			t.Errorf("meta.total = %d, want %d", resp.Meta.Total, len(ids))
+		}
+		return ids
+	}
+
+	if ids := find(`{"matchesJsonPath": "$.team"}`); strings.Join(ids, ",") != "billing" {
+		t.Errorf("matchesJsonPath $.team found %v, want [billing]", ids)
+	}
+	if ids := find(`{"equalToJson": {"owner": "search"}}`); strings.Join(ids, ",") != "search" {
+		t.Errorf("equalToJson found %v, want [search]", ids)
+	}
+	if ids := find(`{"matchesJsonPath": "$.missing"}`); len(ids) != 0 {
+		t.Errorf("expected no matches, got %v", ids)
+	}
+	if status, _ := serve(s, "POST", "/__admin/mappings/find-by-metadata", `not json`); status != 400 {
+		t.Errorf("invalid pattern returned %d, want 400", status)
+	}
+
+	if status, _ := serve(s, "DELETE", "/__admin/mappings/find-by-metadata", `{"matchesJsonPath": "$.tags[0]"}`); status != 200 {
+		t.Fatalf("remove by metadata returned %d", status)
+	}
+	if status, _ := serve(s, "GET", "/invoices", ""); status != 404 {
+		t.Errorf("tagged stub still served after removal")
+	}
+	if len(s.Mappings) != 2 {
+		t.Errorf("%d mappings left, want 2", len(s.Mappings))
+	}
+}
+
+func TestServeTLS(t *testing.T) {
+	certPEM, keyPEM, err := fasthttp.GenerateTestCertificate("localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/secure"},
+		Response: types.Response{Status: 200, Body: "over tls"},
+	}}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) { HandleRequest(s, ctx) }}
+	go Serve(srv, ln, certFile, keyFile)
+	t.Cleanup(func() { srv.Shutdown() })
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(certPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/secure")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || string(body) != "over tls" || resp.TLS == nil {
+		t.Errorf("got %d %q (TLS: %v)", resp.StatusCode, body, resp.TLS != nil)
+	}
+}
+
+func TestApplyTuningReadTimeout(t *testing.T) {
+	s := NewServer("", "/", false, nil)
+	LoadMappings(s, types.WiremockMappings{Mappings: []types.Mapping{{
+		Request:  types.Request{Method: "GET", URL: "/ping"},
+		Response: types.Response{Status: 200, Body: "pong"},
+	}}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) { HandleRequest(s, ctx) }}
+	ApplyTuning(srv, Tuning{ReadTimeout: 100 * time.Millisecond, DisableKeepalive: true})
+	if srv.ReadTimeout != 100*time.Millisecond || !srv.DisableKeepalive || srv.Concurrency != 0 {
+		t.Fatalf("tuning not applied: %+v", srv)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown() })
+
+	// A client that sends its headers in time is served
+	if status, body := rawRequest(t, ln.Addr().String(), "GET /ping HTTP/1.1\r\nHost: mock\r\n\r\n", 0); !strings.Contains(status, " 200 ") || body != "pong" {
+		t.Errorf("prompt client got %q %q", status, body)
+	}
+
+	// One that stalls halfway through the headers is cut off
+	if status, body := rawRequest(t, ln.Addr().String(), "GET /ping HTTP/1.1\r\nHost: mock\r\n", 300*time.Millisecond); strings.Contains(status, " 200 ") {
+		t.Errorf("slow client was served: %q %q", status, body)
+	}
+}
+
+// rawRequest writes req over a fresh connection, pauses before finishing the headers
+// when stall is set, and returns the status line and body of the response, if any.
+func rawRequest(t *testing.T, addr, req string, stall time.Duration) (string, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(req))
+	if stall > 0 {
+		time.Sleep(stall)
+		conn.Write([]byte("\r\n"))
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, _ := io.ReadAll(conn)
+	status, rest, _ := strings.Cut(string(data), "\r\n")
+	_, body, _ := strings.Cut(rest, "\r\n\r\n")
+	return status, body
+}
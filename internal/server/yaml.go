@@ -0,0 +1,115 @@
+// (C) 2025 GoodData Corporation
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goodmock/internal/types"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// isYAMLFile reports whether a mapping file should be parsed as YAML.
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// isYAMLContentType reports whether an import request body is YAML.
+func isYAMLContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// parseYAMLMappings decodes YAML mappings. Each document of a multi-document stream
+// (separated by ---) may be a single mapping or a mappings envelope, like a JSON file.
+// Documents are converted to JSON first so the same struct tags apply.
+func parseYAMLMappings(data []byte) (types.WiremockMappings, error) {
+	return decodeYAMLMappings(data, false)
+}
+
+// decodeYAMLMappings is parseYAMLMappings, optionally rejecting fields GoodMock doesn't know.
+func decodeYAMLMappings(data []byte, strict bool) (types.WiremockMappings, error) {
+	var wm types.WiremockMappings
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	for doc := 1; ; doc++ {
+		var node yaml.Node
+		if err := dec.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				return wm, nil
+			}
+			return types.WiremockMappings{}, fmt.Errorf("document %d: %w", doc, err)
+		}
+		value, err := yamlNodeValue(&node)
+		if err != nil {
+			return types.WiremockMappings{}, fmt.Errorf("document %d: %w", doc, err)
+		}
+		if value == nil {
+			continue // empty document, e.g. a trailing ---
+		}
+		jsonData, err := json.Marshal(value)
+		if err != nil {
+			return types.WiremockMappings{}, fmt.Errorf("document %d: %w", doc, err)
+		}
+		parsed, err := decodeMappings(jsonData, strict)
+		if err != nil {
+			return types.WiremockMappings{}, fmt.Errorf("document %d: %w", doc, err)
+		}
+		wm.Mappings = append(wm.Mappings, parsed.Mappings...)
+	}
+}
+
+// yamlNodeValue converts a YAML node into JSON-compatible values. Timestamps stay
+// strings so an unquoted date in a body isn't reformatted.
+func yamlNodeValue(node *yaml.Node) (any, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlNodeValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlNodeValue(node.Alias)
+	case yaml.MappingNode:
+		obj := make(map[string]any, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, err := yamlNodeValue(node.Content[i])
+			if err != nil {
+				return nil, err
+			}
+			value, err := yamlNodeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj[fmt.Sprint(key)] = value
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(node.Content))
+		for _, child := range node.Content {
+			value, err := yamlNodeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	default:
+		if node.Tag == "!!timestamp" {
+			return node.Value, nil
+		}
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
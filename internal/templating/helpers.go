@@ -0,0 +1,209 @@
+// (C) 2025 GoodData Corporation
+package templating
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// helperArgRe matches a helper argument: key='value', key="value" or key=value.
+var helperArgRe = regexp.MustCompile(`(\w+)=(?:'([^']*)'|"([^"]*)"|(\S+))`)
+
+// parseHelper splits an expression such as randomValue type='UUID' into the helper
+// name and its arguments. It fails if anything besides key=value arguments follows the name.
+func parseHelper(expr string) (string, map[string]string, bool) {
+	name, rest, _ := strings.Cut(expr, " ")
+	args := make(map[string]string)
+	for _, m := range helperArgRe.FindAllStringSubmatch(rest, -1) {
+		args[m[1]] = m[2] + m[3] + m[4]
+	}
+	if strings.TrimSpace(helperArgRe.ReplaceAllString(rest, "")) != "" {
+		return "", nil, false
+	}
+	return name, args, true
+}
+
+// resolveHelper evaluates the randomValue and now helpers.
+func resolveHelper(expr string, data *RequestData) (string, bool) {
+	name, args, ok := parseHelper(expr)
+	if !ok {
+		return "", false
+	}
+	switch name {
+	case "randomValue":
+		return randomValue(args, data)
+	case "now":
+		return now(args, data)
+	}
+	return "", false
+}
+
+const (
+	digits     = "0123456789"
+	hexDigits  = "0123456789abcdef"
+	letters    = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	alphaDigit = letters + digits
+)
+
+// randomValue renders {{randomValue type='UUID'}} and the fixed-length types
+// NUMBER, ALPHABETIC, ALPHANUMERIC and HEXADECIMAL (length defaults to 10).
+func randomValue(args map[string]string, data *RequestData) (string, bool) {
+	intn := data.Random
+	if intn == nil {
+		intn = rand.Intn
+	}
+	length := 10
+	if v, ok := args["length"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return "", false
+		}
+		length = n
+	}
+
+	var alphabet string
+	switch strings.ToUpper(args["type"]) {
+	case "UUID":
+		var b [16]byte
+		for i := range b {
+			b[i] = byte(intn(256))
+		}
+		b[6] = b[6]&0x0f | 0x40 // version 4
+		b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+		return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), true
+	case "NUMBER", "NUMERIC":
+		alphabet = digits
+	case "ALPHABETIC":
+		alphabet = letters
+	case "ALPHANUMERIC":
+		alphabet = alphaDigit
+	case "HEXADECIMAL":
+		alphabet = hexDigits
+	default:
+		return "", false
+	}
+	out := make([]byte, length)
+	for i := range out {
+		out[i] = alphabet[intn(len(alphabet))]
+	}
+	return string(out), true
+}
+
+// now renders {{now}} in UTC, shifted by offset (e.g. '1 days', '-3 hours') and
+// formatted with a Java-style pattern such as 'yyyy-MM-dd', or 'epoch' / 'unix' for
+// milliseconds / seconds since the epoch. Without a format it renders ISO 8601.
+func now(args map[string]string, data *RequestData) (string, bool) {
+	t := data.Now
+	if t.IsZero() {
+		t = time.Now()
+	}
+	t = t.UTC()
+	if offset, ok := args["offset"]; ok {
+		shifted, ok := applyOffset(t, offset)
+		if !ok {
+			return "", false
+		}
+		t = shifted
+	}
+
+	switch format := args["format"]; format {
+	case "":
+		return t.Format("2006-01-02T15:04:05Z"), true
+	case "epoch":
+		return strconv.FormatInt(t.UnixMilli(), 10), true
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), true
+	default:
+		return formatJavaDate(t, format), true
+	}
+}
+
+// applyOffset shifts t by an offset like "1 days" or "-30 minutes".
+func applyOffset(t time.Time, offset string) (time.Time, bool) {
+	fields := strings.Fields(offset)
+	if len(fields) != 2 {
+		return t, false
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return t, false
+	}
+	switch strings.TrimSuffix(strings.ToLower(fields[1]), "s") {
+	case "second":
+		return t.Add(time.Duration(n) * time.Second), true
+	case "minute":
+		return t.Add(time.Duration(n) * time.Minute), true
+	case "hour":
+		return t.Add(time.Duration(n) * time.Hour), true
+	case "day":
+		return t.AddDate(0, 0, n), true
+	case "week":
+		return t.AddDate(0, 0, 7*n), true
+	case "month":
+		return t.AddDate(0, n, 0), true
+	case "year":
+		return t.AddDate(n, 0, 0), true
+	}
+	return t, false
+}
+
+// javaLayoutTokens maps Java date format tokens to Go layout elements, longest first.
+var javaLayoutTokens = []struct{ java, goLayout string }{
+	{"yyyy", "2006"}, {"yy", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"}, {"M", "1"},
+	{"dd", "02"}, {"d", "2"},
+	{"EEEE", "Monday"}, {"EEE", "Mon"},
+	{"HH", "15"}, {"hh", "03"}, {"h", "3"},
+	{"mm", "04"}, {"ss", "05"}, {"SSS", "000"},
+	{"a", "PM"}, {"XXX", "Z07:00"}, {"Z", "-0700"},
+}
+
+// formatJavaDate formats t with a Java date format such as yyyy-MM-dd'T'HH:mm:ss.
+// Text inside single quotes is copied literally; two quotes in a row stand for one.
+// Each token is formatted on its own, so literal text like 'at 1' is never read as
+// a Go layout element.
+func formatJavaDate(t time.Time, format string) string {
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		if format[i] == '\'' {
+			i++
+			if i < len(format) && format[i] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			for i < len(format) {
+				if format[i] == '\'' {
+					if i+1 < len(format) && format[i+1] == '\'' {
+						b.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteByte(format[i])
+				i++
+			}
+			continue
+		}
+		matched := false
+		for _, tok := range javaLayoutTokens {
+			if strings.HasPrefix(format[i:], tok.java) {
+				b.WriteString(t.Format(tok.goLayout))
+				i += len(tok.java)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(format[i])
+			i++
+		}
+	}
+	return b.String()
+}
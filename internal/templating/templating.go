@@ -0,0 +1,191 @@
+// (C) 2025 GoodData Corporation
+package templating
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestData holds the request values available to response templates.
+type RequestData struct {
+	Method        string
+	URL           string // raw URI (path + query string)
+	Path          string
+	PathSegments  []string
+	PathVariables map[string]string   // from urlPathTemplate, or named urlPattern groups
+	URLGroups     []string            // urlPattern/urlPathPattern capture groups; [0] is the whole match
+	Query         map[string][]string // query parameter name -> values
+	Headers       map[string][]string // lower-cased header name -> values
+	Body          string
+	Parameters    map[string]any  // the stub's transformerParameters
+	Now           time.Time       // clock for {{now}}; zero means time.Now
+	Random        func(n int) int // source for {{randomValue}}, returning [0, n); nil means math/rand
+}
+
+// placeholderRe matches Handlebars-style {{ expression }} placeholders.
+var placeholderRe = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// Render substitutes {{request.*}} and {{parameters.*}} placeholders and the randomValue
+// and now helpers in s.
+// Placeholders that can't be resolved are left untouched.
+func Render(s string, data *RequestData) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	return placeholderRe.ReplaceAllStringFunc(s, func(placeholder string) string {
+		expr := placeholderRe.FindStringSubmatch(placeholder)[1]
+		if value, ok := resolve(expr, data); ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
+// RenderJSON renders placeholders inside every string value of a decoded JSON document.
+// Keys and non-string values are left as-is, so the result always stays valid JSON.
+func RenderJSON(v any, data *RequestData) any {
+	switch val := v.(type) {
+	case string:
+		return Render(val, data)
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, child := range val {
+			out[k] = RenderJSON(child, data)
+		}
+		return out
+	case []any:
+		out := make([]any, len(val))
+		for i, child := range val {
+			out[i] = RenderJSON(child, data)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// resolve evaluates a single placeholder expression such as request.query.id.
+func resolve(expr string, data *RequestData) (string, bool) {
+	parts := strings.Split(expr, ".")
+	if len(parts) >= 2 && parts[0] == "parameters" {
+		return lookupParameter(data.Parameters, parts[1:])
+	}
+	if len(parts) < 2 || parts[0] != "request" {
+		return resolveHelper(expr, data)
+	}
+
+	switch parts[1] {
+	case "method":
+		return data.Method, len(parts) == 2
+	case "url":
+		return data.URL, len(parts) == 2
+	case "body":
+		return data.Body, len(parts) == 2
+	case "path":
+		if len(parts) == 2 {
+			return data.Path, true
+		}
+		if len(parts) != 3 {
+			return "", false
+		}
+		if idx, ok := parseIndex(parts[2]); ok {
+			return indexOf(data.PathSegments, idx)
+		}
+		value, ok := data.PathVariables[parts[2]]
+		return value, ok
+	case "pathSegments":
+		if len(parts) != 3 {
+			return "", false
+		}
+		if idx, ok := parseIndex(parts[2]); ok {
+			return indexOf(data.PathSegments, idx)
+		}
+		return "", false
+	case "groups":
+		if len(parts) != 3 {
+			return "", false
+		}
+		if idx, ok := parseIndex(parts[2]); ok {
+			return indexOf(data.URLGroups, idx)
+		}
+		value, ok := data.PathVariables[parts[2]]
+		return value, ok
+	case "query":
+		return lookupMulti(data.Query, parts[2:], false)
+	case "headers":
+		return lookupMulti(data.Headers, parts[2:], true)
+	}
+	return "", false
+}
+
+// lookupMulti resolves name or name.[n] against a multi-value map. Header names
+// may contain dots of their own, so a trailing [n] index is split off first.
+func lookupMulti(values map[string][]string, parts []string, lowerKey bool) (string, bool) {
+	if len(parts) == 0 {
+		return "", false
+	}
+	idx := 0
+	if n, ok := parseIndex(parts[len(parts)-1]); ok && len(parts) > 1 {
+		idx = n
+		parts = parts[:len(parts)-1]
+	}
+	name := strings.Join(parts, ".")
+	if lowerKey {
+		name = strings.ToLower(name)
+	}
+	return indexOf(values[name], idx)
+}
+
+// lookupParameter resolves key, key.nested or key.[n] against the transformer parameters.
+// Strings render as-is; other values render as JSON.
+func lookupParameter(params map[string]any, parts []string) (string, bool) {
+	var v any = params
+	for _, part := range parts {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[part]
+			if !ok {
+				return "", false
+			}
+			v = child
+		case []any:
+			idx, ok := parseIndex(part)
+			if !ok || idx >= len(node) {
+				return "", false
+			}
+			v = node[idx]
+		default:
+			return "", false
+		}
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// parseIndex parses a Handlebars array index segment like [0].
+func parseIndex(s string) (int, bool) {
+	if len(s) < 3 || s[0] != '[' || s[len(s)-1] != ']' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(s[1 : len(s)-1])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+func indexOf(values []string, idx int) (string, bool) {
+	if idx >= len(values) {
+		return "", false
+	}
+	return values[idx], true
+}
@@ -0,0 +1,152 @@
+package templating
+
+import (
+	"encoding/json"
+	"math/rand"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func testData() *RequestData {
+	return &RequestData{
+		Method:        "GET",
+		URL:           "/workspaces/demo/objects/42?id=abc&tag=x&tag=y",
+		Path:          "/workspaces/demo/objects/42",
+		PathSegments:  []string{"workspaces", "demo", "objects", "42"},
+		PathVariables: map[string]string{"workspaceId": "demo", "objectId": "42"},
+		Query:         map[string][]string{"id": {"abc"}, "tag": {"x", "y"}},
+		Headers:       map[string][]string{"x-request-id": {"req-1"}, "x-trace.id": {"t-1"}},
+		URLGroups:     []string{"/workspaces/demo/objects/42", "demo", "42"},
+		Body:          `{"name":"foo"}`,
+		Parameters:    map[string]any{"greeting": "Hello", "limits": map[string]any{"max": 10.0}, "tags": []any{"a", "b"}},
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "no placeholders", template: `{"ok":true}`, expected: `{"ok":true}`},
+		{name: "method", template: "{{request.method}}", expected: "GET"},
+		{name: "url", template: "{{request.url}}", expected: "/workspaces/demo/objects/42?id=abc&tag=x&tag=y"},
+		{name: "path", template: "{{request.path}}", expected: "/workspaces/demo/objects/42"},
+		{name: "path segment by index", template: "{{request.path.[1]}}", expected: "demo"},
+		{name: "path segment out of range", template: "{{request.path.[9]}}", expected: "{{request.path.[9]}}"},
+		{name: "path variable", template: "{{request.path.objectId}}", expected: "42"},
+		{name: "path segments alias", template: "{{request.pathSegments.[3]}}", expected: "42"},
+		{name: "regex group by index", template: "{{request.groups.[2]}}", expected: "42"},
+		{name: "regex whole match", template: "{{request.groups.[0]}}", expected: "/workspaces/demo/objects/42"},
+		{name: "regex group out of range", template: "{{request.groups.[3]}}", expected: "{{request.groups.[3]}}"},
+		{name: "named regex group", template: "{{request.groups.workspaceId}}", expected: "demo"},
+		{name: "query", template: `{"id":"{{request.query.id}}"}`, expected: `{"id":"abc"}`},
+		{name: "query second value", template: "{{request.query.tag.[1]}}", expected: "y"},
+		{name: "missing query", template: "{{request.query.nope}}", expected: "{{request.query.nope}}"},
+		{name: "header case-insensitive", template: "{{request.headers.X-Request-Id}}", expected: "req-1"},
+		{name: "header name containing a dot", template: "{{request.headers.X-Trace.Id}}", expected: "t-1"},
+		{name: "body", template: "echo: {{request.body}}", expected: `echo: {"name":"foo"}`},
+		{name: "whitespace inside braces", template: "{{ request.query.id }}", expected: "abc"},
+		{name: "parameter", template: "{{parameters.greeting}}, world", expected: "Hello, world"},
+		{name: "nested parameter", template: "{{parameters.limits.max}}", expected: "10"},
+		{name: "parameter array element", template: "{{parameters.tags.[1]}}", expected: "b"},
+		{name: "object parameter renders as JSON", template: "{{parameters.limits}}", expected: `{"max":10}`},
+		{name: "missing parameter left untouched", template: "{{parameters.nope}}", expected: "{{parameters.nope}}"},
+		{name: "unknown root left untouched", template: "{{user.name}}", expected: "{{user.name}}"},
+		{name: "unknown helper left untouched", template: "{{#each items}}", expected: "{{#each items}}"},
+		{
+			name:     "multiple placeholders",
+			template: "{{request.path.workspaceId}}/{{request.path.objectId}}/{{request.query.id}}",
+			expected: "demo/42/abc",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.template, testData()); got != tt.expected {
+				t.Errorf("Render(%q) = %q, want %q", tt.template, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	var doc any
+	if err := json.Unmarshal([]byte(`{"id": "{{request.query.id}}", "count": 1, "items": ["{{request.path.[0]}}", true]}`), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := json.Marshal(RenderJSON(doc, testData()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"count":1,"id":"abc","items":["workspaces",true]}`
+	if string(got) != expected {
+		t.Errorf("got %s, want %s", got, expected)
+	}
+}
+
+func TestRenderHelpers(t *testing.T) {
+	data := testData()
+	data.Now = time.Date(2025, 3, 31, 14, 5, 9, 0, time.UTC)
+	data.Random = rand.New(rand.NewSource(1)).Intn
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{name: "now defaults to ISO 8601", template: "{{now}}", expected: "2025-03-31T14:05:09Z"},
+		{name: "now with format", template: "{{now format='yyyy-MM-dd'}}", expected: "2025-03-31"},
+		{name: "now with day offset", template: "{{now format='yyyy-MM-dd' offset='1 days'}}", expected: "2025-04-01"},
+		{name: "now with negative offset", template: "{{now offset='-3 hours'}}", expected: "2025-03-31T11:05:09Z"},
+		{name: "now with month offset", template: "{{now format='dd.MM.yyyy' offset='1 months'}}", expected: "01.05.2025"},
+		{name: "now with quoted literal", template: `{{now format="yyyy-MM-dd'T'HH:mm"}}`, expected: "2025-03-31T14:05"},
+		{name: "now with Go layout digits in a literal", template: `{{now format="dd 'at 1' HH"}}`, expected: "31 at 1 14"},
+		{name: "now with Go layout words in a literal", template: `{{now format="'Monday, Jan' yyyy"}}`, expected: "Monday, Jan 2025"},
+		{name: "now with unmapped letters", template: `{{now format="yyyy-MM-dd Q"}}`, expected: "2025-03-31 Q"},
+		{name: "now with escaped quote", template: `{{now format="HH 'o''clock'"}}`, expected: "14 o'clock"},
+		{name: "now as epoch millis", template: "{{now format='epoch'}}", expected: "1743429909000"},
+		{name: "now as unix seconds", template: "{{now format='unix'}}", expected: "1743429909"},
+		{name: "invalid offset left untouched", template: "{{now offset='soon'}}", expected: "{{now offset='soon'}}"},
+		{name: "unknown random type left untouched", template: "{{randomValue type='COLOR'}}", expected: "{{randomValue type='COLOR'}}"},
+		{name: "malformed arguments left untouched", template: "{{now format}}", expected: "{{now format}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.template, data); got != tt.expected {
+				t.Errorf("Render(%q) = %q, want %q", tt.template, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRenderRandomValue(t *testing.T) {
+	uuidRe := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	render := func(template string, seed int64) string {
+		data := testData()
+		data.Random = rand.New(rand.NewSource(seed)).Intn
+		return Render(template, data)
+	}
+
+	uuid := render("{{randomValue type='UUID'}}", 1)
+	if !uuidRe.MatchString(uuid) {
+		t.Errorf("UUID = %q, want a version 4 UUID", uuid)
+	}
+	if again := render("{{randomValue type='UUID'}}", 1); again != uuid {
+		t.Errorf("same seed rendered %q, then %q", uuid, again)
+	}
+	if other := render("{{randomValue type='UUID'}}", 2); other == uuid {
+		t.Errorf("different seeds both rendered %q", uuid)
+	}
+	if n := render("{{randomValue type='NUMBER'}}", 1); !regexp.MustCompile(`^[0-9]{10}$`).MatchString(n) {
+		t.Errorf("NUMBER = %q, want 10 digits", n)
+	}
+	if hex := render("{{randomValue type='HEXADECIMAL' length=4}}", 1); !regexp.MustCompile(`^[0-9a-f]{4}$`).MatchString(hex) {
+		t.Errorf("HEXADECIMAL = %q, want 4 hex digits", hex)
+	}
+	if two := render("{{randomValue type='UUID'}} {{randomValue type='UUID'}}", 1); two[:36] == two[37:] {
+		t.Errorf("placeholders in one template repeated %q", two[:36])
+	}
+}
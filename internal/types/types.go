@@ -3,9 +3,19 @@ package types
 
 import (
 	"encoding/json"
+	"goodmock/internal/proxy"
+	"io"
+	"math/rand"
 	"sync"
 )
 
+// ScenarioStarted is the state every scenario begins in (and returns to on reset).
+const ScenarioStarted = "Started"
+
+// DefaultPriority is the priority of mappings that don't declare one (WireMock's default).
+// Lower numbers take precedence.
+const DefaultPriority = 5
+
 // WiremockMappings represents the root structure of a Wiremock mapping file
 type WiremockMappings struct {
 	Mappings []Mapping `json:"mappings"`
@@ -13,12 +23,18 @@ type WiremockMappings struct {
 
 // Mapping represents a single request-response mapping
 type Mapping struct {
-	Name                  string   `json:"name,omitempty"`
-	ScenarioName          string   `json:"scenarioName,omitempty"`
-	RequiredScenarioState string   `json:"requiredScenarioState,omitempty"`
-	NewScenarioState      string   `json:"newScenarioState,omitempty"`
-	Request               Request  `json:"request"`
-	Response              Response `json:"response"`
+	ID                    string          `json:"id,omitempty"`
+	UUID                  string          `json:"uuid,omitempty"`
+	Name                  string          `json:"name,omitempty"`
+	Priority              *int            `json:"priority,omitempty"`
+	Persistent            bool            `json:"persistent,omitempty"`
+	ScenarioName          string          `json:"scenarioName,omitempty"`
+	RequiredScenarioState string          `json:"requiredScenarioState,omitempty"`
+	NewScenarioState      string          `json:"newScenarioState,omitempty"`
+	Request               Request         `json:"request"`
+	Response              Response        `json:"response"`
+	Metadata              json.RawMessage `json:"metadata,omitempty"` // free-form tags, see find-by-metadata
+	SourceFile            string          `json:"-"`                  // mapping file it was loaded from, if any
 }
 
 // Request represents the request matching criteria
@@ -26,16 +42,42 @@ type Request struct {
 	URL             string                       `json:"url,omitempty"`
 	URLPath         string                       `json:"urlPath,omitempty"`
 	URLPattern      string                       `json:"urlPattern,omitempty"`
-	Method          string                       `json:"method"`
+	URLPathPattern  string                       `json:"urlPathPattern,omitempty"`
+	URLPathTemplate string                       `json:"urlPathTemplate,omitempty"`
+	URLPathGlob     string                       `json:"urlPathGlob,omitempty"`
+	Method          string                       `json:"method"` // a method, a comma-separated list, or ANY
+	MethodPattern   string                       `json:"methodPattern,omitempty"`
 	QueryParameters map[string]QueryParamMatcher `json:"queryParameters,omitempty"`
+	FormParameters  map[string]QueryParamMatcher `json:"formParameters,omitempty"`
 	BodyPatterns    []BodyPattern                `json:"bodyPatterns,omitempty"`
 	Headers         map[string]HeaderMatcher     `json:"headers,omitempty"`
+	Cookies         map[string]HeaderMatcher     `json:"cookies,omitempty"`
+	BasicAuth       *BasicAuthCredentials        `json:"basicAuthCredentials,omitempty"`
+	Scheme          string                       `json:"scheme,omitempty"` // from X-Forwarded-Proto, "http" when absent
+	Host            string                       `json:"host,omitempty"`   // port is ignored unless the matcher includes one
+}
+
+// BasicAuthCredentials requires an exact HTTP Basic Authorization header.
+type BasicAuthCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
-// QueryParamMatcher represents a query parameter matcher
+// QueryParamMatcher represents a query parameter matcher.
+// Absent takes precedence over any other field when set. Matches and Contains
+// succeed if any value of the parameter satisfies them. CaseInsensitive applies
+// to the equalTo, hasExactly, includes and contains comparisons. HasExactly ignores
+// the order of repeated values unless OrderSensitive is set; Includes only requires
+// its values to be among them.
 type QueryParamMatcher struct {
-	EqualTo    string         `json:"equalTo,omitempty"`
-	HasExactly []EqualMatcher `json:"hasExactly,omitempty"`
+	EqualTo         string         `json:"equalTo,omitempty"`
+	HasExactly      []EqualMatcher `json:"hasExactly,omitempty"`
+	Includes        []EqualMatcher `json:"includes,omitempty"`
+	OrderSensitive  bool           `json:"orderSensitive,omitempty"`
+	Matches         string         `json:"matches,omitempty"`
+	Contains        string         `json:"contains,omitempty"`
+	Absent          bool           `json:"absent,omitempty"`
+	CaseInsensitive bool           `json:"caseInsensitive,omitempty"`
 }
 
 // EqualMatcher represents an equality matcher
@@ -46,45 +88,224 @@ type EqualMatcher struct {
 // BodyPattern represents a request body pattern matcher
 type BodyPattern struct {
 	EqualToJSON         json.RawMessage `json:"equalToJson,omitempty"`
+	EqualToJSONLiteral  bool            `json:"equalToJsonLiteral,omitempty"` // a string equalToJson is a JSON string value, not encoded JSON
 	IgnoreArrayOrder    *bool           `json:"ignoreArrayOrder,omitempty"`
 	IgnoreExtraElements *bool           `json:"ignoreExtraElements,omitempty"`
+	MatchesJsonPath     string          `json:"matchesJsonPath,omitempty"`
+	MatchesJsonSchema   json.RawMessage `json:"matchesJsonSchema,omitempty"`
+	EqualToXML          string          `json:"equalToXml,omitempty"`
+	EqualTo             string          `json:"equalTo,omitempty"`
+	CaseInsensitive     bool            `json:"caseInsensitive,omitempty"`
+	Contains            string          `json:"contains,omitempty"`
+	DoesNotContain      string          `json:"doesNotContain,omitempty"`
+	Matches             string          `json:"matches,omitempty"`
+	DoesNotMatch        string          `json:"doesNotMatch,omitempty"`
+	AnythingButEmpty    bool            `json:"anythingButEmpty,omitempty"`
+	BinaryEqualTo       string          `json:"binaryEqualTo,omitempty"`
+	And                 []BodyPattern   `json:"and,omitempty"` // all must match
+	Or                  []BodyPattern   `json:"or,omitempty"`  // at least one must match
+	// Field-level assertions on a JSON body, all of which must hold
+	JsonPathMatchers []JsonPathMatcher `json:"jsonPathMatchers,omitempty"`
+	// Trims leading/trailing whitespace on both sides before an equalTo comparison
+	IgnoreSurroundingWhitespace bool `json:"ignoreSurroundingWhitespace,omitempty"`
 }
 
-// HeaderMatcher represents a header matcher
-type HeaderMatcher struct {
+// JsonPathMatcher asserts the value of one field of a JSON request body. It matches
+// when a node selected by Path satisfies every matcher set on it; with none set, the
+// path only has to select something. Non-string values are compared as compact JSON.
+type JsonPathMatcher struct {
+	Path     string `json:"path"`
 	EqualTo  string `json:"equalTo,omitempty"`
 	Contains string `json:"contains,omitempty"`
+	Matches  string `json:"matches,omitempty"`
+}
+
+// HeaderMatcher represents a header matcher.
+// Absent takes precedence over any other field when set. A header sent several
+// times matches if any of its values does, or only if all do with MatchAllValues.
+// CaseInsensitive applies to equalTo and contains, not to regexes.
+type HeaderMatcher struct {
+	EqualTo         string `json:"equalTo,omitempty"`
+	Contains        string `json:"contains,omitempty"`
+	Matches         string `json:"matches,omitempty"`
+	DoesNotMatch    string `json:"doesNotMatch,omitempty"`
+	Absent          bool   `json:"absent,omitempty"`
+	MatchAllValues  bool   `json:"matchAllValues,omitempty"`
+	CaseInsensitive bool   `json:"caseInsensitive,omitempty"`
 }
 
 // Response represents the stub response
 type Response struct {
-	Status       int            `json:"status"`
-	Body         string         `json:"body,omitempty"`
-	JsonBody     any            `json:"jsonBody,omitempty"`
-	Headers      map[string]any `json:"headers,omitempty"`
-	ProxyBaseUrl string         `json:"proxyBaseUrl,omitempty"`
+	Status                 int                `json:"status"`
+	StatusMessage          string             `json:"statusMessage,omitempty"` // reason phrase; fasthttp's default when empty
+	Body                   string             `json:"body,omitempty"`
+	JsonBody               any                `json:"jsonBody,omitempty"`
+	Headers                map[string]any     `json:"headers,omitempty"`
+	ProxyBaseUrl           string             `json:"proxyBaseUrl,omitempty"`
+	FixedDelayMilliseconds int                `json:"fixedDelayMilliseconds,omitempty"`
+	DelayDistribution      *DelayDistribution `json:"delayDistribution,omitempty"`
+	Fault                  string             `json:"fault,omitempty"`
+	BodyFileName           string             `json:"bodyFileName,omitempty"`
+	Base64Body             string             `json:"base64Body,omitempty"`
+	Gzip                   bool               `json:"gzip,omitempty"` // compress for clients accepting gzip
+	// Streams the body in chunks spread over a duration, after any other delay
+	ChunkedDribbleDelay *ChunkedDribbleDelay `json:"chunkedDribbleDelay,omitempty"`
+	// Response templating only runs when this lists "response-template", as in WireMock
+	Transformers []string `json:"transformers,omitempty"`
+	// Exposed to response templates as {{parameters.<key>}}
+	TransformerParameters map[string]any `json:"transformerParameters,omitempty"`
+	// Served one per match in order, then the last one for every later match
+	Sequence []Response `json:"sequence,omitempty"`
+	// One entry is picked at random per match, in proportion to its Weight
+	WeightedResponses []Response `json:"weightedResponses,omitempty"`
+	// Relative weight of a weightedResponses entry; 1 when unset
+	Weight *float64 `json:"weight,omitempty"`
+	// Response headers to suppress, case-insensitively, e.g. a stale recorded Set-Cookie
+	RemoveHeaders []string `json:"removeHeaders,omitempty"`
+	// Applied to the forwarded request when ProxyBaseUrl is set
+	AdditionalProxyRequestHeaders map[string]string `json:"additionalProxyRequestHeaders,omitempty"`
+	RemoveProxyRequestHeaders     []string          `json:"removeProxyRequestHeaders,omitempty"`
+}
+
+// Scenario describes a scenario for GET /__admin/scenarios, shaped like WireMock's.
+type Scenario struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	State          string   `json:"state"`
+	PossibleStates []string `json:"possibleStates"`
+}
+
+// GlobalSettings are server-wide settings managed via /__admin/settings.
+// The delays apply to every matched response, on top of the stub's own.
+type GlobalSettings struct {
+	FixedDelay        int                `json:"fixedDelay,omitempty"` // milliseconds
+	DelayDistribution *DelayDistribution `json:"delayDistribution,omitempty"`
+}
+
+// DelayDistribution describes a random response delay in milliseconds.
+// "uniform" samples between Lower and Upper (inclusive); "lognormal" samples
+// around Median with the given Sigma, optionally capped at MaxValue.
+type DelayDistribution struct {
+	Type     string  `json:"type"`
+	Lower    int     `json:"lower,omitempty"`
+	Upper    int     `json:"upper,omitempty"`
+	Median   float64 `json:"median,omitempty"`
+	Sigma    float64 `json:"sigma,omitempty"`
+	MaxValue float64 `json:"maxValue,omitempty"`
+}
+
+// ChunkedDribbleDelay splits a response body into NumberOfChunks pieces written at
+// even intervals over TotalDurationMs milliseconds, like a slow streaming backend.
+type ChunkedDribbleDelay struct {
+	NumberOfChunks  int `json:"numberOfChunks"`
+	TotalDurationMs int `json:"totalDuration"`
 }
 
 // Server holds the mock server state
 type Server struct {
-	Mu                 sync.RWMutex
-	Mappings           []Mapping
-	ProxyHost          string
-	RefererPath        string
-	Verbose            bool
-	BinaryContentTypes []string
+	Mu                        sync.RWMutex
+	Mappings                  []Mapping
+	Scenarios                 map[string]string // scenario name -> current state; missing means ScenarioStarted
+	SequencePositions         map[*Response]int // Response.Sequence (by first entry) -> index of the next entry to serve
+	Settings                  GlobalSettings    // set via /__admin/settings
+	ProxyHost                 string
+	RefererPath               string
+	ProxyClient               *proxy.Client // forwards requests for stubs with a proxyBaseUrl
+	Verbose                   bool
+	JSONLogs                  bool // LOG_FORMAT=json: mismatches and verbose requests print as JSON lines
+	BinaryContentTypes        []string
+	FilesDir                  string // root for Response.BodyFileName
+	MappingsDir               string // MAPPINGS_DIR; target of /__admin/mappings/save
+	RandMu                    sync.Mutex
+	Rand                      *rand.Rand // shared source for random delays; seedable for reproducible runs
+	JournalMu                 sync.Mutex
+	Journal                   []ServeEvent // oldest first, capped at JournalSize
+	JournalSize               int          // 0 disables the request journal
+	GzipOver                  int          // gzip response bodies above this size for all stubs; 0 leaves it to Response.Gzip
+	DefaultResponse           *Response    // served when no stub matches; nil keeps the 404
+	NumberTolerance           float64      // JSON numbers this close compare equal in equalToJson; 0 means exact
+	NormalizeTrailingSlash    bool         // url and urlPath match paths with or without a trailing slash
+	CaseInsensitiveQueryNames bool         // query parameter names match regardless of case, e.g. limit and Limit
+	MaxRequestBodySize        int          // 413 for larger request bodies after decompression; 0 means no limit
+	Diagnostics               bool         // add X-GoodMock-* headers naming the stub that served each response
+	AdminToken                string       // required on /__admin requests when set, except health checks
+	RequestLog                io.Writer    // receives one JSON line per handled stub request; nil disables
+}
+
+// ServeEvent is a request journal entry, shaped like WireMock's.
+type ServeEvent struct {
+	ID          string        `json:"id"`
+	Request     LoggedRequest `json:"request"`
+	WasMatched  bool          `json:"wasMatched"`
+	StubMapping *Mapping      `json:"stubMapping,omitempty"`
+	NearMiss    *NearMiss     `json:"-"` // set for unmatched requests when some stub came close
+}
+
+// NearMiss is the closest stub for an unmatched request and why it didn't match.
+type NearMiss struct {
+	Request     LoggedRequest `json:"request"`
+	StubMapping *Mapping      `json:"stubMapping"`
+	MatchResult MatchDiff     `json:"matchResult"`
+}
+
+// BodyFieldDiff is one field where a JSON body differs from an equalToJson pattern.
+// Kind is "changed", "missing", "unexpected" or "type"; Expected and Actual hold
+// compact JSON values, or type names for "type".
+type BodyFieldDiff struct {
+	Kind     string `json:"kind"`
+	Path     string `json:"path"` // e.g. $.items[0].name
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// MatchDiff is the machine-readable part of a MatchResult.
+type MatchDiff struct {
+	URLMatch       bool            `json:"urlMatch"`
+	MethodMatch    bool            `json:"methodMatch"`
+	QueryMatch     bool            `json:"queryMatch"`
+	BodyMatch      bool            `json:"bodyMatch"`
+	HeaderMatch    bool            `json:"headerMatch"`
+	ScenarioMatch  bool            `json:"scenarioMatch"`
+	QueryDiffs     []string        `json:"queryDiffs,omitempty"`
+	BodyDiff       string          `json:"bodyDiff,omitempty"`
+	BodyFieldDiffs []BodyFieldDiff `json:"bodyFieldDiffs,omitempty"`
+	HeaderDiffs    []string        `json:"headerDiffs,omitempty"`
+	ScenarioDiff   string          `json:"scenarioDiff,omitempty"`
+}
+
+// LoggedRequest is an incoming request as it was received, before header rewriting.
+type LoggedRequest struct {
+	URL              string              `json:"url"`
+	Method           string              `json:"method"`
+	Headers          map[string][]string `json:"headers"`
+	Body             string              `json:"body"`
+	LoggedDate       int64               `json:"loggedDate"` // Unix milliseconds
+	LoggedDateString string              `json:"loggedDateString"`
 }
 
 // MatchResult holds the result of matching a request against a stub
 type MatchResult struct {
-	Matched     bool
-	Mapping     *Mapping
-	URLMatch    bool
-	MethodMatch bool
-	QueryMatch  bool
-	BodyMatch   bool
-	HeaderMatch bool
-	QueryDiffs  []string
-	BodyDiff    string
-	HeaderDiffs []string
+	Matched        bool
+	Mapping        *Mapping
+	URLMatch       bool
+	MethodMatch    bool
+	QueryMatch     bool
+	BodyMatch      bool
+	HeaderMatch    bool
+	ScenarioMatch  bool
+	QueryDiffs     []string
+	BodyDiff       string
+	BodyFieldDiffs []BodyFieldDiff // field-level detail for an equalToJson BodyDiff
+	HeaderDiffs    []string
+	ScenarioDiff   string
+	PathVariables  map[string]string // variables extracted by urlPathTemplate, or named urlPattern groups
+	URLGroups      []string          // urlPattern/urlPathPattern capture groups; [0] is the whole match
+	Score          int               // specificity of the match, or how close a near miss came
+}
+
+// RewriteRule replaces every occurrence of Find with Replace in recorded response
+// bodies, e.g. an upstream host embedded in absolute URLs.
+type RewriteRule struct {
+	Find    string
+	Replace string
 }
@@ -2,16 +2,16 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"goodmock/internal/common"
+	"goodmock/internal/proxy"
 	"goodmock/internal/pureproxy"
 	"goodmock/internal/record"
 	"goodmock/internal/server"
-	"goodmock/internal/types"
 	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 
 	"github.com/valyala/fasthttp"
 )
@@ -30,15 +30,17 @@ func main() {
 		record.RunRecord()
 	case "proxy":
 		pureproxy.RunProxy()
+	case "validate":
+		os.Exit(runValidate())
 	default:
-		fmt.Fprintf(os.Stderr, "Unknown mode: %s\nUsage: goodmock <mode>\nModes: replay, record, proxy\n", mode)
+		fmt.Fprintf(os.Stderr, "Unknown mode: %s\nUsage: goodmock <mode>\nModes: replay, record, proxy, validate\n", mode)
 		os.Exit(1)
 	}
 }
 
 func runReplay() {
 	port := common.GetPort()
-	const maxRequestBodySize = 16 * 1024 * 1024
+	maxRequestBodySize := common.MaxRequestBodySize()
 
 	proxyHost := os.Getenv("PROXY_HOST")
 	if proxyHost == "" {
@@ -53,34 +55,42 @@ func runReplay() {
 	verbose := common.IsVerbose()
 	binaryContentTypes := common.ParseBinaryContentTypes()
 	s := server.NewServer(proxyHost, refererPath, verbose, binaryContentTypes)
+	s.FilesDir = common.FilesDir()
+	s.JournalSize = common.RequestJournalSize()
+	s.GzipOver = common.GzipResponsesOver()
+	s.DefaultResponse = common.DefaultResponse()
+	s.MaxRequestBodySize = maxRequestBodySize
+	s.NumberTolerance = common.JSONNumberTolerance()
+	s.NormalizeTrailingSlash = common.NormalizeTrailingSlash()
+	s.CaseInsensitiveQueryNames = common.CaseInsensitiveQueryNames()
+	s.Diagnostics = common.Diagnostics()
+	s.AdminToken = common.AdminToken()
+	s.JSONLogs = common.JSONLogFormat()
+	var requestLog *server.RequestLogFile
+	if path := common.RequestLogFile(); path != "" {
+		var err error
+		requestLog, err = server.OpenRequestLog(path, server.DefaultRequestLogFlushInterval)
+		if err != nil {
+			log.Fatalf("Could not open REQUEST_LOG_FILE: %v", err)
+		}
+		s.RequestLog = requestLog
+	}
+	s.ProxyClient = proxy.NewClient(common.ProxyConfig())
+	if seed, ok := common.RandomSeed(); ok {
+		server.SeedRandom(s, seed)
+	}
 
 	// Load mappings from MAPPINGS_DIR env if set
 	mappingsDir := os.Getenv("MAPPINGS_DIR")
 	if mappingsDir != "" {
-		entries, err := os.ReadDir(mappingsDir)
-		if err != nil {
-			log.Printf("Warning: Could not read mappings directory %s: %v", mappingsDir, err)
-		} else {
-			for _, entry := range entries {
-				if entry.IsDir() {
-					continue
-				}
-				if strings.HasSuffix(entry.Name(), ".json") {
-					filePath := mappingsDir + "/" + entry.Name()
-					data, err := os.ReadFile(filePath)
-					if err != nil {
-						log.Printf("Warning: Could not read mapping file %s: %v", filePath, err)
-						continue
-					}
-					var wm types.WiremockMappings
-					if err := json.Unmarshal(data, &wm); err != nil {
-						log.Printf("Warning: Could not parse mapping file %s: %v", filePath, err)
-					} else {
-						server.LoadMappings(s, wm)
-						log.Printf("Loaded %d mappings from %s", len(wm.Mappings), filePath)
-					}
-				}
-			}
+		s.MappingsDir = mappingsDir
+		server.LoadMappingsDir(s, mappingsDir)
+		if shadowed := server.ShadowedMappings(s.Mappings, 0); len(shadowed) > 0 && common.StrictMappings() {
+			log.Fatalf("STRICT_MAPPINGS: %d shadowed mappings in %s", len(shadowed), mappingsDir)
+		}
+		if common.WatchMappings() {
+			log.Printf("Watching %s for mapping changes", mappingsDir)
+			go server.WatchMappingsDir(s, mappingsDir, server.DefaultWatchInterval, nil)
 		}
 	}
 
@@ -99,11 +109,63 @@ func runReplay() {
 	httpServer := &fasthttp.Server{
 		Handler:            func(ctx *fasthttp.RequestCtx) { server.HandleRequest(s, ctx) },
 		MaxRequestBodySize: maxRequestBodySize,
-		ErrorHandler: func(ctx *fasthttp.RequestCtx, err error) {
-			ctx.SetStatusCode(fasthttp.StatusBadRequest)
-			ctx.SetBodyString(err.Error())
-		},
+		ErrorHandler:       server.HandleServerError,
+	}
+
+	server.ApplyTuning(httpServer, common.ServerTuning())
+
+	// On SIGINT or SIGTERM, let in-flight requests finish and flush the request log before exiting
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		if err := httpServer.Shutdown(); err != nil {
+			log.Printf("Warning: shutdown: %v", err)
+		}
+		if requestLog != nil {
+			if err := requestLog.Close(); err != nil {
+				log.Printf("Warning: could not write request log: %v", err)
+			}
+		}
+		close(stopped)
+	}()
+
+	certFile, keyFile := common.TLSFiles()
+	if err := server.ListenAndServe(httpServer, addr, certFile, keyFile); err != nil {
+		log.Fatal(err)
+	}
+	<-stopped
+}
+
+// runValidate checks the mapping files in MAPPINGS_DIR (or the directory given after
+// the mode) without starting the server, prints each problem and returns the exit code.
+func runValidate() int {
+	dir := os.Getenv("MAPPINGS_DIR")
+	if len(os.Args) > 2 {
+		dir = os.Args[2]
+	}
+	if dir == "" {
+		fmt.Fprintf(os.Stderr, "Usage: goodmock validate <dir> (or set MAPPINGS_DIR)\n")
+		return 2
 	}
 
-	log.Fatal(httpServer.ListenAndServe(addr))
+	problems, checked, err := server.ValidateMappingsDir(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read mappings directory %s: %v\n", dir, err)
+		return 2
+	}
+	for _, p := range problems {
+		if p.Mapping != "" {
+			fmt.Printf("%s: %s: %s\n", p.File, p.Mapping, p.Message)
+		} else {
+			fmt.Printf("%s: %s\n", p.File, p.Message)
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d problems in %d mappings\n", len(problems), checked)
+		return 1
+	}
+	fmt.Printf("%d mappings OK\n", checked)
+	return 0
 }